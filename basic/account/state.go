@@ -0,0 +1,143 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrBadNonce            = errors.New("transaction nonce is not the next one of the sender")
+	ErrInsufficientBalance = errors.New("sender balance is not enough for amount and fee")
+	ErrBalanceOverflow     = errors.New("balance overflows")
+)
+
+// State holds the balance and the next nonce of every account.
+type State struct {
+	balances map[string]uint64
+	nonces   map[string]uint64
+	mutex    sync.RWMutex
+}
+
+func NewState(alloc map[string]uint64) *State {
+	state := &State{
+		balances: make(map[string]uint64),
+		nonces:   make(map[string]uint64),
+	}
+	for address, balance := range alloc {
+		state.balances[address] = balance
+	}
+	return state
+}
+
+// Reset replaces the balances by alloc and starts every nonce from 0 again.
+func (state *State) Reset(alloc map[string]uint64) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	state.balances = make(map[string]uint64)
+	state.nonces = make(map[string]uint64)
+	for address, balance := range alloc {
+		state.balances[address] = balance
+	}
+}
+
+func (state *State) Balance(address string) uint64 {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return state.balances[address]
+}
+
+// Nonce is the nonce the next transaction of address must carry.
+func (state *State) Nonce(address string) uint64 {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return state.nonces[address]
+}
+
+// Balances returns a copy of all non-zero balances.
+func (state *State) Balances() map[string]uint64 {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	balances := make(map[string]uint64, len(state.balances))
+	for address, balance := range state.balances {
+		balances[address] = balance
+	}
+	return balances
+}
+
+// Validate checks tx could be applied now, without changing the state.
+func (state *State) Validate(tx *Transaction) error {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	return state.validate(tx)
+}
+
+func (state *State) validate(tx *Transaction) error {
+	if err := tx.Verify(); err != nil {
+		return err
+	}
+	if tx.Nonce != state.nonces[tx.From] {
+		return fmt.Errorf("%w: want %d, got %d", ErrBadNonce, state.nonces[tx.From], tx.Nonce)
+	}
+	total := tx.Amount + tx.Fee
+	if total < tx.Amount || state.balances[tx.From] < total {
+		return ErrInsufficientBalance
+	}
+	if state.balances[tx.To]+tx.Amount < state.balances[tx.To] {
+		return fmt.Errorf("receiver %w", ErrBalanceOverflow)
+	}
+	return nil
+}
+
+// Apply transfers the amount and pays the fee to proposer. A rejected transaction changes nothing,
+// so a double-spend fails on its nonce or on the balance left by the first spend.
+func (state *State) Apply(tx *Transaction, proposer string) error {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if err := state.validate(tx); err != nil {
+		return err
+	}
+	// Move the balances on a copy, the proposer may be the sender or the receiver.
+	addresses := []string{tx.From, tx.To, proposer}
+	balances := make(map[string]uint64, len(addresses))
+	for _, address := range addresses {
+		balances[address] = state.balances[address]
+	}
+	balances[tx.From] -= tx.Amount + tx.Fee
+	balances[tx.To] += tx.Amount
+	if balances[proposer]+tx.Fee < balances[proposer] {
+		return fmt.Errorf("proposer %w", ErrBalanceOverflow)
+	}
+	balances[proposer] += tx.Fee
+	for address, balance := range balances {
+		if balance == 0 {
+			delete(state.balances, address)
+		} else {
+			state.balances[address] = balance
+		}
+	}
+	state.nonces[tx.From]++
+	return nil
+}
+
+// ApplyAll applies txs in order and returns the error of each, nil for the applied ones.
+func (state *State) ApplyAll(txs []*Transaction, proposer string) []error {
+	errs := make([]error, len(txs))
+	for i, tx := range txs {
+		errs[i] = state.Apply(tx, proposer)
+	}
+	return errs
+}
+
+// Accounts lists the addresses with a balance, sorted.
+func (state *State) Accounts() []string {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+	addresses := make([]string, 0, len(state.balances))
+	for address := range state.balances {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
@@ -0,0 +1,51 @@
+package account
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestApplyRejectsProposerOverflow(t *testing.T) {
+	sender, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, proposer := "0000000000000000000000000000000000000001", "0000000000000000000000000000000000000002"
+	state := NewState(map[string]uint64{sender.Address: 100, proposer: math.MaxUint64})
+	tx, err := NewTransaction(sender, receiver, 10, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = state.Apply(tx, proposer); !errors.Is(err, ErrBalanceOverflow) {
+		t.Fatalf("apply paying a full proposer returned %v", err)
+	}
+	if state.Balance(sender.Address) != 100 || state.Balance(receiver) != 0 || state.Nonce(sender.Address) != 0 {
+		t.Fatal("rejected transaction changed the state")
+	}
+	if err = state.Apply(tx, sender.Address); err != nil {
+		t.Fatal(err)
+	}
+	if state.Balance(sender.Address) != 90 || state.Balance(receiver) != 10 {
+		t.Fatalf("sender proposing its own transaction has %d, receiver %d", state.Balance(sender.Address), state.Balance(receiver))
+	}
+}
+
+func TestToMempoolRange(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transaction{From: key.Address, Nonce: math.MaxInt64 + 1}
+	if _, err = tx.ToMempool(tx); !errors.Is(err, ErrNonceOverflow) {
+		t.Fatalf("nonce beyond int64 returned %v", err)
+	}
+	tx = &Transaction{From: key.Address, Nonce: 1, Fee: math.MaxUint64}
+	wrapped, err := tx.ToMempool(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped.Priority != math.MaxInt64 {
+		t.Fatalf("fee of %d is prioritized %d", tx.Fee, wrapped.Priority)
+	}
+}
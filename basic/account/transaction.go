@@ -0,0 +1,123 @@
+package account
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/mempool"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"math"
+)
+
+// AddressLength is the number of hex characters of an address.
+const AddressLength = 40
+
+// Address is derived from the public key, so the key proves the ownership.
+func Address(publicKey ed25519.PublicKey) string {
+	return node.Hash(publicKey)[:AddressLength]
+}
+
+type Key struct {
+	Address    string
+	PublicKey  ed25519.PublicKey
+	PrivateKey ed25519.PrivateKey
+}
+
+func NewKey() (*Key, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		Address:    Address(publicKey),
+		PublicKey:  publicKey,
+		PrivateKey: privateKey,
+	}, nil
+}
+
+// Transaction moves Amount from From to To, Fee goes to the proposer of the block.
+// Nonce counts the transactions of From, starting from 0.
+type Transaction struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Amount    uint64 `json:"amount"`
+	Fee       uint64 `json:"fee"`
+	Nonce     uint64 `json:"nonce"`
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+func NewTransaction(key *Key, to string, amount uint64, fee uint64, nonce uint64) (*Transaction, error) {
+	tx := &Transaction{
+		From:      key.Address,
+		To:        to,
+		Amount:    amount,
+		Fee:       fee,
+		Nonce:     nonce,
+		PublicKey: hex.EncodeToString(key.PublicKey),
+	}
+	content, err := tx.signingContent()
+	if err != nil {
+		return nil, err
+	}
+	tx.Signature = hex.EncodeToString(ed25519.Sign(key.PrivateKey, content))
+	return tx, nil
+}
+
+func (tx *Transaction) signingContent() ([]byte, error) {
+	unsigned := *tx
+	unsigned.Signature = ""
+	return json.Marshal(&unsigned)
+}
+
+func (tx *Transaction) Hash() string {
+	content, _ := json.Marshal(tx)
+	return node.Hash(content)
+}
+
+// Verify checks the transaction is well-formed and signed by the owner of From.
+func (tx *Transaction) Verify() error {
+	if len(tx.To) != AddressLength {
+		return errors.New("transaction receiver is malformed")
+	}
+	publicKey, err := hex.DecodeString(tx.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("transaction public key is malformed")
+	}
+	if Address(publicKey) != tx.From {
+		return errors.New("transaction public key does not own the sender")
+	}
+	signature, err := hex.DecodeString(tx.Signature)
+	if err != nil {
+		return errors.New("transaction signature is malformed")
+	}
+	content, err := tx.signingContent()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, content, signature) {
+		return errors.New("transaction signature is invalid")
+	}
+	return nil
+}
+
+// ErrNonceOverflow rejects nonces the mempool can not order.
+var ErrNonceOverflow = errors.New("transaction nonce is beyond the range of the mempool")
+
+// Validate verifies the transaction a message may carry, a message carrying none is valid.
+func (tx *Transaction) Validate() error {
+	if tx == nil {
+		return nil
+	}
+	return tx.Verify()
+}
+
+// ToMempool wraps payload, a message carrying tx, for the mempool, ordered by nonce and prioritized by fee.
+func (tx *Transaction) ToMempool(payload interface{}) (*mempool.Tx, error) {
+	if tx.Nonce > math.MaxInt64 {
+		return nil, ErrNonceOverflow
+	}
+	return mempool.NewTx(tx.From, int64(tx.Nonce), mempool.Priority(tx.Fee), payload)
+}
@@ -0,0 +1,123 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/merkle"
+	"github.com/glimmerzcy/bccp/basic/node"
+)
+
+type Header struct {
+	Height     int64  `json:"height"`
+	PrevHash   string `json:"prevHash"`
+	Timestamp  int64  `json:"timestamp"`
+	Proposer   string `json:"proposer"`
+	MerkleRoot string `json:"merkleRoot"`
+	// consensus specific fields covered by the hash, e.g. slot or difficulty
+	Extra json.RawMessage `json:"extra,omitempty"`
+}
+
+// Certificate proves the block is agreed, it is collected after the block is built
+// and not covered by the hash.
+type Certificate struct {
+	View     int64    `json:"view"`
+	Sequence int64    `json:"sequence"`
+	Digest   string   `json:"digest"`
+	Signers  []string `json:"signers"`
+	// hex signatures of the signers over Digest, in the order of Signers, where the protocol signs
+	Signatures []string `json:"signatures,omitempty"`
+}
+
+type Block struct {
+	Header
+	Hash         string            `json:"hash"`
+	Transactions []json.RawMessage `json:"transactions"`
+	Certificate  *Certificate      `json:"certificate,omitempty"`
+}
+
+func init() {
+	codec.Register(0x0002, 2, Block{})
+}
+
+// NewBlock builds the block following parent, extra is encoded into the header if not nil.
+func NewBlock(parent *Block, timestamp int64, proposer string, txs []json.RawMessage, extra interface{}) (*Block, error) {
+	block := &Block{
+		Header: Header{
+			Height:     parent.Height + 1,
+			PrevHash:   parent.Hash,
+			Timestamp:  timestamp,
+			Proposer:   proposer,
+			MerkleRoot: MerkleRoot(txs),
+		},
+		Transactions: txs,
+	}
+	if extra != nil {
+		raw, err := json.Marshal(extra)
+		if err != nil {
+			return nil, err
+		}
+		block.Extra = raw
+	}
+	block.Hash = block.Header.ComputeHash()
+	return block, nil
+}
+
+// Genesis is the block at height 0, all nodes of one experiment must share it.
+func Genesis(timestamp int64, extra interface{}) (*Block, error) {
+	return NewBlock(&Block{Header: Header{Height: -1}}, timestamp, "", make([]json.RawMessage, 0), extra)
+}
+
+func (header *Header) ComputeHash() string {
+	content, _ := json.Marshal(header)
+	return node.Hash(content)
+}
+
+func (header *Header) DecodeExtra(extra interface{}) error {
+	return json.Unmarshal(header.Extra, extra)
+}
+
+// Verify checks the block is well-formed and extends parent.
+func (block *Block) Verify(parent *Block) error {
+	if block.Height != parent.Height+1 {
+		return errors.New("block height does not follow its parent")
+	}
+	if block.PrevHash != parent.Hash {
+		return errors.New("block does not link to its parent")
+	}
+	if block.MerkleRoot != MerkleRoot(block.Transactions) {
+		return errors.New("block merkle root does not match its transactions")
+	}
+	if block.Hash != block.Header.ComputeHash() {
+		return errors.New("block hash is corrupted")
+	}
+	return nil
+}
+
+// Encode marshals every object into a transaction.
+func Encode[T any](objects []T) ([]json.RawMessage, error) {
+	txs := make([]json.RawMessage, 0, len(objects))
+	for _, object := range objects {
+		raw, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, raw)
+	}
+	return txs, nil
+}
+
+// MerkleRoot is the root of the Merkle tree over the transactions.
+func MerkleRoot(txs []json.RawMessage) string {
+	return merkle.Root(txs)
+}
+
+// Checkpoint digests a run of blocks as the Merkle root over their hashes,
+// so the inclusion of one block can be proved against the checkpoint.
+func Checkpoint(blocks []*Block) string {
+	hashes := make([][]byte, len(blocks))
+	for i, b := range blocks {
+		hashes[i] = []byte(b.Hash)
+	}
+	return merkle.Root(hashes)
+}
@@ -0,0 +1,188 @@
+package center
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/pki"
+	"net/http"
+)
+
+// Serve adds the REST API of the center to its server:
+//
+//	GET /center/servers, POST {"addr"} to register, DELETE ?addr= to deregister
+//	GET /center/nodes, POST {"id", "algo", "server"} to place, DELETE ?id= to remove
+//	GET /center/routes, POST to push them to every server
+//	POST /center/requests {"node", "count"} to send client requests
+//	GET /center/results, ?node= for the results of a node instead of the summaries
+//
+// Errors are answered as {"error"}.
+func (center *Center) Serve() {
+	center.Server.Handle("/center/servers", center.guard(center.handleServers))
+	center.Server.Handle("/center/nodes", center.guard(center.handleNodes))
+	center.Server.Handle("/center/routes", center.guard(center.handleRoutes))
+	center.Server.Handle("/center/requests", center.guard(center.handleRequests))
+	center.Server.Handle("/center/results", center.guard(center.handleResults))
+}
+
+// guard lets only the center identity in under TLS, like the server operations.
+func (center *Center) guard(handle http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if center.Server.TLS != nil && pki.PeerName(request) != pki.CenterName {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handle(writer, request)
+	}
+}
+
+func (center *Center) handleServers(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		reply(writer, http.StatusOK, center.Members())
+	case http.MethodPost:
+		var msg struct {
+			Addr string `json:"addr"`
+		}
+		if !decode(writer, request, &msg) {
+			return
+		}
+		if msg.Addr == "" {
+			fail(writer, badRequest("no addr"))
+			return
+		}
+		member, err := center.Register(msg.Addr)
+		if err != nil {
+			fail(writer, err)
+			return
+		}
+		reply(writer, http.StatusCreated, member)
+	case http.MethodDelete:
+		if err := center.Deregister(request.URL.Query().Get("addr")); err != nil {
+			fail(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (center *Center) handleNodes(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		reply(writer, http.StatusOK, center.Placements())
+	case http.MethodPost:
+		var msg Placement
+		if !decode(writer, request, &msg) {
+			return
+		}
+		if msg.ID == "" {
+			fail(writer, badRequest("no id"))
+			return
+		}
+		placement, err := center.Place(msg.ID, msg.Algo, msg.Server)
+		if err != nil {
+			fail(writer, err)
+			return
+		}
+		reply(writer, http.StatusCreated, placement)
+	case http.MethodDelete:
+		if err := center.Remove(request.URL.Query().Get("id")); err != nil {
+			fail(writer, err)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (center *Center) handleRoutes(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		reply(writer, http.StatusOK, center.Routes())
+	case http.MethodPost:
+		errs := make(map[string]string)
+		for addr, err := range center.PushRoutes() {
+			errs[addr] = err.Error()
+		}
+		if len(errs) > 0 {
+			reply(writer, http.StatusBadGateway, errs)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (center *Center) handleRequests(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	msg := struct {
+		Node  string `json:"node"`
+		Count int    `json:"count"`
+	}{Count: 1}
+	if !decode(writer, request, &msg) {
+		return
+	}
+	results, err := center.Request(msg.Node, msg.Count)
+	if err != nil {
+		fail(writer, err)
+		return
+	}
+	reply(writer, http.StatusOK, results)
+}
+
+func (center *Center) handleResults(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if id := request.URL.Query().Get("node"); id != "" {
+		reply(writer, http.StatusOK, center.Results(id))
+		return
+	}
+	reply(writer, http.StatusOK, center.Summaries())
+}
+
+func decode(writer http.ResponseWriter, request *http.Request, msg interface{}) bool {
+	if err := json.NewDecoder(request.Body).Decode(msg); err != nil {
+		fail(writer, badRequest(err.Error()))
+		return false
+	}
+	return true
+}
+
+func reply(writer http.ResponseWriter, status int, msg interface{}) {
+	writer.Header().Set("Content-Type", codec.JSON.ContentType())
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(msg)
+}
+
+type badRequest string
+
+func (err badRequest) Error() string {
+	return string(err)
+}
+
+// fail answers err with the status matching it, 502 for the errors of the servers but a rejected request.
+func fail(writer http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	var bad badRequest
+	var serverErr *ServerError
+	switch {
+	case errors.As(err, &bad), errors.As(err, &serverErr) && serverErr.Status == http.StatusBadRequest:
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrUnknownServer), errors.Is(err, ErrUnknownNode):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrRegistered):
+		status = http.StatusConflict
+	case errors.Is(err, ErrNoServer):
+		status = http.StatusServiceUnavailable
+	}
+	reply(writer, status, map[string]string{"error": err.Error()})
+}
@@ -1,22 +1,38 @@
 package center
 
 import (
-	"fmt"
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/discovery"
 	"github.com/glimmerzcy/bccp/basic/server"
 	"log"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 )
 
 type Center struct {
-	Server     *server.Server
+	Server *server.Server
+	// addresses of the servers, in the order they are registered
 	ServerList []string
+	// a server failing as many health checks in a row is unhealthy, no node is placed on it
+	MaxFailures int
+	members     map[string]*Member
+	placements  map[string]*Placement
+	results     map[string][]Result
+	done        chan struct{}
+	mutex       sync.Mutex
 }
 
 func NewCenter(server *server.Server) *Center {
 	return &Center{
-		server,
-		make([]string, 0),
+		Server:      server,
+		ServerList:  make([]string, 0),
+		MaxFailures: 2,
+		members:     make(map[string]*Member),
+		placements:  make(map[string]*Placement),
+		results:     make(map[string][]Result),
 	}
 }
 
@@ -26,49 +42,155 @@ func init() {
 	DefaultCenter = NewCenter(server.DefaultServer)
 }
 
-func (center *Center) Send(to int, operation string, id string, msg string) (resp *http.Response, err error) {
+// Send runs operation on the server at addr.
+func (center *Center) Send(addr string, operation string, id string, msg string) (resp *http.Response, err error) {
 	query := url.Values{}
 	query.Add("id", id)
 	query.Add("msg", msg)
 	query.Add("operation", operation)
-	queryUrl := "http://" + center.ServerList[to] + "/server?" + query.Encode()
-	return http.Get(queryUrl)
+	queryUrl := center.Server.Scheme() + "://" + addr + "/server?" + query.Encode()
+	return center.Server.Client().Get(queryUrl)
 }
 
+// Create asks the server at addr to make the node id by the factory registered as algo, the default factory of the server when empty.
+func (center *Center) Create(addr string, id string, algo string) (resp *http.Response, err error) {
+	query := url.Values{}
+	query.Add("id", id)
+	query.Add("algo", algo)
+	query.Add("operation", "new")
+	queryUrl := center.Server.Scheme() + "://" + addr + "/server?" + query.Encode()
+	return center.Server.Client().Get(queryUrl)
+}
+
+// Broadcast sends to every server at once, the answer of the i-th server listed at the call is resps[i] or errs[i].
+// The caller closes the bodies.
 func (center *Center) Broadcast(operation string, id string, msg string) (resps []*http.Response, errs []error) {
-	total := len(center.ServerList)
-	countChan := make(chan int)
-	resps, errs = make([]*http.Response, 0, total), make([]error, 0, total)
-	for to := range center.ServerList {
-		go func(to int) {
-			resp, err := center.Send(to, operation, id, msg)
-			resps = append(resps, resp)
-			errs = append(errs, err)
-			countChan <- 1
-		}(to)
-	}
-	for i := 0; i < total; i++ {
-		select {
-		case <-countChan:
-			fmt.Println(i)
-		}
+	addrs := center.servers()
+	resps, errs = make([]*http.Response, len(addrs)), make([]error, len(addrs))
+	var wait sync.WaitGroup
+	for i, addr := range addrs {
+		wait.Add(1)
+		go func(i int, addr string) {
+			defer wait.Done()
+			resps[i], errs[i] = center.Send(addr, operation, id, msg)
+		}(i, addr)
 	}
+	wait.Wait()
 	log.Println("operation broadcast finished!")
 	return resps, errs
 }
 
-func Send(to int, operation string, id string, msg string) (resp *http.Response, err error) {
-	return DefaultCenter.Send(to, operation, id, msg)
+// servers is a copy of ServerList, which registering servers may change.
+func (center *Center) servers() []string {
+	center.mutex.Lock()
+	defer center.mutex.Unlock()
+	return append([]string{}, center.ServerList...)
+}
+
+func Send(addr string, operation string, id string, msg string) (resp *http.Response, err error) {
+	return DefaultCenter.Send(addr, operation, id, msg)
 }
 
+func Create(addr string, id string, algo string) (resp *http.Response, err error) {
+	return DefaultCenter.Create(addr, id, algo)
+}
+
+// Broadcast logs the servers failing operation.
 func Broadcast(operation string, id string, msg string) {
-	DefaultCenter.Broadcast(operation, id, msg)
+	resps, errs := DefaultCenter.Broadcast(operation, id, msg)
+	for i, resp := range resps {
+		if errs[i] != nil {
+			log.Println(errs[i])
+			continue
+		}
+		if resp.StatusCode >= http.StatusBadRequest {
+			log.Println(resp.Request.URL.Host, operation, resp.Status)
+		}
+		resp.Body.Close()
+	}
 }
 
 func SetServerList(serverList []string) {
+	DefaultCenter.mutex.Lock()
+	defer DefaultCenter.mutex.Unlock()
 	DefaultCenter.ServerList = serverList
 }
 
+// Discover asks the server at addr for the servers it knows, they become the server list,
+// and routes their nodes.
+func Discover(addr string) error {
+	center := DefaultCenter
+	resp, err := center.Server.Client().Get(center.Server.Scheme() + "://" + addr + discovery.Path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var members []discovery.Member
+	if err = json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return err
+	}
+	serverList := make([]string, 0, len(members))
+	for _, member := range members {
+		serverList = append(serverList, member.Addr)
+		for _, id := range member.Nodes {
+			center.Server.Route(id, member.Addr)
+		}
+	}
+	center.mutex.Lock()
+	center.ServerList = serverList
+	center.mutex.Unlock()
+	return nil
+}
+
 func GetServer() *server.Server {
 	return DefaultCenter.Server
 }
+
+// Fault injects fault into the messages sent by the nodes of every server.
+func Fault(fault server.Fault) {
+	msg, _ := json.Marshal(fault)
+	Broadcast("fault", "", string(msg))
+}
+
+// Partition cuts nodes off from the rest of the network for duration, starting after.
+// e.g. Partition([]string{"node-1"}, 0, 5*time.Second)
+func Partition(nodes []string, after time.Duration, duration time.Duration) {
+	msg, _ := json.Marshal(server.Partition{Nodes: nodes, After: after, Duration: duration})
+	Broadcast("partition", "", string(msg))
+}
+
+// Stats sums the bytes on the wire per operation and codec over every server.
+func Stats() map[string]map[string]codec.Wire {
+	stats := make(map[string]map[string]codec.Wire)
+	for _, addr := range DefaultCenter.servers() {
+		resp, err := DefaultCenter.Send(addr, "stats", "", "")
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var part map[string]map[string]codec.Wire
+		err = json.NewDecoder(resp.Body).Decode(&part)
+		resp.Body.Close()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for operation, byCodec := range part {
+			if stats[operation] == nil {
+				stats[operation] = make(map[string]codec.Wire)
+			}
+			for name, wire := range byCodec {
+				sum := stats[operation][name]
+				sum.Messages += wire.Messages
+				sum.Bytes += wire.Bytes
+				stats[operation][name] = sum
+			}
+		}
+	}
+	return stats
+}
+
+// Heal removes the faults and partitions of every server.
+func Heal() {
+	Broadcast("heal", "", "")
+}
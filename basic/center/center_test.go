@@ -0,0 +1,119 @@
+package center
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// replica answers every operation and keeps the total of the last setF.
+type replica struct {
+	total int
+	mutex sync.Mutex
+}
+
+func (replica *replica) DoOperation(operation string, writer http.ResponseWriter, request *http.Request) {
+	if operation == "setF" {
+		var msg struct{ Total int }
+		if err := json.NewDecoder(request.Body).Decode(&msg); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		replica.mutex.Lock()
+		replica.total = msg.Total
+		replica.mutex.Unlock()
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (replica *replica) Total() int {
+	replica.mutex.Lock()
+	defer replica.mutex.Unlock()
+	return replica.total
+}
+
+// replicas makes replicas, refusing the ids in broken.
+type replicas struct {
+	made   map[string]*replica
+	broken map[string]bool
+	mutex  sync.Mutex
+}
+
+func (factory *replicas) NewOperator(id string, _ server.Sender) (server.Operator, error) {
+	factory.mutex.Lock()
+	defer factory.mutex.Unlock()
+	if factory.broken[id] {
+		return nil, errors.New("broken")
+	}
+	made := &replica{}
+	factory.made[id] = made
+	return made, nil
+}
+
+func (factory *replicas) get(id string) *replica {
+	factory.mutex.Lock()
+	defer factory.mutex.Unlock()
+	return factory.made[id]
+}
+
+func TestPlaceAndRecover(t *testing.T) {
+	factory := &replicas{made: make(map[string]*replica), broken: make(map[string]bool)}
+	srv, err := server.New(server.Config{Listen: "127.0.0.1:0", Factory: factory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	center := NewCenter(server.NewServer())
+	addr := srv.Addr()
+	if _, err = center.Register(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"node-1", "node-2", "node-3"} {
+		if _, err = center.Place(id, "", addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"node-1", "node-2", "node-3"} {
+		if total := factory.get(id).Total(); total != 3 {
+			t.Fatalf("%s is told %d nodes after 3 are placed", id, total)
+		}
+	}
+	if err = center.Remove("node-3"); err != nil {
+		t.Fatal(err)
+	}
+	if total := factory.get("node-1").Total(); total != 2 {
+		t.Fatalf("node-1 is told %d nodes after one of 3 is removed", total)
+	}
+
+	// The server restarts without its nodes, and can only make node-2 again.
+	srv.Delete("node-1")
+	srv.Delete("node-2")
+	factory.mutex.Lock()
+	factory.broken["node-2"] = true
+	factory.mutex.Unlock()
+	center.mutex.Lock()
+	center.members[addr].Healthy = false
+	center.mutex.Unlock()
+	center.Check()
+
+	if _, ok := srv.OperatorTable.Get("node-1"); !ok {
+		t.Fatal("node-1 is not created again on the recovered server")
+	}
+	placements := center.Placements()
+	if len(placements) != 1 || placements[0].ID != "node-1" {
+		t.Fatalf("placements are %v, want node-2 lost", placements)
+	}
+	if total := factory.get("node-1").Total(); total != 1 {
+		t.Fatalf("node-1 is told %d nodes after node-2 is lost", total)
+	}
+	if routed, _ := srv.Lookup("node-2"); routed != "" {
+		t.Fatalf("the lost node-2 is still routed to %s", routed)
+	}
+}
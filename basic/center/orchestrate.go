@@ -0,0 +1,533 @@
+package center
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownServer = errors.New("unknown server")
+	ErrUnknownNode   = errors.New("unknown node")
+	ErrRegistered    = errors.New("already registered")
+	ErrNoServer      = errors.New("no healthy server")
+)
+
+// ClientRequest is the message of the client requests the center sends, the reply carries the delay.
+const ClientRequest = `{"Operation":"Test"}`
+
+// PBFT is the algorithm of the nodes told the number of replicas by SetF.
+// Nodes placed without an algorithm run the one of their server, pbft unless it is set otherwise.
+const PBFT = "pbft"
+
+// CheckTimeout bounds a health check, so a hung server is found unhealthy.
+var CheckTimeout = 3 * time.Second
+
+// Member is a server registered with the center.
+type Member struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+	// health checks failed in a row
+	Failures int       `json:"failures"`
+	LastSeen time.Time `json:"lastSeen"`
+	// the operators the server had at the last health check
+	Nodes []string `json:"nodes"`
+	Error string   `json:"error,omitempty"`
+}
+
+// Placement is a node the center created on a server.
+type Placement struct {
+	ID     string `json:"id"`
+	Algo   string `json:"algo"`
+	Server string `json:"server"`
+}
+
+// Result is a client request the center sent to a node.
+type Result struct {
+	Node string    `json:"node"`
+	At   time.Time `json:"at"`
+	// µs the node took to commit the request, as it reported
+	Delay int64  `json:"delay"`
+	Error string `json:"error,omitempty"`
+}
+
+// Summary sums the results of a node.
+type Summary struct {
+	Node     string `json:"node"`
+	Requests int    `json:"requests"`
+	Failed   int    `json:"failed"`
+	AvgDelay int64  `json:"avgDelay"`
+	MaxDelay int64  `json:"maxDelay"`
+}
+
+// Register adds the server at addr once it answers a health check, and pushes it the routes.
+func (center *Center) Register(addr string) (Member, error) {
+	center.mutex.Lock()
+	if _, ok := center.members[addr]; ok {
+		center.mutex.Unlock()
+		return Member{}, fmt.Errorf("server %s: %w", addr, ErrRegistered)
+	}
+	center.mutex.Unlock()
+	status, err := center.status(addr)
+	if err != nil {
+		return Member{}, err
+	}
+	center.mutex.Lock()
+	if _, ok := center.members[addr]; ok {
+		center.mutex.Unlock()
+		return Member{}, fmt.Errorf("server %s: %w", addr, ErrRegistered)
+	}
+	member := &Member{Addr: addr, Healthy: true, LastSeen: time.Now(), Nodes: status.Nodes}
+	center.members[addr] = member
+	center.ServerList = append(center.ServerList, addr)
+	registered := *member
+	center.mutex.Unlock()
+	center.PushRoutes()
+	return registered, nil
+}
+
+// Deregister drops the server at addr and the nodes placed on it, the other servers stop routing to them.
+func (center *Center) Deregister(addr string) error {
+	center.mutex.Lock()
+	if _, ok := center.members[addr]; !ok {
+		center.mutex.Unlock()
+		return fmt.Errorf("server %s: %w", addr, ErrUnknownServer)
+	}
+	delete(center.members, addr)
+	for i, listed := range center.ServerList {
+		if listed == addr {
+			center.ServerList = append(center.ServerList[:i:i], center.ServerList[i+1:]...)
+			break
+		}
+	}
+	for id, placement := range center.placements {
+		if placement.Server == addr {
+			delete(center.placements, id)
+		}
+	}
+	center.mutex.Unlock()
+	center.PushRoutes()
+	center.SetF()
+	return nil
+}
+
+// Members are the registered servers, in registration order.
+func (center *Center) Members() []Member {
+	center.mutex.Lock()
+	defer center.mutex.Unlock()
+	members := make([]Member, 0, len(center.ServerList))
+	for _, addr := range center.ServerList {
+		if member, ok := center.members[addr]; ok {
+			members = append(members, *member)
+		}
+	}
+	return members
+}
+
+// Start health-checks the registered servers every period until Stop.
+func (center *Center) Start(period time.Duration) {
+	center.mutex.Lock()
+	if center.done != nil {
+		center.mutex.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	center.done = done
+	center.mutex.Unlock()
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				center.Check()
+			}
+		}
+	}()
+}
+
+func (center *Center) Stop() {
+	center.mutex.Lock()
+	defer center.mutex.Unlock()
+	if center.done != nil {
+		close(center.done)
+		center.done = nil
+	}
+}
+
+// Check health-checks every registered server at once. A server failing MaxFailures checks in a row
+// becomes unhealthy. Once it recovers it may have restarted: the nodes placed on it it lost are
+// created again, and it gets the routes again.
+func (center *Center) Check() {
+	addrs := center.servers()
+	var wait sync.WaitGroup
+	// address to the nodes of the recovered servers
+	recovered := make(map[string][]string)
+	var mutex sync.Mutex
+	for _, addr := range addrs {
+		wait.Add(1)
+		go func(addr string) {
+			defer wait.Done()
+			status, err := center.status(addr)
+			center.mutex.Lock()
+			defer center.mutex.Unlock()
+			member, ok := center.members[addr]
+			if !ok {
+				return
+			}
+			if err != nil {
+				member.Failures++
+				member.Error = err.Error()
+				if member.Healthy && member.Failures >= center.MaxFailures {
+					log.Println("server", addr, "is unhealthy:", err)
+					member.Healthy = false
+				}
+				return
+			}
+			if !member.Healthy {
+				log.Println("server", addr, "recovered")
+				mutex.Lock()
+				recovered[addr] = status.Nodes
+				mutex.Unlock()
+			}
+			member.Healthy, member.Failures, member.Error = true, 0, ""
+			member.LastSeen = time.Now()
+			member.Nodes = status.Nodes
+		}(addr)
+	}
+	wait.Wait()
+	if len(recovered) == 0 {
+		return
+	}
+	for addr, nodes := range recovered {
+		center.reconcile(addr, nodes)
+	}
+	center.PushRoutes()
+	center.SetF()
+}
+
+// reconcile creates the nodes placed on the server at addr again if it has not got them.
+// A node the server fails to create is lost, the center forgets it.
+func (center *Center) reconcile(addr string, nodes []string) {
+	has := make(map[string]bool, len(nodes))
+	for _, id := range nodes {
+		has[id] = true
+	}
+	center.mutex.Lock()
+	missing := make([]Placement, 0)
+	for _, placement := range center.placements {
+		if placement.Server == addr && !has[placement.ID] {
+			missing = append(missing, *placement)
+		}
+	}
+	center.mutex.Unlock()
+	for _, placement := range missing {
+		log.Println("node", placement.ID, "is missing on", addr)
+		_, err := center.operate(center.Server.Client(), addr, "new", url.Values{"id": {placement.ID}, "algo": {placement.Algo}})
+		if err == nil {
+			continue
+		}
+		log.Println("node", placement.ID, "is lost:", err)
+		center.mutex.Lock()
+		if current, ok := center.placements[placement.ID]; ok && current.Server == addr {
+			delete(center.placements, placement.ID)
+		}
+		center.mutex.Unlock()
+	}
+}
+
+// Place creates the node id running algo on the server at addr, or on the healthy server with
+// the fewest nodes if addr is empty, then pushes the routes to every server and tells the pbft nodes their number.
+func (center *Center) Place(id string, algo string, addr string) (Placement, error) {
+	center.mutex.Lock()
+	if placement, ok := center.placements[id]; ok {
+		center.mutex.Unlock()
+		return Placement{}, fmt.Errorf("node %s on %s: %w", id, placement.Server, ErrRegistered)
+	}
+	if addr == "" {
+		addr = center.leastLoaded()
+		if addr == "" {
+			center.mutex.Unlock()
+			return Placement{}, ErrNoServer
+		}
+	} else if member, ok := center.members[addr]; !ok {
+		center.mutex.Unlock()
+		return Placement{}, fmt.Errorf("server %s: %w", addr, ErrUnknownServer)
+	} else if !member.Healthy {
+		center.mutex.Unlock()
+		return Placement{}, fmt.Errorf("server %s: %w", addr, ErrNoServer)
+	}
+	// Reserve the id while the server creates the node.
+	placement := &Placement{ID: id, Algo: algo, Server: addr}
+	center.placements[id] = placement
+	center.mutex.Unlock()
+
+	_, err := center.operate(center.Server.Client(), addr, "new", url.Values{"id": {id}, "algo": {algo}})
+	if err != nil {
+		center.mutex.Lock()
+		delete(center.placements, id)
+		center.mutex.Unlock()
+		return Placement{}, err
+	}
+	center.PushRoutes()
+	center.SetF()
+	return *placement, nil
+}
+
+// leastLoaded is the healthy server with the fewest placed nodes, the first registered on a tie.
+func (center *Center) leastLoaded() string {
+	load := make(map[string]int)
+	for _, placement := range center.placements {
+		load[placement.Server]++
+	}
+	least := ""
+	for _, addr := range center.ServerList {
+		member, ok := center.members[addr]
+		if !ok || !member.Healthy {
+			continue
+		}
+		if least == "" || load[addr] < load[least] {
+			least = addr
+		}
+	}
+	return least
+}
+
+// Remove deletes the node id from its server, then pushes the routes to every server and tells the pbft nodes their number.
+// The node is forgotten even if its server does not answer.
+func (center *Center) Remove(id string) error {
+	center.mutex.Lock()
+	placement, ok := center.placements[id]
+	if !ok {
+		center.mutex.Unlock()
+		return fmt.Errorf("node %s: %w", id, ErrUnknownNode)
+	}
+	delete(center.placements, id)
+	center.mutex.Unlock()
+	_, err := center.operate(center.Server.Client(), placement.Server, "delete", url.Values{"id": {id}})
+	center.PushRoutes()
+	center.SetF()
+	return err
+}
+
+// Placements are the placed nodes, by id.
+func (center *Center) Placements() []Placement {
+	center.mutex.Lock()
+	defer center.mutex.Unlock()
+	placements := make([]Placement, 0, len(center.placements))
+	for _, placement := range center.placements {
+		placements = append(placements, *placement)
+	}
+	sort.Slice(placements, func(i, j int) bool { return placements[i].ID < placements[j].ID })
+	return placements
+}
+
+// Routes maps every placed node to the address of its server.
+func (center *Center) Routes() map[string]string {
+	center.mutex.Lock()
+	defer center.mutex.Unlock()
+	table := make(map[string]string, len(center.placements))
+	for id, placement := range center.placements {
+		table[id] = placement.Server
+	}
+	return table
+}
+
+// PushRoutes replaces the route table of every healthy server by Routes, so they all agree.
+// The errors are by server address.
+func (center *Center) PushRoutes() map[string]error {
+	table, _ := json.Marshal(center.Routes())
+	errs := make(map[string]error)
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	for _, member := range center.Members() {
+		if !member.Healthy {
+			continue
+		}
+		wait.Add(1)
+		go func(addr string) {
+			defer wait.Done()
+			_, err := center.operate(center.Server.Client(), addr, "routes", url.Values{"msg": {string(table)}})
+			if err != nil {
+				log.Println(err)
+				mutex.Lock()
+				errs[addr] = err
+				mutex.Unlock()
+			}
+		}(member.Addr)
+	}
+	wait.Wait()
+	return errs
+}
+
+// SetF tells every pbft node how many pbft nodes are placed, their f follows from it.
+// The errors are by node id.
+func (center *Center) SetF() map[string]error {
+	center.mutex.Lock()
+	nodes := make(map[string]string)
+	for id, placement := range center.placements {
+		if placement.Algo == PBFT || placement.Algo == "" {
+			nodes[id] = placement.Server
+		}
+	}
+	center.mutex.Unlock()
+	msg, _ := json.Marshal(struct{ Total int }{len(nodes)})
+	errs := make(map[string]error)
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	for id, addr := range nodes {
+		wait.Add(1)
+		go func(id string, addr string) {
+			defer wait.Done()
+			if _, err := center.post(addr, id, "setF", msg); err != nil {
+				log.Println(err)
+				mutex.Lock()
+				errs[id] = err
+				mutex.Unlock()
+			}
+		}(id, addr)
+	}
+	wait.Wait()
+	return errs
+}
+
+// Request sends count client requests in a row to the node id, and records their results.
+func (center *Center) Request(id string, count int) ([]Result, error) {
+	center.mutex.Lock()
+	placement, ok := center.placements[id]
+	center.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("node %s: %w", id, ErrUnknownNode)
+	}
+	results := make([]Result, 0, count)
+	for i := 0; i < count; i++ {
+		result := Result{Node: id, At: time.Now()}
+		body, err := center.client(placement.Server, id)
+		if err == nil {
+			var reply struct{ Delay int64 }
+			err = json.Unmarshal(body, &reply)
+			result.Delay = reply.Delay
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	center.mutex.Lock()
+	center.results[id] = append(center.results[id], results...)
+	center.mutex.Unlock()
+	return results, nil
+}
+
+// client sends a client request to the node id at the server at addr, as the center.
+func (center *Center) client(addr string, id string) ([]byte, error) {
+	return center.post(addr, id, "client", []byte(ClientRequest))
+}
+
+// post sends the JSON msg to the node id at the server at addr as the center, and returns the answer.
+func (center *Center) post(addr string, id string, operation string, msg []byte) ([]byte, error) {
+	query := url.Values{}
+	query.Add("from", "center")
+	query.Add("to", id)
+	query.Add("operation", operation)
+	queryUrl := center.Server.Scheme() + "://" + addr + "/node?" + query.Encode()
+	resp, err := center.Server.Client().Post(queryUrl, "application/json", bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	return read(resp, id+" "+operation)
+}
+
+// Results are the recorded results of the node id.
+func (center *Center) Results(id string) []Result {
+	center.mutex.Lock()
+	defer center.mutex.Unlock()
+	return append([]Result{}, center.results[id]...)
+}
+
+// Summaries sum the recorded results of every node, by id.
+func (center *Center) Summaries() []Summary {
+	center.mutex.Lock()
+	defer center.mutex.Unlock()
+	summaries := make([]Summary, 0, len(center.results))
+	for id, results := range center.results {
+		summary := Summary{Node: id, Requests: len(results)}
+		var total int64
+		for _, result := range results {
+			if result.Error != "" {
+				summary.Failed++
+				continue
+			}
+			total += result.Delay
+			if result.Delay > summary.MaxDelay {
+				summary.MaxDelay = result.Delay
+			}
+		}
+		if done := summary.Requests - summary.Failed; done > 0 {
+			summary.AvgDelay = total / int64(done)
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Node < summaries[j].Node })
+	return summaries
+}
+
+// status runs the status operation on the server at addr, within CheckTimeout.
+func (center *Center) status(addr string) (server.Status, error) {
+	var status server.Status
+	client := &http.Client{Transport: center.Server.Client().Transport, Timeout: CheckTimeout}
+	body, err := center.operate(client, addr, "status", nil)
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(body, &status)
+	return status, err
+}
+
+// operate runs a server operation on the server at addr, and returns the answer.
+func (center *Center) operate(client *http.Client, addr string, operation string, params url.Values) ([]byte, error) {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("operation", operation)
+	resp, err := client.Get(center.Server.Scheme() + "://" + addr + "/server?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return read(resp, addr+" "+operation)
+}
+
+// read is the body of resp, or an error telling what failed if the status is not a success.
+func read(resp *http.Response, what string) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, &ServerError{what + ": " + resp.Status + " " + string(bytes.TrimSpace(body)), resp.StatusCode}
+	}
+	return body, nil
+}
+
+// ServerError is a server answering with an error status.
+type ServerError struct {
+	msg    string
+	Status int
+}
+
+func (err *ServerError) Error() string {
+	return err.msg
+}
@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// ReadyTimeout is how long a server process may take to answer after it is spawned.
+const ReadyTimeout = 10 * time.Second
+
+// StopTimeout is how long a server process may take to exit once asked to stop, it is killed afterwards.
+const StopTimeout = 5 * time.Second
+
+var ErrExited = errors.New("server process exited")
+
+// Cluster is a running Spec, one server process per host on this machine.
+type Cluster struct {
+	Spec *Spec
+	// working directory of the processes, one directory per host holding its logs
+	Dir string
+	// talks to the servers, as the center
+	Center    *server.Server
+	processes map[string]*process
+}
+
+type process struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+	err    error
+}
+
+// Up spawns exe with args and the listen address of each host, e.g. bccp server,
+// then creates and routes the nodes, injects their faults and sends the setup messages.
+// Whatever is up is torn down when a step fails.
+func Up(spec *Spec, dir string, exe string, args ...string) (*Cluster, error) {
+	center, err := server.New(server.EnvConfig())
+	if err != nil {
+		return nil, err
+	}
+	cluster := &Cluster{
+		Spec:      spec,
+		Dir:       dir,
+		Center:    center,
+		processes: make(map[string]*process),
+	}
+	for _, node := range spec.Nodes {
+		addr, _ := spec.Addr(node.ID)
+		center.Route(node.ID, addr)
+	}
+	if err = cluster.up(exe, args); err != nil {
+		cluster.Down()
+		return nil, err
+	}
+	return cluster, nil
+}
+
+func (cluster *Cluster) up(exe string, args []string) error {
+	for _, host := range cluster.Spec.Hosts {
+		if err := cluster.spawn(host, exe, args); err != nil {
+			return err
+		}
+	}
+	for _, host := range cluster.Spec.Hosts {
+		if err := cluster.ready(host); err != nil {
+			return err
+		}
+	}
+	for _, node := range cluster.Spec.Nodes {
+		algo := node.Algo
+		if algo == "" {
+			algo = cluster.Spec.Algo
+		}
+		if err := cluster.Operate(node.Host, "new", node.ID, url.Values{"algo": {algo}}); err != nil {
+			return err
+		}
+	}
+	for _, host := range cluster.Spec.Hosts {
+		for _, node := range cluster.Spec.Nodes {
+			addr, _ := cluster.Spec.Addr(node.ID)
+			if err := cluster.Operate(host.Name, "add", node.ID, url.Values{"msg": {addr}}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, node := range cluster.Spec.Nodes {
+		latency, _ := node.latency()
+		if latency > 0 {
+			fault, _ := json.Marshal(server.Fault{From: node.ID, Delay: latency})
+			if err := cluster.Operate(node.Host, "fault", "", url.Values{"msg": {string(fault)}}); err != nil {
+				return err
+			}
+		}
+		if node.Byzantine != "" {
+			strategy, _ := json.Marshal(map[string]string{"Strategy": node.Byzantine})
+			if err := cluster.Send(node.ID, "byzantine", strategy); err != nil {
+				return err
+			}
+		}
+	}
+	for _, msg := range cluster.Spec.Setup {
+		to := msg.To
+		if len(to) == 0 {
+			to = cluster.IDs()
+		}
+		for _, id := range to {
+			if err := cluster.Send(id, msg.Operation, msg.Message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (cluster *Cluster) spawn(host Host, exe string, args []string) error {
+	dir := filepath.Join(cluster.Dir, host.Name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	output, err := os.Create(filepath.Join(dir, "server.log"))
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, append(append([]string{}, args...), "-listen", host.Addr)...)
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err = cmd.Start(); err != nil {
+		output.Close()
+		return err
+	}
+	p := &process{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		output.Close()
+		close(p.exited)
+	}()
+	cluster.processes[host.Name] = p
+	log.Println("cluster: spawned", host.Name, "at", host.Addr, "pid", cmd.Process.Pid)
+	return nil
+}
+
+// ready waits for the server of host to answer.
+func (cluster *Cluster) ready(host Host) error {
+	p := cluster.processes[host.Name]
+	deadline := time.Now().Add(ReadyTimeout)
+	for {
+		resp, err := cluster.Center.Client().Get(cluster.Center.Scheme() + "://" + host.Addr + "/server?operation=stats")
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return nil
+		}
+		select {
+		case <-p.exited:
+			return fmt.Errorf("%s: %w: %v, see %s", host.Name, ErrExited, p.err, filepath.Join(cluster.Dir, host.Name, "server.log"))
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: no answer at %s: %w", host.Name, host.Addr, err)
+		}
+	}
+}
+
+// Operate runs a server operation on the server of host, like the center.
+func (cluster *Cluster) Operate(host string, operation string, id string, params url.Values) error {
+	h, ok := cluster.Spec.Host(host)
+	if !ok {
+		return fmt.Errorf("unknown host %s", host)
+	}
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("operation", operation)
+	query.Set("id", id)
+	resp, err := cluster.Center.Client().Get(cluster.Center.Scheme() + "://" + h.Addr + "/server?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	return check(resp, host+" "+operation+" "+id)
+}
+
+// Send posts a JSON message to the node id as the center.
+func (cluster *Cluster) Send(id string, operation string, message json.RawMessage) error {
+	addr, ok := cluster.Spec.Addr(id)
+	if !ok {
+		return fmt.Errorf("unknown node %s", id)
+	}
+	query := url.Values{}
+	query.Add("from", "center")
+	query.Add("to", id)
+	query.Add("operation", operation)
+	resp, err := cluster.Center.Client().Post(cluster.Center.Scheme()+"://"+addr+"/node?"+query.Encode(),
+		codec.JSON.ContentType(), bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	return check(resp, id+" "+operation)
+}
+
+// IDs of all nodes, in the order of the spec.
+func (cluster *Cluster) IDs() []string {
+	ids := make([]string, 0, len(cluster.Spec.Nodes))
+	for _, node := range cluster.Spec.Nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
+// Down stops the servers, killing those not exiting within StopTimeout.
+func (cluster *Cluster) Down() {
+	for name, p := range cluster.processes {
+		select {
+		case <-p.exited:
+			continue
+		default:
+		}
+		if err := cluster.Operate(name, "stop", "", nil); err != nil {
+			log.Println("cluster:", err)
+		}
+	}
+	for name, p := range cluster.processes {
+		select {
+		case <-p.exited:
+		case <-time.After(StopTimeout):
+			log.Println("cluster: kill", name)
+			p.cmd.Process.Kill()
+			<-p.exited
+		}
+	}
+	log.Println("cluster: down")
+}
+
+func check(resp *http.Response, what string) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s: %s %s", what, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
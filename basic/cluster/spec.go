@@ -0,0 +1,147 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"github.com/glimmerzcy/bccp/implement/pbft"
+	"os"
+	"time"
+)
+
+// Spec describes a cluster: the servers to run, the nodes on them, and the messages setting the nodes up.
+type Spec struct {
+	// algorithm of the nodes naming none, see server.RegisterFactory
+	Algo  string `json:"algo"`
+	Hosts []Host `json:"hosts"`
+	Nodes []Node `json:"nodes"`
+	// sent to the nodes in order, once all of them are routed
+	Setup []Message `json:"setup"`
+}
+
+type Host struct {
+	Name string `json:"name"`
+	// address the server listens on and is reached at, e.g. 127.0.0.1:2001
+	Addr string `json:"addr"`
+}
+
+type Node struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+	Algo string `json:"algo,omitempty"`
+	// turns a pbft replica faulty with one of pbft.Strategies
+	Byzantine string `json:"byzantine,omitempty"`
+	// delay of every message the node sends, e.g. 50ms
+	Latency string `json:"latency,omitempty"`
+}
+
+type Message struct {
+	Operation string `json:"operation"`
+	// nodes receiving the message, all when empty
+	To      []string        `json:"to,omitempty"`
+	Message json.RawMessage `json:"message"`
+}
+
+// Load reads the spec at path, and validates it.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var spec Spec
+	if err = json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err = spec.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &spec, nil
+}
+
+// Validate checks that names are unique, nodes are on known hosts and have a registered algorithm,
+// only pbft replicas are byzantine by a known strategy, and messages go to known nodes.
+func (spec *Spec) Validate() error {
+	hosts := make(map[string]bool)
+	for _, host := range spec.Hosts {
+		if host.Name == "" || host.Addr == "" {
+			return fmt.Errorf("host %q needs a name and an address", host.Name)
+		}
+		if hosts[host.Name] {
+			return fmt.Errorf("host %s is described twice", host.Name)
+		}
+		hosts[host.Name] = true
+	}
+	nodes := make(map[string]bool)
+	for _, node := range spec.Nodes {
+		if node.ID == "" {
+			return fmt.Errorf("a node on %s has no id", node.Host)
+		}
+		if nodes[node.ID] {
+			return fmt.Errorf("node %s is described twice", node.ID)
+		}
+		nodes[node.ID] = true
+		if !hosts[node.Host] {
+			return fmt.Errorf("node %s is on unknown host %q", node.ID, node.Host)
+		}
+		algo := node.Algo
+		if algo == "" {
+			algo = spec.Algo
+		}
+		if algo == "" {
+			return fmt.Errorf("node %s has no algorithm", node.ID)
+		}
+		if _, err := server.FactoryOf(algo); err != nil {
+			return fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		if node.Byzantine != "" {
+			if algo != "pbft" {
+				return fmt.Errorf("node %s can not be byzantine, only pbft replicas can", node.ID)
+			}
+			if _, ok := pbft.Strategies[node.Byzantine]; !ok {
+				return fmt.Errorf("node %s has unknown byzantine strategy %s", node.ID, node.Byzantine)
+			}
+		}
+		if _, err := node.latency(); err != nil {
+			return fmt.Errorf("node %s: %w", node.ID, err)
+		}
+	}
+	for _, msg := range spec.Setup {
+		if msg.Operation == "" {
+			return fmt.Errorf("a setup message has no operation")
+		}
+		for _, id := range msg.To {
+			if !nodes[id] {
+				return fmt.Errorf("setup message %s goes to unknown node %s", msg.Operation, id)
+			}
+		}
+	}
+	return nil
+}
+
+// Host finds the host named name.
+func (spec *Spec) Host(name string) (Host, bool) {
+	for _, host := range spec.Hosts {
+		if host.Name == name {
+			return host, true
+		}
+	}
+	return Host{}, false
+}
+
+// Addr is the address of the server running the node id.
+func (spec *Spec) Addr(id string) (string, bool) {
+	for _, node := range spec.Nodes {
+		if node.ID == id {
+			host, ok := spec.Host(node.Host)
+			return host.Addr, ok
+		}
+	}
+	return "", false
+}
+
+func (node *Node) latency() (time.Duration, error) {
+	if node.Latency == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(node.Latency)
+}
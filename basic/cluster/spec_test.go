@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	_ "github.com/glimmerzcy/bccp/implement/dpos"
+	"testing"
+)
+
+func TestValidateNodes(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		node Node
+		ok   bool
+	}{
+		{"honest", Node{ID: "node-1", Host: "host-1"}, true},
+		{"byzantine", Node{ID: "node-1", Host: "host-1", Byzantine: "silent"}, true},
+		{"unknown strategy", Node{ID: "node-1", Host: "host-1", Byzantine: "sleepy"}, false},
+		{"byzantine dpos", Node{ID: "node-1", Host: "host-1", Algo: "dpos", Byzantine: "silent"}, false},
+		{"unknown algo", Node{ID: "node-1", Host: "host-1", Algo: "raft"}, false},
+	} {
+		spec := Spec{
+			Algo:  "pbft",
+			Hosts: []Host{{Name: "host-1", Addr: "127.0.0.1:2001"}},
+			Nodes: []Node{test.node},
+		}
+		if err := spec.Validate(); (err == nil) != test.ok {
+			t.Errorf("%s: validate returned %v", test.name, err)
+		}
+	}
+}
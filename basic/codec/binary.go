@@ -0,0 +1,379 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	binaryMagic  = 0xbc
+	binaryFormat = 1
+	// magic, format, type ID and schema version
+	headerSize = 1 + 1 + 2 + 2
+)
+
+var errShort = errors.New("binary message is truncated")
+
+// binaryCodec writes the exported fields of structs in order, without names:
+// integers as varints, strings and slices with their length, maps sorted by key, nil as length 0.
+// A header carries the type ID and schema version, a receiver refuses versions it does not know.
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) ContentType() string {
+	return "application/x-bccp-binary"
+}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	t := TypeOf(v)
+	buf := bytes.NewBuffer(make([]byte, 0, 256))
+	buf.Write([]byte{binaryMagic, binaryFormat})
+	writeUint16(buf, t.ID)
+	writeUint16(buf, t.Version)
+	value := reflect.ValueOf(v)
+	// A message sent by pointer reads back into a pointer to its type, so it carries no presence byte.
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, errors.New("binary marshal of a nil message")
+		}
+		value = value.Elem()
+	}
+	if err := encode(buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	if err := codec.Check(data); err != nil {
+		return err
+	}
+	if got, want := header(data), TypeOf(v); got != want {
+		return fmt.Errorf("binary message of type %d version %d does not fit type %d version %d",
+			got.ID, got.Version, want.ID, want.Version)
+	}
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return errors.New("binary unmarshal needs a non-nil pointer")
+	}
+	decoder := &decoder{data: data[headerSize:]}
+	if err := decoder.decode(target.Elem()); err != nil {
+		return err
+	}
+	if len(decoder.data) != 0 {
+		return errors.New("binary message has trailing bytes")
+	}
+	return nil
+}
+
+func (binaryCodec) Check(data []byte) error {
+	if len(data) < headerSize || data[0] != binaryMagic {
+		return ErrUnsupported
+	}
+	if data[1] != binaryFormat || !known(header(data)) {
+		return ErrVersion
+	}
+	return nil
+}
+
+func header(data []byte) Type {
+	return Type{
+		ID:      binary.BigEndian.Uint16(data[2:]),
+		Version: binary.BigEndian.Uint16(data[4:]),
+	}
+}
+
+func writeUint16(buf *bytes.Buffer, n uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], n)
+	buf.Write(b[:])
+}
+
+func writeUvarint(buf *bytes.Buffer, n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func writeVarint(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+// fields are the exported fields of a struct, as encoding/json sees them.
+func fields(t reflect.Type) []int {
+	indexes := make([]int, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeVarint(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUvarint(buf, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		buf.Write(b[:])
+	case reflect.String:
+		writeUvarint(buf, uint64(v.Len()))
+		buf.WriteString(v.String())
+	case reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteByte(0)
+			return nil
+		}
+		buf.WriteByte(1)
+		return encode(buf, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			writeUvarint(buf, 0)
+			return nil
+		}
+		writeUvarint(buf, uint64(v.Len())+1)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf.Write(v.Bytes())
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			writeUvarint(buf, 0)
+			return nil
+		}
+		writeUvarint(buf, uint64(v.Len())+1)
+		// Sort the entries by their encoded keys, so a map always has the same bytes.
+		entries := make([][2][]byte, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var key, value bytes.Buffer
+			if err := encode(&key, iter.Key()); err != nil {
+				return err
+			}
+			if err := encode(&value, iter.Value()); err != nil {
+				return err
+			}
+			entries = append(entries, [2][]byte{key.Bytes(), value.Bytes()})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i][0], entries[j][0]) < 0
+		})
+		for _, entry := range entries {
+			buf.Write(entry[0])
+			buf.Write(entry[1])
+		}
+	case reflect.Struct:
+		for _, i := range fields(v.Type()) {
+			if err := encode(buf, v.Field(i)); err != nil {
+				return fmt.Errorf("%s.%s: %w", v.Type().Name(), v.Type().Field(i).Name, err)
+			}
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return fmt.Errorf("%w: interface holding %s", ErrUnsupported, v.Elem().Type())
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupported, v.Type())
+	}
+	return nil
+}
+
+type decoder struct {
+	data []byte
+}
+
+func (decoder *decoder) byte() (byte, error) {
+	if len(decoder.data) == 0 {
+		return 0, errShort
+	}
+	b := decoder.data[0]
+	decoder.data = decoder.data[1:]
+	return b, nil
+}
+
+func (decoder *decoder) uvarint() (uint64, error) {
+	n, size := binary.Uvarint(decoder.data)
+	if size <= 0 {
+		return 0, errShort
+	}
+	decoder.data = decoder.data[size:]
+	return n, nil
+}
+
+func (decoder *decoder) varint() (int64, error) {
+	n, size := binary.Varint(decoder.data)
+	if size <= 0 {
+		return 0, errShort
+	}
+	decoder.data = decoder.data[size:]
+	return n, nil
+}
+
+// length reads a length and checks that the message may hold that many elements.
+func (decoder *decoder) length(offset uint64) (int, bool, error) {
+	n, err := decoder.uvarint()
+	if err != nil {
+		return 0, false, err
+	}
+	if n < offset {
+		return 0, true, nil
+	}
+	n -= offset
+	if n > uint64(len(decoder.data)) {
+		return 0, false, errShort
+	}
+	return int(n), false, nil
+}
+
+func (decoder *decoder) decode(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := decoder.byte()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := decoder.varint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("%d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := decoder.uvarint()
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("%d overflows %s", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if len(decoder.data) < 8 {
+			return errShort
+		}
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(decoder.data)))
+		decoder.data = decoder.data[8:]
+	case reflect.String:
+		n, _, err := decoder.length(0)
+		if err != nil {
+			return err
+		}
+		var s strings.Builder
+		s.Write(decoder.data[:n])
+		v.SetString(s.String())
+		decoder.data = decoder.data[n:]
+	case reflect.Ptr:
+		b, err := decoder.byte()
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := decoder.decode(elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Slice:
+		n, isNil, err := decoder.length(1)
+		if err != nil {
+			return err
+		}
+		if isNil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, n)
+			copy(data, decoder.data[:n])
+			v.SetBytes(data)
+			decoder.data = decoder.data[n:]
+			return nil
+		}
+		slice := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := decoder.decode(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := decoder.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, isNil, err := decoder.length(1)
+		if err != nil {
+			return err
+		}
+		if isNil {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := decoder.decode(key); err != nil {
+				return err
+			}
+			if err := decoder.decode(value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		for _, i := range fields(v.Type()) {
+			if err := decoder.decode(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Interface:
+		// Only nil interfaces are written.
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupported, v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type inner struct {
+	Name  string
+	Count uint32
+}
+
+type message struct {
+	Flag    bool
+	Small   int8
+	Big     int64
+	Unsized uint64
+	Ratio   float64
+	Text    string
+	Data    []byte
+	List    []int
+	Inners  []inner
+	Table   map[string]int
+	Pointer *inner
+	Fixed   [3]uint16
+	Skipped string `json:"-"`
+	hidden  int
+}
+
+type other struct {
+	Text string
+}
+
+type narrow struct {
+	Small int8
+}
+
+func init() {
+	Register(0xff01, 1, message{})
+	Register(0xff02, 1, other{})
+	Register(0xff03, 1, narrow{})
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		msg  *message
+	}{
+		{"zero", &message{}},
+		{"nil and empty differ", &message{Data: []byte{}, List: []int{}, Table: map[string]int{}}},
+		{"full", &message{
+			Flag:    true,
+			Small:   -128,
+			Big:     -1 << 62,
+			Unsized: 1<<64 - 1,
+			Ratio:   -0.125,
+			Text:    "héllo",
+			Data:    []byte{0, 1, 255},
+			List:    []int{3, -2, 1},
+			Inners:  []inner{{"a", 1}, {"", 0}},
+			Table:   map[string]int{"z": 26, "a": 1, "m": -13},
+			Pointer: &inner{"p", 7},
+			Fixed:   [3]uint16{1, 2, 65535},
+		}},
+	} {
+		data, err := Binary.Marshal(c.msg)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var got message
+		if err = Binary.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(&got, c.msg) {
+			t.Fatalf("%s: read back %+v, wrote %+v", c.name, got, *c.msg)
+		}
+	}
+}
+
+func TestBinarySkipsFields(t *testing.T) {
+	data, err := Binary.Marshal(&message{Skipped: "json skips it", hidden: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got message
+	if err = Binary.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Skipped != "" || got.hidden != 0 {
+		t.Fatalf("fields hidden from json are written: %+v", got)
+	}
+}
+
+// TestBinaryMapOrder checks a map is written the same whatever the order of its iteration.
+func TestBinaryMapOrder(t *testing.T) {
+	table := make(map[string]int)
+	for _, key := range []string{"q", "w", "e", "r", "t", "y", "u", "i", "o", "p"} {
+		table[key] = len(key)
+	}
+	first, err := Binary.Marshal(&message{Table: table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		data, err := Binary.Marshal(&message{Table: table})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, first) {
+			t.Fatal("the same map is written as other bytes")
+		}
+	}
+}
+
+func TestBinaryHeader(t *testing.T) {
+	data, err := Binary.Marshal(&other{Text: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(change func(data []byte) []byte) []byte {
+		return change(append([]byte(nil), data...))
+	}
+	for _, c := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"short", data[:headerSize-1], ErrUnsupported},
+		{"magic", with(func(d []byte) []byte { d[0] = '{'; return d }), ErrUnsupported},
+		{"format", with(func(d []byte) []byte { d[1] = binaryFormat + 1; return d }), ErrVersion},
+		{"version", with(func(d []byte) []byte { binary.BigEndian.PutUint16(d[4:], 2); return d }), ErrVersion},
+		{"unknown type", with(func(d []byte) []byte { binary.BigEndian.PutUint16(d[2:], 0xfffe); return d }), ErrVersion},
+	} {
+		if err := Binary.Check(c.data); !errors.Is(err, c.want) {
+			t.Fatalf("%s: check returned %v, want %v", c.name, err, c.want)
+		}
+		var got other
+		if err := Binary.Unmarshal(c.data, &got); !errors.Is(err, c.want) {
+			t.Fatalf("%s: unmarshal returned %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	// A known type does not read into another one.
+	var got message
+	if err = Binary.Unmarshal(data, &got); err == nil {
+		t.Fatal("message of one type read into another")
+	}
+}
+
+func TestBinaryBounds(t *testing.T) {
+	data, err := Binary.Marshal(&message{Text: "text", List: []int{1, 2}, Table: map[string]int{"a": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every cut of the message is refused.
+	for size := headerSize; size < len(data); size++ {
+		var got message
+		if err = Binary.Unmarshal(data[:size], &got); err == nil {
+			t.Fatalf("message cut to %d of %d bytes is read", size, len(data))
+		}
+	}
+	var got message
+	if err = Binary.Unmarshal(append(data, 0), &got); err == nil {
+		t.Fatal("message with a trailing byte is read")
+	}
+
+	// A length beyond the message is refused before anything is allocated.
+	for _, length := range []uint64{5, 1 << 40, 1<<64 - 1} {
+		var buf bytes.Buffer
+		buf.Write([]byte{binaryMagic, binaryFormat})
+		writeUint16(&buf, 0xff02)
+		writeUint16(&buf, 1)
+		writeUvarint(&buf, length)
+		buf.WriteString("text")
+		var got other
+		if err = Binary.Unmarshal(buf.Bytes(), &got); !errors.Is(err, errShort) {
+			t.Fatalf("string of length %d in 4 bytes returned %v", length, err)
+		}
+	}
+
+	// An integer wider than its field is refused.
+	var buf bytes.Buffer
+	buf.Write([]byte{binaryMagic, binaryFormat})
+	writeUint16(&buf, 0xff03)
+	writeUint16(&buf, 1)
+	writeVarint(&buf, 300)
+	var small narrow
+	if err = Binary.Unmarshal(buf.Bytes(), &small); err == nil {
+		t.Fatalf("300 is read into an int8 as %d", small.Small)
+	}
+}
+
+func TestBinaryUnsupported(t *testing.T) {
+	type holder struct {
+		Any interface{}
+	}
+	if _, err := Binary.Marshal(&holder{Any: 1}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("interface holding a value returned %v", err)
+	}
+	if _, err := Binary.Marshal(&struct{ C chan int }{}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("channel returned %v", err)
+	}
+	if _, err := Binary.Marshal((*other)(nil)); err == nil {
+		t.Fatal("nil message is written")
+	}
+}
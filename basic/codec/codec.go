@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrUnsupported = errors.New("message encoding is not supported")
+	ErrVersion     = errors.New("message schema version is not supported")
+)
+
+// Codec turns messages into bytes on the wire and back.
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	// Check tells if data can be unmarshalled by this node, before a handler tries it.
+	Check(data []byte) error
+}
+
+var (
+	JSON   Codec = jsonCodec{}
+	Binary Codec = binaryCodec{}
+	// codecs in order of preference
+	Codecs = []Codec{Binary, JSON}
+	// Default is the codec of a sender which prefers none, see Preference.
+	Default = JSON
+)
+
+// ByName finds the codec called name, e.g. json or binary.
+func ByName(name string) (Codec, bool) {
+	for _, codec := range Codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// ByContentType finds the codec of a request, JSON if it has no content type.
+func ByContentType(contentType string) (Codec, bool) {
+	if contentType == "" {
+		return JSON, true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	for _, codec := range Codecs {
+		if codec.ContentType() == mediaType {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// Accepted lists the content types this node reads, for the Accept header of a refusal.
+func Accepted() string {
+	types := make([]string, 0, len(Codecs))
+	for _, codec := range Codecs {
+		types = append(types, codec.ContentType())
+	}
+	return strings.Join(types, ", ")
+}
+
+// Accept checks that the body of request is in a known codec and schema version,
+// the body stays readable afterwards.
+func Accept(request *http.Request) error {
+	codec, ok := ByContentType(request.Header.Get("Content-Type"))
+	if !ok {
+		return ErrUnsupported
+	}
+	data, err := io.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	request.Body.Close()
+	request.Body = io.NopCloser(bytes.NewReader(data))
+	return codec.Check(data)
+}
+
+// Decode unmarshals the body of request into v by the codec of its content type.
+func Decode(request *http.Request, v interface{}) error {
+	codec, ok := ByContentType(request.Header.Get("Content-Type"))
+	if !ok {
+		return ErrUnsupported
+	}
+	data, err := io.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Check passes everything, JSON readers skip unknown fields and leave missing ones zero.
+func (jsonCodec) Check(_ []byte) error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Type identifies a message struct on the wire, Version grows with every change of its fields.
+type Type struct {
+	ID      uint16
+	Version uint16
+}
+
+var (
+	types   = make(map[reflect.Type]Type)
+	byID    = make(map[uint16]Type)
+	typesMu sync.RWMutex
+)
+
+// Register gives the struct type of prototype a type ID and schema version, IDs must be unique.
+func Register(id uint16, version uint16, prototype interface{}) {
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	t := structOf(reflect.TypeOf(prototype))
+	if known, ok := byID[id]; ok && types[t] != known {
+		panic(fmt.Sprintf("codec: type ID %d is already registered", id))
+	}
+	types[t] = Type{ID: id, Version: version}
+	byID[id] = Type{ID: id, Version: version}
+}
+
+// TypeOf finds the registration of the message v, zero if it has none.
+func TypeOf(v interface{}) Type {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	return types[structOf(reflect.TypeOf(v))]
+}
+
+// known tells if t is a type ID and version this node reads.
+func known(t Type) bool {
+	if t.ID == 0 {
+		return t.Version == 0
+	}
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	return byID[t.ID] == t
+}
+
+func structOf(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,42 @@
+package codec
+
+import "testing"
+
+func TestRegister(t *testing.T) {
+	for _, v := range []interface{}{other{}, &other{}, new(*other)} {
+		if got := TypeOf(v); got != (Type{ID: 0xff02, Version: 1}) {
+			t.Fatalf("%T is registered as %+v", v, got)
+		}
+	}
+	if got := TypeOf(struct{ Unknown int }{}); got != (Type{}) {
+		t.Fatalf("type never registered is %+v", got)
+	}
+
+	// Registering a type again under its ID is allowed, another type under the ID is not.
+	Register(0xff02, 1, other{})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("two types registered under one ID")
+			}
+		}()
+		Register(0xff02, 1, narrow{})
+	}()
+}
+
+func TestKnown(t *testing.T) {
+	for _, c := range []struct {
+		t     Type
+		known bool
+	}{
+		{Type{}, true},
+		{Type{Version: 1}, false},
+		{Type{ID: 0xff02, Version: 1}, true},
+		{Type{ID: 0xff02, Version: 2}, false},
+		{Type{ID: 0xfffe, Version: 1}, false},
+	} {
+		if known(c.t) != c.known {
+			t.Fatalf("%+v known %t", c.t, !c.known)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Wire counts the messages of one type sent by this process and their bytes.
+type Wire struct {
+	Messages int64 `json:"messages"`
+	Bytes    int64 `json:"bytes"`
+}
+
+var (
+	// operation to codec name to its counters
+	wires = make(map[string]map[string]*Wire)
+	// peer to the codec it accepts, peers refusing the codec of a sender are sent JSON
+	peers  = make(map[string]Codec)
+	wireMu sync.Mutex
+)
+
+// Preference is the codec a sender writes with, it may change while the sender is sending.
+type Preference struct {
+	codec Codec
+	mutex sync.RWMutex
+}
+
+func NewPreference(codec Codec) *Preference {
+	return &Preference{codec: codec}
+}
+
+// Codec is the codec the sender writes with, Default when none is set.
+func (preference *Preference) Codec() Codec {
+	preference.mutex.RLock()
+	defer preference.mutex.RUnlock()
+	if preference.codec == nil {
+		return Default
+	}
+	return preference.codec
+}
+
+// UseCodec makes the sender write with codec from now on, peers refusing it get JSON.
+func (preference *Preference) UseCodec(codec Codec) {
+	preference.mutex.Lock()
+	defer preference.mutex.Unlock()
+	preference.codec = codec
+}
+
+// Encode marshals message for peer by the preferred codec, or by JSON if peer refused it.
+func (preference *Preference) Encode(peer string, operation string, message interface{}) (Codec, []byte, error) {
+	return Encode(preference.Codec(), peer, operation, message)
+}
+
+// Of is the codec sender writes with when it tells one, Default otherwise.
+func Of(sender interface{}) Codec {
+	if preferring, ok := sender.(interface{ Codec() Codec }); ok {
+		return preferring.Codec()
+	}
+	return Default
+}
+
+// Encode marshals message for peer by codec, or by JSON if peer refused it, and counts the bytes for operation.
+// A message the codec can not write goes as JSON.
+func Encode(codec Codec, peer string, operation string, message interface{}) (Codec, []byte, error) {
+	wireMu.Lock()
+	if accepted, ok := peers[peer]; ok {
+		codec = accepted
+	}
+	wireMu.Unlock()
+	data, err := codec.Marshal(message)
+	if err != nil && codec != JSON {
+		codec = JSON
+		data, err = codec.Marshal(message)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	Count(operation, codec, len(data))
+	return codec, data, nil
+}
+
+// Refused tells if peer refused a message for its encoding, and sends JSON to it from now on.
+func Refused(peer string, resp *http.Response) bool {
+	if resp == nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		return false
+	}
+	wireMu.Lock()
+	defer wireMu.Unlock()
+	if peers[peer] == JSON {
+		return false
+	}
+	peers[peer] = JSON
+	return true
+}
+
+func Count(operation string, codec Codec, bytes int) {
+	wireMu.Lock()
+	defer wireMu.Unlock()
+	byCodec, ok := wires[operation]
+	if !ok {
+		byCodec = make(map[string]*Wire)
+		wires[operation] = byCodec
+	}
+	wire, ok := byCodec[codec.Name()]
+	if !ok {
+		wire = &Wire{}
+		byCodec[codec.Name()] = wire
+	}
+	wire.Messages++
+	wire.Bytes += int64(bytes)
+}
+
+// Stats copies the counters, by operation and codec name.
+func Stats() map[string]map[string]Wire {
+	wireMu.Lock()
+	defer wireMu.Unlock()
+	stats := make(map[string]map[string]Wire, len(wires))
+	for operation, byCodec := range wires {
+		stats[operation] = make(map[string]Wire, len(byCodec))
+		for name, wire := range byCodec {
+			stats[operation][name] = *wire
+		}
+	}
+	return stats
+}
+
+func ResetStats() {
+	wireMu.Lock()
+	defer wireMu.Unlock()
+	wires = make(map[string]map[string]*Wire)
+}
+
+// Report prints the stats one line per operation and codec.
+func Report(stats map[string]map[string]Wire) string {
+	lines := make([]string, 0)
+	for operation, byCodec := range stats {
+		for name, wire := range byCodec {
+			average := int64(0)
+			if wire.Messages != 0 {
+				average = wire.Bytes / wire.Messages
+			}
+			lines = append(lines, fmt.Sprintf("%s %s: %d messages, %d bytes, %d bytes/message",
+				operation, name, wire.Messages, wire.Bytes, average))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
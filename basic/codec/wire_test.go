@@ -0,0 +1,117 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPreference(t *testing.T) {
+	preference := NewPreference(nil)
+	if preference.Codec() != Default {
+		t.Fatalf("sender preferring no codec writes %s", preference.Codec().Name())
+	}
+	preference.UseCodec(Binary)
+	if Of(preference) != Binary {
+		t.Fatalf("sender is told to write %s", Of(preference).Name())
+	}
+	if Of(struct{}{}) != Default {
+		t.Fatal("sender telling no codec does not write the default")
+	}
+
+	c, data, err := preference.Encode("preference-peer", "op", &other{Text: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != Binary || data[0] != binaryMagic {
+		t.Fatalf("message is written as %s", c.Name())
+	}
+	// A message the binary codec can not write goes as JSON.
+	if c, _, err = preference.Encode("preference-peer", "op", map[string]interface{}{"a": 1}); err != nil || c != JSON {
+		t.Fatalf("unsupported message is written as %v, %v", c, err)
+	}
+}
+
+func TestRefused(t *testing.T) {
+	preference := NewPreference(Binary)
+	refusal := &http.Response{StatusCode: http.StatusUnsupportedMediaType}
+	for _, c := range []struct {
+		name    string
+		resp    *http.Response
+		refused bool
+	}{
+		{"no response", nil, false},
+		{"accepted", &http.Response{StatusCode: http.StatusOK}, false},
+		{"refused", refusal, true},
+		{"refused as json", refusal, false},
+	} {
+		if refused := Refused("refusing-peer", c.resp); refused != c.refused {
+			t.Fatalf("%s: refused %t", c.name, refused)
+		}
+	}
+	if c, _, err := preference.Encode("refusing-peer", "op", &other{}); err != nil || c != JSON {
+		t.Fatalf("peer refusing binary is sent %v, %v", c, err)
+	}
+	if c, _, err := preference.Encode("accepting-peer", "op", &other{}); err != nil || c != Binary {
+		t.Fatalf("peer accepting binary is sent %v, %v", c, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	ResetStats()
+	defer ResetStats()
+	for _, c := range []Codec{JSON, Binary, Binary} {
+		if _, _, err := Encode(c, "stats-peer", "op", &other{Text: "text"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := Stats()
+	if stats["op"]["json"].Messages != 1 || stats["op"]["binary"].Messages != 2 {
+		t.Fatalf("counted %+v", stats["op"])
+	}
+	if stats["op"]["binary"].Bytes >= 2*stats["op"]["json"].Bytes {
+		t.Fatalf("binary takes %d bytes for 2 messages, json %d for 1", stats["op"]["binary"].Bytes, stats["op"]["json"].Bytes)
+	}
+	report := Report(stats)
+	if lines := strings.Split(report, "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], "op binary: 2 messages") {
+		t.Fatalf("report is %q", report)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	data, err := Binary.Marshal(&other{Text: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        error
+	}{
+		{"json", "application/json; charset=utf-8", []byte(`{"Text":"text"}`), nil},
+		{"no content type", "", []byte(`{"Text":"text"}`), nil},
+		{"binary", Binary.ContentType(), data, nil},
+		{"unknown content type", "text/plain", []byte("text"), ErrUnsupported},
+		{"binary of another version", Binary.ContentType(), append([]byte{binaryMagic, binaryFormat, 0xff, 0x02, 0, 9}, data[headerSize:]...), ErrVersion},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/node", bytes.NewReader(c.body))
+		if c.contentType != "" {
+			request.Header.Set("Content-Type", c.contentType)
+		}
+		if err := Accept(request); !errors.Is(err, c.want) {
+			t.Fatalf("%s: accept returned %v, want %v", c.name, err, c.want)
+		}
+		if c.want != nil {
+			continue
+		}
+		// The body stays readable after Accept.
+		var got other
+		if err := Decode(request, &got); err != nil || got.Text != "text" {
+			t.Fatalf("%s: decoded %+v, %v", c.name, got, err)
+		}
+	}
+}
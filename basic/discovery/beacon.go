@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Beacons go between the ports BeaconPort to BeaconPort+BeaconPorts-1 on loopback,
+// each server on the machine listens on the first free one.
+const (
+	BeaconPort   = 47600
+	BeaconPorts  = 16
+	BeaconPeriod = time.Second
+)
+
+var ErrNoBeaconPort = errors.New("all beacon ports are taken")
+
+type beaconMsg struct {
+	Addr string `json:"addr"`
+}
+
+// Beacon announces the address of a server to the other servers on this machine, like mDNS
+// without multicast, which loopback does not carry.
+type Beacon struct {
+	Addr string
+	conn *net.UDPConn
+	done chan struct{}
+}
+
+// ListenBeacon announces addr, and calls found with the addresses announced by the others.
+func ListenBeacon(addr string, found func(addr string)) (*Beacon, error) {
+	var conn *net.UDPConn
+	for port := BeaconPort; port < BeaconPort+BeaconPorts; port++ {
+		c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+		if err == nil {
+			conn = c
+			break
+		}
+	}
+	if conn == nil {
+		return nil, ErrNoBeaconPort
+	}
+	beacon := &Beacon{addr, conn, make(chan struct{})}
+	go beacon.listen(found)
+	go beacon.announce()
+	return beacon, nil
+}
+
+func (beacon *Beacon) listen(found func(addr string)) {
+	buf := make([]byte, 1024)
+	for {
+		n, _, err := beacon.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-beacon.done:
+			default:
+				log.Println("beacon:", err)
+			}
+			return
+		}
+		var msg beaconMsg
+		if json.Unmarshal(buf[:n], &msg) != nil || msg.Addr == "" || msg.Addr == beacon.Addr {
+			continue
+		}
+		found(msg.Addr)
+	}
+}
+
+func (beacon *Beacon) announce() {
+	msg, _ := json.Marshal(beaconMsg{beacon.Addr})
+	own := beacon.conn.LocalAddr().(*net.UDPAddr).Port
+	for {
+		for port := BeaconPort; port < BeaconPort+BeaconPorts; port++ {
+			if port == own {
+				continue
+			}
+			to, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:"+strconv.Itoa(port))
+			// Nobody listens on most ports, the error is expected.
+			beacon.conn.WriteToUDP(msg, to)
+		}
+		select {
+		case <-time.After(BeaconPeriod):
+		case <-beacon.done:
+			return
+		}
+	}
+}
+
+func (beacon *Beacon) Close() error {
+	close(beacon.done)
+	return beacon.conn.Close()
+}
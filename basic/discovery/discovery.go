@@ -0,0 +1,352 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Path of the peer exchange endpoint on every server.
+const Path = "/peers"
+
+var ErrNoAdvertise = errors.New("discovery needs the address this server is reached at")
+
+type Config struct {
+	// address other servers reach this one at, e.g. 106.3.97.70:1000
+	Advertise string `json:"advertise"`
+	// servers asked for their peers until they answer
+	Bootstrap []string `json:"bootstrap"`
+	// peers exchanged with once every Period
+	Fanout int           `json:"fanout"`
+	Period time.Duration `json:"period"`
+	// a server whose heartbeat has not advanced for Timeout has left
+	Timeout time.Duration `json:"timeout"`
+	// find the servers on this machine by beacons on loopback, see Beacon
+	Local bool `json:"local"`
+}
+
+var DefaultConfig = Config{
+	Fanout:  3,
+	Period:  time.Second,
+	Timeout: 5 * time.Second,
+}
+
+// Member is a server as its peers know it. Only the server itself raises Seq,
+// the copy with the highest Seq wins. Seq starts from the time the server started,
+// so a restarted server outruns the copies of its previous run, left or lost.
+type Member struct {
+	Addr string `json:"addr"`
+	// address of its TCP transport, empty over HTTP
+	TCP   string   `json:"tcp,omitempty"`
+	Nodes []string `json:"nodes"`
+	Seq   uint64   `json:"seq"`
+	Left  bool     `json:"left,omitempty"`
+}
+
+type member struct {
+	Member
+	// when Seq last advanced here
+	updated time.Time
+}
+
+// Discovery keeps the RouteTable of a server in line with the nodes of all servers it can reach,
+// learned by exchanging member lists with bootstrap servers and the peers they tell.
+type Discovery struct {
+	Config
+	Server  *server.Server
+	members map[string]*member
+	// address to the last Seq of the servers left or lost, older copies of them are ignored
+	gone map[string]uint64
+	// servers heard of but not exchanged with yet
+	seeds map[string]bool
+	// id to address of the routes set by discovery, manual routes are left alone
+	routed map[string]string
+	seq    uint64
+	beacon *Beacon
+	done   chan struct{}
+	rand   *rand.Rand
+	mutex  sync.Mutex
+}
+
+func New(srv *server.Server, config Config) *Discovery {
+	discovery := &Discovery{
+		Config:  config,
+		Server:  srv,
+		members: make(map[string]*member),
+		gone:    make(map[string]uint64),
+		seeds:   make(map[string]bool),
+		routed:  make(map[string]string),
+		done:    make(chan struct{}),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		seq:     uint64(time.Now().UnixNano()),
+	}
+	for _, addr := range config.Bootstrap {
+		if addr != config.Advertise {
+			discovery.seeds[addr] = true
+		}
+	}
+	return discovery
+}
+
+// Start serves the peer exchange endpoint and exchanges once every Period until Stop.
+func (discovery *Discovery) Start() error {
+	if discovery.Advertise == "" {
+		return ErrNoAdvertise
+	}
+	discovery.Server.Handle(Path, discovery.HandlePeers)
+	if discovery.Local {
+		beacon, err := ListenBeacon(discovery.Advertise, discovery.Seed)
+		if err != nil {
+			return err
+		}
+		discovery.beacon = beacon
+	}
+	go func() {
+		ticker := time.NewTicker(discovery.Period)
+		defer ticker.Stop()
+		for {
+			discovery.round()
+			select {
+			case <-ticker.C:
+			case <-discovery.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop tells the known servers this one leaves, they drop its routes at once.
+func (discovery *Discovery) Stop() {
+	close(discovery.done)
+	if discovery.beacon != nil {
+		discovery.beacon.Close()
+	}
+	discovery.mutex.Lock()
+	discovery.seq++
+	self := discovery.self()
+	self.Left = true
+	view := []Member{self}
+	peers := discovery.live()
+	discovery.mutex.Unlock()
+	for _, addr := range peers {
+		discovery.exchange(addr, view)
+	}
+}
+
+// Seed adds a server to exchange with, like a bootstrap server.
+func (discovery *Discovery) Seed(addr string) {
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+	if addr == discovery.Advertise {
+		return
+	}
+	if _, ok := discovery.members[addr]; !ok {
+		discovery.seeds[addr] = true
+	}
+}
+
+// Members are the servers known to be up, this one included, ordered by address.
+func (discovery *Discovery) Members() []Member {
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+	return discovery.view()
+}
+
+// HandlePeers merges the member list posted by a peer, and answers with this server's list.
+func (discovery *Discovery) HandlePeers(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == http.MethodPost {
+		var view []Member
+		if err := json.NewDecoder(request.Body).Decode(&view); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		discovery.merge(view)
+		discovery.sync()
+	}
+	discovery.mutex.Lock()
+	view := discovery.view()
+	discovery.mutex.Unlock()
+	json.NewEncoder(writer).Encode(view)
+}
+
+// round exchanges with the seeds and Fanout random peers, then drops silent servers and fixes the routes.
+func (discovery *Discovery) round() {
+	discovery.mutex.Lock()
+	discovery.seq++
+	targets := make([]string, 0)
+	for addr := range discovery.seeds {
+		targets = append(targets, addr)
+	}
+	peers := discovery.live()
+	discovery.rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if len(peers) > discovery.Fanout {
+		peers = peers[:discovery.Fanout]
+	}
+	targets = append(targets, peers...)
+	view := discovery.view()
+	discovery.mutex.Unlock()
+
+	for _, addr := range targets {
+		discovery.exchange(addr, view)
+	}
+	discovery.expire()
+	discovery.sync()
+}
+
+func (discovery *Discovery) exchange(addr string, view []Member) {
+	body, _ := json.Marshal(view)
+	url := discovery.Server.Scheme() + "://" + addr + Path
+	resp, err := discovery.Server.Client().Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Println("discovery:", err)
+		return
+	}
+	defer resp.Body.Close()
+	var answer []Member
+	if err = json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		log.Println("discovery:", addr, err)
+		return
+	}
+	discovery.mutex.Lock()
+	delete(discovery.seeds, addr)
+	discovery.mutex.Unlock()
+	discovery.merge(answer)
+}
+
+func (discovery *Discovery) merge(view []Member) {
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+	now := time.Now()
+	for _, m := range view {
+		if m.Addr == "" || m.Addr == discovery.Advertise {
+			continue
+		}
+		if seq, ok := discovery.gone[m.Addr]; ok && m.Seq <= seq {
+			continue
+		}
+		known, ok := discovery.members[m.Addr]
+		if ok && m.Seq <= known.Seq {
+			continue
+		}
+		delete(discovery.seeds, m.Addr)
+		if m.Left {
+			log.Println("discovery: leave", m.Addr)
+			discovery.gone[m.Addr] = m.Seq
+			delete(discovery.members, m.Addr)
+			continue
+		}
+		if !ok {
+			log.Println("discovery: join", m.Addr)
+			delete(discovery.gone, m.Addr)
+		}
+		discovery.members[m.Addr] = &member{m, now}
+	}
+}
+
+// expire drops the servers silent for Timeout.
+func (discovery *Discovery) expire() {
+	discovery.mutex.Lock()
+	defer discovery.mutex.Unlock()
+	now := time.Now()
+	for addr, m := range discovery.members {
+		if now.Sub(m.updated) <= discovery.Timeout {
+			continue
+		}
+		log.Println("discovery: lost", addr)
+		discovery.gone[addr] = m.Seq
+		delete(discovery.members, addr)
+		// A lost bootstrap server may come back.
+		for _, seed := range discovery.Bootstrap {
+			if seed == addr {
+				discovery.seeds[addr] = true
+			}
+		}
+	}
+}
+
+// sync routes the nodes of live servers, and unroutes the nodes gone alone or with their server.
+func (discovery *Discovery) sync() {
+	discovery.mutex.Lock()
+	want := make(map[string]string)
+	// server address to the address of its TCP transport
+	transports := make(map[string]string)
+	for _, m := range discovery.members {
+		for _, id := range m.Nodes {
+			want[id] = m.Addr
+		}
+		if m.TCP != "" {
+			transports[m.Addr] = m.TCP
+		}
+	}
+	for _, id := range discovery.Server.Operators() {
+		want[id] = discovery.Advertise
+	}
+	stale := make(map[string]string)
+	for id, addr := range discovery.routed {
+		if _, ok := want[id]; !ok {
+			stale[id] = addr
+		}
+	}
+	discovery.routed = want
+	discovery.mutex.Unlock()
+
+	for id, addr := range stale {
+		// The route may have been set by hand since.
+		if current, ok := discovery.Server.Lookup(id); ok && current == addr {
+			discovery.Server.Unroute(id)
+		}
+	}
+	for addr, transport := range transports {
+		discovery.Server.SetTransport(addr, transport)
+	}
+	for id, addr := range want {
+		if current, ok := discovery.Server.Lookup(id); !ok || current != addr {
+			discovery.Server.Route(id, addr)
+		}
+	}
+}
+
+// self is the entry of this server, call with mutex held.
+func (discovery *Discovery) self() Member {
+	self := Member{
+		Addr:  discovery.Advertise,
+		Nodes: discovery.Server.Operators(),
+		Seq:   discovery.seq,
+	}
+	if tcp := discovery.Server.TCP(); tcp != nil {
+		self.TCP = tcp.Addr()
+	}
+	return self
+}
+
+// view lists this server and the known ones, call with mutex held.
+func (discovery *Discovery) view() []Member {
+	view := []Member{discovery.self()}
+	for _, m := range discovery.members {
+		view = append(view, m.Member)
+	}
+	sort.Slice(view, func(i, j int) bool {
+		return view[i].Addr < view[j].Addr
+	})
+	return view
+}
+
+// live are the addresses of the servers up, call with mutex held.
+func (discovery *Discovery) live() []string {
+	peers := make([]string, 0, len(discovery.members))
+	for addr := range discovery.members {
+		peers = append(peers, addr)
+	}
+	sort.Strings(peers)
+	return peers
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"github.com/glimmerzcy/bccp/basic/server"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type idle struct{}
+
+func (idle) DoOperation(_ string, writer http.ResponseWriter, _ *http.Request) {
+	writer.WriteHeader(http.StatusOK)
+}
+
+// cluster starts n servers on loopback holding one node each, all bootstrapped from the first.
+func cluster(t *testing.T, n int) ([]*server.Server, []*Discovery) {
+	servers := make([]*server.Server, 0, n)
+	discoveries := make([]*Discovery, 0, n)
+	for i := 1; i <= n; i++ {
+		srv, err := server.New(server.Config{Listen: "127.0.0.1:0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = srv.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			srv.Close()
+		})
+		if err = srv.UseTCP("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		srv.OperatorTable.Put("node-"+strconv.Itoa(i), idle{})
+		config := Config{Advertise: srv.Addr(), Fanout: 2, Period: 20 * time.Millisecond, Timeout: 300 * time.Millisecond}
+		if len(servers) != 0 {
+			config.Bootstrap = []string{servers[0].Addr()}
+		}
+		servers = append(servers, srv)
+		discoveries = append(discoveries, New(srv, config))
+	}
+	for _, discovery := range discoveries {
+		if err := discovery.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return servers, discoveries
+}
+
+// eventually waits until check holds, failing with the message it returns last.
+func eventually(t *testing.T, check func() string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		message := check()
+		if message == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// routed checks every server routes the nodes in want to their servers, and no other node.
+func routed(servers []*server.Server, want map[string]string) string {
+	for _, srv := range servers {
+		ids := srv.IDs()
+		if len(ids) != len(want) {
+			return srv.Addr() + " routes " + strconv.Itoa(len(ids)) + " nodes"
+		}
+		for id, addr := range want {
+			if current, _ := srv.Lookup(id); current != addr {
+				return srv.Addr() + " routes " + id + " to " + current
+			}
+		}
+	}
+	return ""
+}
+
+func TestDiscovery(t *testing.T) {
+	servers, discoveries := cluster(t, 3)
+	want := make(map[string]string)
+	for i, srv := range servers {
+		want["node-"+strconv.Itoa(i+1)] = srv.Addr()
+	}
+	eventually(t, func() string {
+		return routed(servers, want)
+	})
+	if members := discoveries[1].Members(); len(members) != 3 {
+		t.Fatalf("%d members known", len(members))
+	}
+
+	// A transport replaced while the servers exchange is followed by the peers.
+	if err := servers[0].UseTCP("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() string {
+		if addr, _ := servers[1].TCP().RouteTable.Get("node-1"); addr != servers[0].TCP().Addr() {
+			return "node-1 is routed to the transport at " + addr
+		}
+		return ""
+	})
+
+	// A server leaving tells the others, its node is unrouted at once.
+	discoveries[2].Stop()
+	delete(want, "node-3")
+	eventually(t, func() string {
+		return routed(servers[:2], want)
+	})
+
+	// A server falling silent, without saying it leaves, is dropped after the timeout.
+	close(discoveries[1].done)
+	servers[1].Close()
+	silent := time.Now()
+	delete(want, "node-2")
+	eventually(t, func() string {
+		return routed(servers[:1], want)
+	})
+	if waited := time.Since(silent); waited < discoveries[0].Timeout/2 {
+		t.Fatalf("silent server dropped after %s, before the timeout", waited)
+	}
+	discoveries[0].Stop()
+}
@@ -4,11 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/glimmerzcy/bccp/basic/center"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/forkchoice"
+	"github.com/glimmerzcy/bccp/basic/gossip"
 	util "github.com/glimmerzcy/bccp/basic/log"
+	"github.com/glimmerzcy/bccp/basic/node"
 	"github.com/glimmerzcy/bccp/basic/parse"
 	"github.com/glimmerzcy/bccp/basic/server"
 	_ "github.com/glimmerzcy/bccp/basic/server"
+	"github.com/glimmerzcy/bccp/basic/simulator"
+	"github.com/glimmerzcy/bccp/implement/dpos"
 	"github.com/glimmerzcy/bccp/implement/pbft"
+	"github.com/glimmerzcy/bccp/implement/poa"
 	"github.com/wcharczuk/go-chart"
 	"log"
 	"math"
@@ -23,13 +30,13 @@ var RouteTable = make(map[string]string)
 var NodeNum int
 var SleepTime time.Duration
 
-//106.3.97.70
-//106.3.97.36
-//106.3.97.28
-//106.3.97.67
-//106.3.97.45
-//106.3.97.120
-//106.3.97.212
+// 106.3.97.70
+// 106.3.97.36
+// 106.3.97.28
+// 106.3.97.67
+// 106.3.97.45
+// 106.3.97.120
+// 106.3.97.212
 var serverList = []string{
 	"106.3.97.70:1000",
 	"106.3.97.36:1000",
@@ -41,8 +48,8 @@ var serverList = []string{
 	"localhost:1000",
 }
 
-//curl "http://localhost:1000/server?operation=stop"
-//ps -aux | grep main
+// bccp status -server localhost:1000
+// bccp stop -server localhost:1000
 
 const prefix = "http://"
 const suffix = ":1000"
@@ -50,11 +57,13 @@ const suffix = ":1000"
 func AddNode() {
 	NodeNum++
 	nodeId := parse.ID2name(NodeNum)
-	RouteTable[nodeId] = parse.ID2url(NodeNum)
-	http.Get("http://localhost:1000/server?operation=new&id=" + nodeId)
-	http.Get("http://localhost:1000/server?operation=add&id=" + nodeId + "&msg=localhost:1000")
+	local := server.DefaultServer
+	RouteTable[nodeId] = local.Addr()
+	base := local.Scheme() + "://" + local.Addr()
+	local.Client().Get(base + "/server?operation=new&algo=pbft&id=" + nodeId)
+	local.Client().Get(base + "/server?operation=add&id=" + nodeId + "&msg=" + local.Addr())
 	for id := range RouteTable {
-		http.Get("http://localhost:1000/node?from=center&operation=add&to=" + id)
+		local.Client().Get(base + "/node?from=center&operation=add&to=" + id)
 	}
 	server.Send("center", nodeId, "setF", pbft.SetFMsg{Total: NodeNum})
 }
@@ -68,7 +77,7 @@ func Test(nodes int, times int) {
 	fmt.Println("jj")
 	center.Broadcast("stop", "", "")
 
-	//center.Send(1, "add", "node-5", "")
+	//center.Send(serverList[1], "add", "node-5", "")
 	//
 	//center.GetServer().Send("center", "node-2", "client", nil)
 
@@ -115,9 +124,296 @@ func TestServer(nodes int, times int) {
 		fmt.Println(i, avg[i], data[i])
 		log.Println(i, avg[i], data[i])
 	}
+	report := codec.Report(center.Stats())
+	fmt.Println(report)
+	log.Println(report)
 	draw(avg)
 }
 
+// TestMemory runs the experiment of TestServer with all nodes in this process, sending through memory.
+// It returns the average delay by the number of nodes.
+func TestMemory(nodes int, times int) []int {
+	rand.Seed(998244353)
+	util.LogInit()
+
+	memory := server.NewMemory(pbft.Factory{Name: "pbft"})
+	NodeNum = 0
+	addNode := func() {
+		NodeNum++
+		if _, err := memory.Add(parse.ID2name(NodeNum)); err != nil {
+			panic(err)
+		}
+		memory.Broadcast("center", "setF", pbft.SetFMsg{Total: NodeNum})
+	}
+	for NodeNum < 3 {
+		addNode()
+	}
+	data := make([][]int, nodes+1)
+	avg := make([]int, nodes+1)
+	for i := 0; i <= nodes; i++ {
+		data[i] = make([]int, times)
+		if i <= NodeNum {
+			continue
+		}
+		addNode()
+		for j := 0; j < times; j++ {
+			nodeId := parse.ID2name(rand.Intn(NodeNum) + 1)
+			resp, err := memory.Send("center", nodeId, "client", pbft.ClientMsg{Operation: "Test"})
+			if err != nil {
+				panic(err)
+			}
+			var msg pbft.ClientMsg
+			if err = json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+				panic(err)
+			}
+			data[i][j] = int(msg.Delay)
+		}
+		avg[i] = ArrayAverage(data[i])
+		fmt.Println(i, avg[i], data[i])
+		log.Println(i, avg[i], data[i])
+	}
+	report := codec.Report(codec.Stats())
+	fmt.Println(report)
+	log.Println(report)
+	draw(avg)
+	return avg
+}
+
+// TestChain runs a poa or dpos cluster of nodes in this process, all following the fork-choice rule,
+// sends times client requests to random nodes and logs the reorgs every node has made.
+func TestChain(algo string, rule string, nodes int, times int) map[string]forkchoice.Metrics {
+	rand.Seed(998244353)
+	util.LogInit()
+
+	factory, err := server.FactoryOf(algo)
+	if err != nil {
+		panic(err)
+	}
+	memory := server.NewMemory(factory)
+	ids := make([]string, 0, nodes)
+	for i := 1; i <= nodes; i++ {
+		ids = append(ids, parse.ID2name(i))
+		if _, err = memory.Add(parse.ID2name(i)); err != nil {
+			panic(err)
+		}
+	}
+	defer func() {
+		for _, id := range ids {
+			memory.Remove(id)
+		}
+	}()
+
+	var genesis, client interface{}
+	switch algo {
+	case "poa":
+		// Signers seal with keys of their own, the genesis lists them.
+		keys := make(map[string]string)
+		for _, id := range ids {
+			resp, err := memory.Send("center", id, "key", nil)
+			if err != nil {
+				panic(err)
+			}
+			var msg poa.KeyMsg
+			if err = json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+				panic(err)
+			}
+			keys[id] = msg.Key
+		}
+		genesis = poa.GenesisMsg{Timestamp: time.Now().UnixMilli(), Signers: ids, ForkChoice: rule, Keys: keys}
+		client = poa.ClientMsg{Operation: "Test"}
+	case "dpos":
+		stakes := make(map[string]int64)
+		for _, id := range ids {
+			stakes[id] = 1
+		}
+		genesis = dpos.GenesisMsg{Timestamp: time.Now().UnixMilli(), Stakes: stakes, Delegates: ids, ForkChoice: rule}
+		client = dpos.ClientMsg{Operation: "Test"}
+	default:
+		panic("no chain experiment for " + algo)
+	}
+	resps, errs := memory.Broadcast("center", "genesis", genesis)
+	for i, resp := range resps {
+		if errs[i] != nil {
+			panic(errs[i])
+		}
+		if resp.StatusCode != http.StatusOK {
+			panic(fmt.Sprintf("genesis answered %d", resp.StatusCode))
+		}
+	}
+
+	data := make([]int, times)
+	for i := range data {
+		resp, err := memory.Send("center", ids[rand.Intn(nodes)], "client", client)
+		if err != nil {
+			panic(err)
+		}
+		var msg struct{ Delay int64 }
+		if err = json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			panic(err)
+		}
+		data[i] = int(msg.Delay)
+	}
+	fmt.Println(algo, rule, ArrayAverage(data), data)
+	log.Println(algo, rule, ArrayAverage(data), data)
+	return LogReorgs(memory, ids)
+}
+
+// TestSimulator runs the experiment of TestServer on virtual time, the same seed always yields the same run.
+// It prints and returns the digest of the message trace to compare runs.
+func TestSimulator(seed int64, nodes int, times int) string {
+	return testSimulator(seed, pbft.Factory{Name: "pbft"}, nodes, times)
+}
+
+// TestGossip runs TestSimulator with replicas broadcasting by gossip.
+func TestGossip(seed int64, config gossip.Config, nodes int, times int) string {
+	return testSimulator(seed, pbft.Factory{Name: "pbft", Gossip: &config}, nodes, times)
+}
+
+func testSimulator(seed int64, factory pbft.Factory, nodes int, times int) string {
+	util.LogInit()
+
+	sim := simulator.New(seed, factory)
+	r := rand.New(rand.NewSource(seed))
+	NodeNum = 0
+	addNode := func() {
+		NodeNum++
+		if _, err := sim.Add(parse.ID2name(NodeNum)); err != nil {
+			panic(err)
+		}
+		sim.Broadcast("center", "setF", pbft.SetFMsg{Total: NodeNum})
+		sim.Run(time.Second)
+	}
+	for NodeNum < 3 {
+		addNode()
+	}
+	data := make([][]int, nodes+1)
+	avg := make([]int, nodes+1)
+	for i := 0; i <= nodes; i++ {
+		data[i] = make([]int, times)
+		if i <= NodeNum {
+			continue
+		}
+		addNode()
+		for j := 0; j < times; j++ {
+			nodeId := parse.ID2name(r.Intn(NodeNum) + 1)
+			operator, _ := sim.OperatorTable.Get(nodeId)
+			client := operator.(*pbft.Node)
+			// The client operation only starts the request on virtual time, the delay is waited for here.
+			sim.Send("center", nodeId, "client", pbft.ClientMsg{Operation: "Test"})
+			var delay int64 = -1
+			sim.RunUntil(func() bool {
+				select {
+				case delay = <-client.Client.Msg:
+					return true
+				default:
+					return false
+				}
+			}, sim.Now().Add(time.Second*30))
+			data[i][j] = int(delay)
+			sim.Run(time.Second)
+		}
+		avg[i] = ArrayAverage(data[i])
+		fmt.Println(i, avg[i], data[i])
+		log.Println(i, avg[i], data[i])
+	}
+	trace, _ := json.Marshal(sim.Trace)
+	digest := node.Hash(trace)
+	fmt.Println("messages", len(sim.Trace), "trace", digest)
+	log.Println("messages", len(sim.Trace), "trace", digest)
+	report := codec.Report(codec.Stats())
+	fmt.Println(report)
+	log.Println(report)
+	draw(avg)
+	return digest
+}
+
+// TestByzantine runs requests on virtual time with f replicas turned Byzantine by strategy,
+// then checks that the honest replicas committed the same blocks. It returns the requests finished and if they did.
+// An equivocating replica must be the primary, node-1.
+func TestByzantine(seed int64, nodes int, strategy string, times int) (int, bool) {
+	util.LogInit()
+
+	sim := simulator.New(seed, pbft.Factory{Name: "pbft"})
+	r := rand.New(rand.NewSource(seed))
+	replicas := make([]*pbft.Node, 0, nodes)
+	for i := 1; i <= nodes; i++ {
+		operator, err := sim.Add(parse.ID2name(i))
+		if err != nil {
+			panic(err)
+		}
+		replicas = append(replicas, operator.(*pbft.Node))
+	}
+	sim.Broadcast("center", "setF", pbft.SetFMsg{Total: nodes})
+	sim.Run(time.Second)
+
+	f := (nodes - 1) / 3
+	faulty := make(map[string]bool)
+	for i := 0; len(faulty) < f; i++ {
+		replica := replicas[nodes-1-i]
+		if strategy == "equivocate" && i == 0 {
+			replica = replicas[0]
+		}
+		if err := pbft.MakeByzantine(replica, strategy); err != nil {
+			panic(err)
+		}
+		faulty[replica.ID] = true
+	}
+	honest := make([]*pbft.Node, 0, nodes)
+	for _, replica := range replicas {
+		if !faulty[replica.ID] {
+			honest = append(honest, replica)
+		}
+	}
+
+	finished := 0
+	for j := 0; j < times; j++ {
+		client := honest[r.Intn(len(honest))]
+		sim.Go(func() {
+			if err := client.SendRequest("Test", nil); err != nil {
+				client.Println(err)
+			}
+		})
+		if sim.RunUntil(func() bool {
+			select {
+			case <-client.Client.Msg:
+				return true
+			default:
+				return false
+			}
+		}, sim.Now().Add(time.Second*30)) {
+			finished++
+		}
+		sim.Run(time.Second)
+	}
+
+	// Safety: at every height the honest replicas reached, they hold the same block.
+	safe := true
+	for height := int64(1); safe; height++ {
+		hash := ""
+		reached := false
+		for _, replica := range honest {
+			b, err := replica.Ledger.Get(height)
+			if err != nil {
+				continue
+			}
+			reached = true
+			if hash == "" {
+				hash = b.Hash
+			} else if hash != b.Hash {
+				safe = false
+				fmt.Println("safety violated at height", height)
+				log.Println("safety violated at height", height)
+			}
+		}
+		if !reached {
+			break
+		}
+	}
+	fmt.Println(strategy, "faulty", f, "of", nodes, "finished", finished, "of", times, "safe", safe)
+	log.Println(strategy, "faulty", f, "of", nodes, "finished", finished, "of", times, "safe", safe)
+	return finished, safe
+}
+
 func draw(data []int) {
 	xv, yv := make([]float64, len(data)), make([]float64, len(data))
 	for x, y := range data {
@@ -183,3 +479,35 @@ func ArrayMax(data []int) int {
 	}
 	return ans
 }
+
+// LogReorgs collects the fork-choice metrics of the nodes ids through sender into the experiment output,
+// nodes without a block tree are skipped.
+func LogReorgs(sender server.Sender, ids []string) map[string]forkchoice.Metrics {
+	all := make(map[string]forkchoice.Metrics)
+	total := forkchoice.NewMetrics()
+	for _, id := range ids {
+		resp, err := sender.Send("center", id, "metrics", nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		var metrics forkchoice.Metrics
+		err = json.NewDecoder(resp.Body).Decode(&metrics)
+		resp.Body.Close()
+		if err != nil {
+			continue
+		}
+		all[id] = metrics
+		total.Reorgs += metrics.Reorgs
+		total.Orphans += metrics.Orphans
+		for depth, count := range metrics.Depths {
+			total.Depths[depth] += count
+		}
+		if metrics.MaxDepth > total.MaxDepth {
+			total.MaxDepth = metrics.MaxDepth
+		}
+		log.Println(id, "reorgs", metrics.Reorgs, "max depth", metrics.MaxDepth, "depths", metrics.Depths, "orphans", metrics.Orphans)
+	}
+	fmt.Println("reorgs", total.Reorgs, "max depth", total.MaxDepth, "depths", total.Depths, "orphans", total.Orphans)
+	log.Println("reorgs", total.Reorgs, "max depth", total.MaxDepth, "depths", total.Depths, "orphans", total.Orphans)
+	return all
+}
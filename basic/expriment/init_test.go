@@ -0,0 +1,74 @@
+package expriment
+
+import (
+	"github.com/glimmerzcy/bccp/implement/pbft"
+	"os"
+	"sort"
+	"testing"
+)
+
+// TestMain runs in a temporary directory, the experiments and nodes write their logs to the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "expriment")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestByzantineStrategies(t *testing.T) {
+	strategies := make([]string, 0, len(pbft.Strategies))
+	for strategy := range pbft.Strategies {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	if len(strategies) != 5 {
+		t.Fatalf("strategies are %v", strategies)
+	}
+	for _, strategy := range strategies {
+		t.Run(strategy, func(t *testing.T) {
+			finished, safe := TestByzantine(1, 4, strategy, 3)
+			if !safe {
+				t.Fatalf("honest replicas committed different blocks with a %s replica", strategy)
+			}
+			if finished != 3 {
+				t.Fatalf("%d of 3 requests finished with a %s replica", finished, strategy)
+			}
+		})
+	}
+}
+
+func TestChainMetrics(t *testing.T) {
+	for _, run := range []struct{ algo, rule string }{{"poa", "ghost"}, {"dpos", "longest"}} {
+		t.Run(run.algo, func(t *testing.T) {
+			metrics := TestChain(run.algo, run.rule, 3, 2)
+			if len(metrics) != 3 {
+				t.Fatalf("metrics of %d of 3 nodes are logged", len(metrics))
+			}
+		})
+	}
+}
+
+func TestSimulatorDeterminism(t *testing.T) {
+	first, second := TestSimulator(7, 4, 2), TestSimulator(7, 4, 2)
+	if first != second {
+		t.Fatalf("runs of the same seed traced %s, then %s", first, second)
+	}
+	if other := TestSimulator(8, 4, 2); other == first {
+		t.Fatalf("runs of seeds 7 and 8 both traced %s", first)
+	}
+}
+
+func TestMemorySmoke(t *testing.T) {
+	avg := TestMemory(5, 2)
+	for nodes := 4; nodes <= 5; nodes++ {
+		if avg[nodes] <= 0 {
+			t.Fatalf("requests to %d nodes took %d on average", nodes, avg[nodes])
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package forkchoice
+
+// Metrics counts the reorganizations of one node, reported in the experiment output.
+type Metrics struct {
+	Reorgs   int         `json:"reorgs"`
+	MaxDepth int         `json:"maxDepth"`
+	Depths   map[int]int `json:"depths"`
+	Orphans  int         `json:"orphans"`
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Depths: make(map[int]int),
+	}
+}
+
+// Record accounts a head switch, extensions with depth 0 are not reorganizations.
+func (metrics *Metrics) Record(reorg *Reorg) {
+	if reorg.Depth == 0 {
+		return
+	}
+	metrics.Reorgs++
+	metrics.Depths[reorg.Depth]++
+	if reorg.Depth > metrics.MaxDepth {
+		metrics.MaxDepth = reorg.Depth
+	}
+}
@@ -0,0 +1,80 @@
+package forkchoice
+
+// Rule picks the head among the chains of a tree.
+type Rule interface {
+	Name() string
+	Head(tree *Tree) *Entry
+}
+
+// better tells if a beats b on score, the earlier arrival wins a tie.
+func better(aScore uint64, a *Entry, bScore uint64, b *Entry) bool {
+	if aScore != bScore {
+		return aScore > bScore
+	}
+	return a.Seq < b.Seq
+}
+
+// LongestChain picks the highest tip.
+type LongestChain struct{}
+
+func (LongestChain) Name() string {
+	return "longest"
+}
+
+func (LongestChain) Head(tree *Tree) *Entry {
+	head := tree.root
+	for _, leaf := range tree.Leaves() {
+		if better(uint64(leaf.Block.Height), leaf, uint64(head.Block.Height), head) {
+			head = leaf
+		}
+	}
+	return head
+}
+
+// HeaviestWork picks the tip with the most work in its chain.
+type HeaviestWork struct{}
+
+func (HeaviestWork) Name() string {
+	return "heaviest"
+}
+
+func (HeaviestWork) Head(tree *Tree) *Entry {
+	head := tree.root
+	for _, leaf := range tree.Leaves() {
+		if better(leaf.TotalWork, leaf, head.TotalWork, head) {
+			head = leaf
+		}
+	}
+	return head
+}
+
+// GHOST walks from the root into the child with the heaviest subtree, counting the work of uncles too.
+type GHOST struct{}
+
+func (GHOST) Name() string {
+	return "ghost"
+}
+
+func (GHOST) Head(tree *Tree) *Entry {
+	head := tree.root
+	for len(head.Children) != 0 {
+		next := head.Children[0]
+		for _, child := range head.Children[1:] {
+			if better(child.SubtreeWork, child, next.SubtreeWork, next) {
+				next = child
+			}
+		}
+		head = next
+	}
+	return head
+}
+
+// RuleByName returns the rule with name, nil if there is none.
+func RuleByName(name string) Rule {
+	for _, rule := range []Rule{LongestChain{}, HeaviestWork{}, GHOST{}} {
+		if rule.Name() == name {
+			return rule
+		}
+	}
+	return nil
+}
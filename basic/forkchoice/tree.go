@@ -0,0 +1,309 @@
+package forkchoice
+
+import (
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/block"
+)
+
+var (
+	ErrKnown   = errors.New("block is already in the tree")
+	ErrUnknown = errors.New("block is not in the tree")
+	ErrStale   = errors.New("block is not above the root")
+)
+
+// MaxOrphans is the number of orphans a tree keeps by default, the oldest are dropped beyond it.
+const MaxOrphans = 1024
+
+// Entry is a block connected to the tree.
+type Entry struct {
+	Block    *block.Block
+	Parent   *Entry
+	Children []*Entry
+	// work of the chain from the root to this block
+	TotalWork uint64
+	// work of this block and all its descendants
+	SubtreeWork uint64
+	// arrival order, earlier blocks win ties
+	Seq int64
+}
+
+// Tree keeps competing chains growing from a root, and blocks whose parent is not known yet.
+type Tree struct {
+	Rule Rule
+	// Work is the weight of one block, 1 for every block by default.
+	Work    func(b *block.Block) uint64
+	Metrics *Metrics
+	// orphans kept at most, the oldest are dropped beyond it
+	MaxOrphans int
+
+	root    *Entry
+	entries map[string]*Entry
+	// parent hash to the blocks waiting for it
+	orphans map[string][]*block.Block
+	// orphans in arrival order, those connected or dropped meanwhile are skipped
+	arrivals    []orphan
+	orphanCount int
+	head        *Entry
+	seq         int64
+}
+
+type orphan struct {
+	parent string
+	hash   string
+}
+
+func NewTree(root *block.Block, rule Rule) *Tree {
+	tree := &Tree{
+		Rule:       rule,
+		Work:       func(_ *block.Block) uint64 { return 1 },
+		Metrics:    NewMetrics(),
+		MaxOrphans: MaxOrphans,
+		entries:    make(map[string]*Entry),
+		orphans:    make(map[string][]*block.Block),
+		arrivals:   make([]orphan, 0),
+	}
+	tree.root = &Entry{Block: root, Children: make([]*Entry, 0)}
+	tree.entries[root.Hash] = tree.root
+	tree.head = tree.root
+	return tree
+}
+
+func (tree *Tree) Has(hash string) bool {
+	_, ok := tree.entries[hash]
+	return ok
+}
+
+func (tree *Tree) Get(hash string) *Entry {
+	return tree.entries[hash]
+}
+
+func (tree *Tree) Head() *block.Block {
+	return tree.head.Block
+}
+
+func (tree *Tree) Root() *block.Block {
+	return tree.root.Block
+}
+
+// Add connects b to its parent, together with the orphans waiting for b.
+// If the parent is unknown b is kept as an orphan, unless it can never connect above the root.
+// The head is chosen again by the rule.
+func (tree *Tree) Add(b *block.Block) error {
+	if tree.Has(b.Hash) {
+		return ErrKnown
+	}
+	parent, ok := tree.entries[b.PrevHash]
+	if !ok {
+		if b.Height <= tree.root.Block.Height+1 {
+			return ErrStale
+		}
+		for _, orphan := range tree.orphans[b.PrevHash] {
+			if orphan.Hash == b.Hash {
+				return ErrKnown
+			}
+		}
+		tree.keepOrphan(b)
+		return nil
+	}
+	if err := b.Verify(parent.Block); err != nil {
+		return err
+	}
+
+	tree.connect(parent, b)
+	tree.head = tree.Rule.Head(tree)
+	return nil
+}
+
+func (tree *Tree) connect(parent *Entry, b *block.Block) {
+	tree.seq++
+	work := tree.Work(b)
+	entry := &Entry{
+		Block:     b,
+		Parent:    parent,
+		Children:  make([]*Entry, 0),
+		TotalWork: parent.TotalWork + work,
+		Seq:       tree.seq,
+	}
+	parent.Children = append(parent.Children, entry)
+	tree.entries[b.Hash] = entry
+	for ancestor := entry; ancestor != nil; ancestor = ancestor.Parent {
+		ancestor.SubtreeWork += work
+	}
+
+	// Connect the orphans waiting for this block.
+	orphans := tree.takeOrphans(b.Hash)
+	for _, orphan := range orphans {
+		if orphan.Verify(b) == nil && !tree.Has(orphan.Hash) {
+			tree.connect(entry, orphan)
+		}
+	}
+}
+
+// Remove drops the block with hash and all its descendants, e.g. when it turns out invalid.
+func (tree *Tree) Remove(hash string) error {
+	entry, ok := tree.entries[hash]
+	if !ok {
+		return ErrUnknown
+	}
+	if entry == tree.root {
+		return errors.New("the root can not be removed")
+	}
+	siblings := entry.Parent.Children
+	for i, sibling := range siblings {
+		if sibling == entry {
+			entry.Parent.Children = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+	for ancestor := entry.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		ancestor.SubtreeWork -= entry.SubtreeWork
+	}
+	tree.drop(entry)
+	tree.head = tree.Rule.Head(tree)
+	return nil
+}
+
+func (tree *Tree) drop(entry *Entry) {
+	delete(tree.entries, entry.Block.Hash)
+	tree.takeOrphans(entry.Block.Hash)
+	for _, child := range entry.Children {
+		tree.drop(child)
+	}
+}
+
+// Leaves are the tips of all chains.
+func (tree *Tree) Leaves() []*Entry {
+	leaves := make([]*Entry, 0)
+	for _, entry := range tree.entries {
+		if len(entry.Children) == 0 {
+			leaves = append(leaves, entry)
+		}
+	}
+	return leaves
+}
+
+// Prune moves the root up to the ancestor of the head at height,
+// dropping every block not descending from it and the orphans below it.
+func (tree *Tree) Prune(height int64) {
+	if height <= tree.root.Block.Height || height > tree.head.Block.Height {
+		return
+	}
+	newRoot := tree.head
+	for newRoot.Block.Height > height {
+		newRoot = newRoot.Parent
+	}
+	for ancestor := newRoot; ancestor.Parent != nil; ancestor = ancestor.Parent {
+		for _, sibling := range ancestor.Parent.Children {
+			if sibling != ancestor {
+				tree.drop(sibling)
+			}
+		}
+		delete(tree.entries, ancestor.Parent.Block.Hash)
+	}
+	newRoot.Parent = nil
+	tree.root = newRoot
+	for parentHash, orphans := range tree.orphans {
+		if len(orphans) != 0 && orphans[0].Height <= height+1 {
+			tree.takeOrphans(parentHash)
+		}
+	}
+}
+
+// keepOrphan holds b until its parent arrives, dropping the oldest orphans beyond MaxOrphans.
+func (tree *Tree) keepOrphan(b *block.Block) {
+	tree.orphans[b.PrevHash] = append(tree.orphans[b.PrevHash], b)
+	tree.arrivals = append(tree.arrivals, orphan{parent: b.PrevHash, hash: b.Hash})
+	tree.orphanCount++
+	tree.Metrics.Orphans++
+	for tree.orphanCount > tree.MaxOrphans && len(tree.arrivals) != 0 {
+		oldest := tree.arrivals[0]
+		tree.arrivals = tree.arrivals[1:]
+		waiting := tree.orphans[oldest.parent]
+		for i, orphan := range waiting {
+			if orphan.Hash == oldest.hash {
+				tree.orphans[oldest.parent] = append(waiting[:i:i], waiting[i+1:]...)
+				tree.orphanCount--
+				break
+			}
+		}
+		if len(tree.orphans[oldest.parent]) == 0 {
+			delete(tree.orphans, oldest.parent)
+		}
+	}
+	// Arrivals of orphans connected or dropped meanwhile are left behind, forget them once they pile up.
+	if len(tree.arrivals) > 2*tree.orphanCount+tree.MaxOrphans {
+		kept := make([]orphan, 0, tree.orphanCount)
+		for _, arrival := range tree.arrivals {
+			for _, orphan := range tree.orphans[arrival.parent] {
+				if orphan.Hash == arrival.hash {
+					kept = append(kept, arrival)
+					break
+				}
+			}
+		}
+		tree.arrivals = kept
+	}
+}
+
+// takeOrphans removes the orphans waiting for the block with hash and returns them.
+func (tree *Tree) takeOrphans(hash string) []*block.Block {
+	orphans := tree.orphans[hash]
+	delete(tree.orphans, hash)
+	tree.orphanCount -= len(orphans)
+	return orphans
+}
+
+// Orphans is the number of blocks waiting for their parent.
+func (tree *Tree) Orphans() int {
+	return tree.orphanCount
+}
+
+// Reorg describes moving the head from one chain to another.
+type Reorg struct {
+	OldHead  *block.Block
+	NewHead  *block.Block
+	Ancestor *block.Block
+	// blocks of the old chain above the ancestor, from the highest
+	Dropped []*block.Block
+	// blocks of the new chain above the ancestor, from the lowest
+	Added []*block.Block
+	// number of blocks dropped, 0 if the new head just extends the old one
+	Depth int
+}
+
+// Path finds how to move from the block with hash from to the block with hash to.
+func (tree *Tree) Path(from string, to string) (*Reorg, error) {
+	oldEntry, ok := tree.entries[from]
+	if !ok {
+		return nil, ErrUnknown
+	}
+	newEntry, ok := tree.entries[to]
+	if !ok {
+		return nil, ErrUnknown
+	}
+	reorg := &Reorg{
+		OldHead: oldEntry.Block,
+		NewHead: newEntry.Block,
+		Dropped: make([]*block.Block, 0),
+		Added:   make([]*block.Block, 0),
+	}
+	added := make([]*block.Block, 0)
+	for oldEntry != newEntry {
+		if oldEntry.Block.Height >= newEntry.Block.Height {
+			reorg.Dropped = append(reorg.Dropped, oldEntry.Block)
+			oldEntry = oldEntry.Parent
+		} else {
+			added = append(added, newEntry.Block)
+			newEntry = newEntry.Parent
+		}
+		if oldEntry == nil || newEntry == nil {
+			return nil, errors.New("blocks do not share an ancestor in the tree")
+		}
+	}
+	for i := len(added) - 1; i >= 0; i-- {
+		reorg.Added = append(reorg.Added, added[i])
+	}
+	reorg.Ancestor = oldEntry.Block
+	reorg.Depth = len(reorg.Dropped)
+	return reorg, nil
+}
@@ -0,0 +1,285 @@
+package forkchoice
+
+import (
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"strconv"
+	"testing"
+)
+
+// builder makes blocks with distinct hashes, weighted by the work it is told.
+type builder struct {
+	t       *testing.T
+	genesis *block.Block
+	weights map[string]uint64
+	n       int64
+}
+
+func newBuilder(t *testing.T) *builder {
+	genesis, err := block.Genesis(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &builder{t: t, genesis: genesis, weights: make(map[string]uint64)}
+}
+
+func (builder *builder) tree(rule Rule) *Tree {
+	tree := NewTree(builder.genesis, rule)
+	tree.Work = func(b *block.Block) uint64 {
+		if work, ok := builder.weights[b.Hash]; ok {
+			return work
+		}
+		return 1
+	}
+	return tree
+}
+
+// chain makes count blocks on parent, the last one of the given work.
+func (builder *builder) chain(parent *block.Block, count int, work uint64) []*block.Block {
+	blocks := make([]*block.Block, 0, count)
+	for i := 0; i < count; i++ {
+		builder.n++
+		b, err := block.NewBlock(parent, builder.n, "node-"+strconv.FormatInt(builder.n, 10), make([]json.RawMessage, 0), nil)
+		if err != nil {
+			builder.t.Fatal(err)
+		}
+		blocks = append(blocks, b)
+		parent = b
+	}
+	builder.weights[parent.Hash] = work
+	return blocks
+}
+
+func add(t *testing.T, tree *Tree, blocks ...*block.Block) {
+	for _, b := range blocks {
+		if err := tree.Add(b); err != nil {
+			t.Fatalf("add block %d: %v", b.Height, err)
+		}
+	}
+}
+
+// TestRulesDiverge grows three chains from the genesis block: the highest, the one of most work,
+// and the one whose subtree holds the most work through its uncles.
+func TestRulesDiverge(t *testing.T) {
+	builder := newBuilder(t)
+	high := builder.chain(builder.genesis, 4, 1)
+	heavy := builder.chain(builder.genesis, 2, 5)
+	bushy := builder.chain(builder.genesis, 1, 1)
+	uncles := make([]*block.Block, 0, 6)
+	for i := 0; i < 6; i++ {
+		uncles = append(uncles, builder.chain(bushy[0], 1, 1)...)
+	}
+
+	for _, c := range []struct {
+		rule Rule
+		head *block.Block
+	}{
+		{LongestChain{}, high[3]},
+		{HeaviestWork{}, heavy[1]},
+		// The first uncle wins the tie among equal subtrees.
+		{GHOST{}, uncles[0]},
+	} {
+		tree := builder.tree(c.rule)
+		add(t, tree, high...)
+		add(t, tree, heavy...)
+		add(t, tree, bushy...)
+		add(t, tree, uncles...)
+		if tree.Head().Hash != c.head.Hash {
+			t.Fatalf("%s picks block %d by %s", c.rule.Name(), tree.Head().Height, tree.Head().Proposer)
+		}
+		if RuleByName(c.rule.Name()) != c.rule {
+			t.Fatalf("%s is not found by its name", c.rule.Name())
+		}
+	}
+	if RuleByName("unknown") != nil {
+		t.Fatal("unknown rule is found")
+	}
+}
+
+func TestOrphansConnect(t *testing.T) {
+	builder := newBuilder(t)
+	blocks := builder.chain(builder.genesis, 4, 1)
+	tree := builder.tree(LongestChain{})
+
+	// The blocks arrive from the highest.
+	for i := len(blocks) - 1; i > 0; i-- {
+		add(t, tree, blocks[i])
+		if tree.Has(blocks[i].Hash) || tree.Head().Hash != builder.genesis.Hash {
+			t.Fatalf("block %d is connected before its parent", blocks[i].Height)
+		}
+	}
+	if err := tree.Add(blocks[2]); err != ErrKnown {
+		t.Fatalf("orphan added twice returned %v", err)
+	}
+	if tree.Orphans() != 3 || tree.Metrics.Orphans != 3 {
+		t.Fatalf("%d orphans kept, %d counted", tree.Orphans(), tree.Metrics.Orphans)
+	}
+	add(t, tree, blocks[0])
+	if tree.Head().Hash != blocks[3].Hash || tree.Orphans() != 0 {
+		t.Fatalf("head at %d with %d orphans once the missing block arrives", tree.Head().Height, tree.Orphans())
+	}
+	if err := tree.Add(blocks[1]); err != ErrKnown {
+		t.Fatalf("connected block added again returned %v", err)
+	}
+
+	// An orphan whose parent is corrupted is not connected.
+	forged := builder.chain(blocks[3], 2, 1)
+	forged[1].Transactions = append(forged[1].Transactions, json.RawMessage(`"forged"`))
+	add(t, tree, forged[1], forged[0])
+	if tree.Has(forged[1].Hash) || tree.Head().Hash != forged[0].Hash {
+		t.Fatal("orphan failing verification is connected")
+	}
+}
+
+func TestOrphansBounded(t *testing.T) {
+	builder := newBuilder(t)
+	tree := builder.tree(LongestChain{})
+	tree.MaxOrphans = 3
+	missing := builder.chain(builder.genesis, 1, 1)[0]
+	orphans := make([]*block.Block, 0, 5)
+	for i := 0; i < 5; i++ {
+		orphans = append(orphans, builder.chain(missing, 1, 1)...)
+	}
+	add(t, tree, orphans...)
+	if tree.Orphans() != 3 {
+		t.Fatalf("%d orphans kept, at most 3", tree.Orphans())
+	}
+	add(t, tree, missing)
+	for i, orphan := range orphans {
+		if connected := tree.Has(orphan.Hash); connected != (i >= 2) {
+			t.Fatalf("orphan %d connected %t, only the 3 latest are kept", i, connected)
+		}
+	}
+
+	// A block which can only hang below the root is refused.
+	stale := builder.chain(&block.Block{Hash: "other genesis"}, 1, 1)[0]
+	if err := tree.Add(stale); err != ErrStale {
+		t.Fatalf("block beside the root returned %v", err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	builder := newBuilder(t)
+	main := builder.chain(builder.genesis, 3, 1)
+	fork := builder.chain(main[0], 1, 1)
+	tree := builder.tree(HeaviestWork{})
+	add(t, tree, main...)
+	add(t, tree, fork...)
+
+	if err := tree.Remove(main[1].Hash); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range main[1:] {
+		if tree.Has(b.Hash) {
+			t.Fatalf("descendant %d of the removed block is kept", b.Height)
+		}
+	}
+	if tree.Head().Hash != fork[0].Hash {
+		t.Fatalf("head at %d by %s after removing the main chain", tree.Head().Height, tree.Head().Proposer)
+	}
+	if work := tree.Get(builder.genesis.Hash).SubtreeWork; work != 2 {
+		t.Fatalf("root subtree holds %d work after the removal", work)
+	}
+	if err := tree.Remove(main[2].Hash); err != ErrUnknown {
+		t.Fatalf("removing a removed block returned %v", err)
+	}
+	if err := tree.Remove(builder.genesis.Hash); err == nil {
+		t.Fatal("the root is removed")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	builder := newBuilder(t)
+	main := builder.chain(builder.genesis, 5, 1)
+	fork := builder.chain(main[0], 2, 1)
+	tree := builder.tree(LongestChain{})
+	add(t, tree, main...)
+	add(t, tree, fork...)
+	// Waits for a block below the new root, it can never connect.
+	lost := builder.chain(builder.chain(main[1], 1, 1)[0], 1, 1)[0]
+	// Waits for a block above it.
+	waiting := builder.chain(builder.chain(main[4], 1, 1)[0], 1, 1)[0]
+	add(t, tree, lost, waiting)
+
+	tree.Prune(main[2].Height)
+	if tree.Root().Hash != main[2].Hash {
+		t.Fatalf("root at %d after pruning to 3", tree.Root().Height)
+	}
+	for _, b := range append([]*block.Block{builder.genesis, main[0], main[1]}, fork...) {
+		if tree.Has(b.Hash) {
+			t.Fatalf("block %d by %s is kept below the root", b.Height, b.Proposer)
+		}
+	}
+	if tree.Orphans() != 1 {
+		t.Fatalf("%d orphans kept, only the one above the root", tree.Orphans())
+	}
+	if tree.Head().Hash != main[4].Hash {
+		t.Fatalf("head moved to %d by pruning", tree.Head().Height)
+	}
+
+	// Heights outside the root and the head leave the tree as it is.
+	tree.Prune(main[1].Height)
+	tree.Prune(main[4].Height + 1)
+	if tree.Root().Hash != main[2].Hash {
+		t.Fatalf("root moved to %d", tree.Root().Height)
+	}
+}
+
+func TestPath(t *testing.T) {
+	builder := newBuilder(t)
+	main := builder.chain(builder.genesis, 4, 1)
+	fork := builder.chain(main[1], 3, 1)
+	tree := builder.tree(LongestChain{})
+	add(t, tree, main...)
+	add(t, tree, fork...)
+
+	for _, c := range []struct {
+		name     string
+		from, to *block.Block
+		ancestor *block.Block
+		dropped  []*block.Block
+		added    []*block.Block
+	}{
+		{"extension", main[1], main[3], main[1], nil, main[2:]},
+		{"same block", main[3], main[3], main[3], nil, nil},
+		{"reorganization", main[3], fork[2], main[1], []*block.Block{main[3], main[2]}, fork},
+		{"rollback", fork[2], main[0], main[0], []*block.Block{fork[2], fork[1], fork[0], main[1]}, nil},
+	} {
+		reorg, err := tree.Path(c.from.Hash, c.to.Hash)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if reorg.Ancestor.Hash != c.ancestor.Hash || reorg.Depth != len(c.dropped) {
+			t.Fatalf("%s: ancestor at %d, depth %d", c.name, reorg.Ancestor.Height, reorg.Depth)
+		}
+		same := func(got []*block.Block, want []*block.Block) bool {
+			if len(got) != len(want) {
+				return false
+			}
+			for i := range got {
+				if got[i].Hash != want[i].Hash {
+					return false
+				}
+			}
+			return true
+		}
+		if !same(reorg.Dropped, c.dropped) || !same(reorg.Added, c.added) {
+			t.Fatalf("%s: drops %d blocks and adds %d", c.name, len(reorg.Dropped), len(reorg.Added))
+		}
+	}
+	if _, err := tree.Path(main[0].Hash, "unknown"); err != ErrUnknown {
+		t.Fatalf("path to an unknown block returned %v", err)
+	}
+
+	metrics := NewMetrics()
+	for _, to := range []*block.Block{main[3], fork[2]} {
+		reorg, err := tree.Path(main[2].Hash, to.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics.Record(reorg)
+	}
+	if metrics.Reorgs != 1 || metrics.MaxDepth != 1 || metrics.Depths[1] != 1 {
+		t.Fatalf("metrics %+v after one extension and one reorganization", metrics)
+	}
+}
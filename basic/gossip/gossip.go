@@ -0,0 +1,349 @@
+package gossip
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Operations of the node receiving pushed messages and pull requests.
+const (
+	PushOperation = "gossip"
+	PullOperation = "gossip-pull"
+)
+
+// OriginHeader carries the origin of a delivered message, which came through peers and not from the origin itself.
+const OriginHeader = "Gossip-Origin"
+
+type Config struct {
+	// peers each message is pushed to by every node
+	Fanout int `json:"fanout"`
+	// hops a message travels from its origin, 1 reaches the first peers only
+	TTL int `json:"ttl"`
+	// a node asks a random peer for the messages it missed once every PullPeriod, 0 pushes only
+	PullPeriod time.Duration `json:"pullPeriod"`
+	// messages received within Window are offered to pulling peers, older ones are only repaired by push
+	Window time.Duration `json:"window"`
+	// hashes remembered to drop duplicates
+	Seen int   `json:"seen"`
+	Seed int64 `json:"seed"`
+}
+
+var DefaultConfig = Config{
+	Fanout:     4,
+	TTL:        6,
+	PullPeriod: time.Second,
+	Window:     3 * time.Second,
+	Seen:       16384,
+}
+
+// Envelope carries a broadcast message, encoded by the codec of ContentType, from peer to peer.
+type Envelope struct {
+	Hash   string `json:"hash"`
+	Origin string `json:"origin"`
+	// raised by the origin for every broadcast, so sending the same message again is not dropped as a duplicate
+	Nonce       uint64 `json:"nonce"`
+	Operation   string `json:"operation"`
+	TTL         int    `json:"ttl"`
+	ContentType string `json:"contentType"`
+	Payload     []byte `json:"payload"`
+}
+
+// PullMsg lists the hashes the puller holds, the peer answers with the recent messages not in it.
+// A node joining late is not sent what was broadcast before it started, in unix nanoseconds.
+type PullMsg struct {
+	Have  []string `json:"have"`
+	Since int64    `json:"since"`
+}
+
+func init() {
+	codec.Register(0x0003, 2, Envelope{})
+	codec.Register(0x0004, 1, PullMsg{})
+}
+
+// Runtime runs forwarding and pulling, a node.Node fits it.
+type Runtime interface {
+	Now() time.Time
+	Go(fn func())
+	Every(period time.Duration, fn func()) (stop func())
+}
+
+// Gossip broadcasts by pushing each message to Fanout random peers, which push it on until its TTL runs out,
+// instead of sending to every peer directly. Send stays direct.
+type Gossip struct {
+	server.Sender
+	Config
+	ID string
+	// receives the messages, once each
+	Operator server.Operator
+	runtime  Runtime
+	started  time.Time
+	// nonce of the last broadcast, starting from the start time so a restarted node does not repeat one
+	nonce uint64
+	seen  map[string]bool
+	// seen hashes, oldest first
+	order  []string
+	recent []received
+	rand   *rand.Rand
+	mutex  sync.Mutex
+}
+
+type received struct {
+	*Envelope
+	at time.Time
+}
+
+func New(id string, sender server.Sender, operator server.Operator, runtime Runtime, config Config) *Gossip {
+	seed := fnv.New64a()
+	seed.Write([]byte(id))
+	gossip := &Gossip{
+		Sender:   sender,
+		Config:   config,
+		ID:       id,
+		Operator: operator,
+		runtime:  runtime,
+		started:  runtime.Now(),
+		nonce:    uint64(runtime.Now().UnixNano()),
+		seen:     make(map[string]bool),
+		order:    make([]string, 0),
+		recent:   make([]received, 0),
+		rand:     rand.New(rand.NewSource(config.Seed ^ int64(seed.Sum64()))),
+	}
+	if config.PullPeriod > 0 {
+		runtime.Every(config.PullPeriod, gossip.pull)
+	}
+	return gossip
+}
+
+// IDs of the peers, when the wrapped Sender tells them.
+func (gossip *Gossip) IDs() []string {
+	if router, ok := gossip.Sender.(interface{ IDs() []string }); ok {
+		return router.IDs()
+	}
+	return nil
+}
+
+// Broadcast pushes message to Fanout peers, it reaches the others through them.
+func (gossip *Gossip) Broadcast(from string, operation string, message interface{}) (resps []*http.Response, errs []error) {
+	if gossip.IDs() == nil {
+		return gossip.Sender.Broadcast(from, operation, message)
+	}
+	c := codec.Of(gossip.Sender)
+	payload, err := c.Marshal(message)
+	if err != nil {
+		c = codec.JSON
+		if payload, err = c.Marshal(message); err != nil {
+			return nil, []error{err}
+		}
+	}
+	gossip.mutex.Lock()
+	gossip.nonce++
+	nonce := gossip.nonce
+	gossip.mutex.Unlock()
+	envelope := &Envelope{
+		Origin:      from,
+		Nonce:       nonce,
+		Operation:   operation,
+		TTL:         gossip.TTL,
+		ContentType: c.ContentType(),
+		Payload:     payload,
+	}
+	envelope.Hash = hashOf(envelope)
+	gossip.remember(envelope)
+	return gossip.push(envelope, from)
+}
+
+// HandlePush delivers a pushed message seen for the first time and pushes it on.
+func (gossip *Gossip) HandlePush(_ http.ResponseWriter, request *http.Request) {
+	var envelope Envelope
+	if err := codec.Decode(request, &envelope); err != nil {
+		log.Println(err)
+		return
+	}
+	if envelope.Hash != hashOf(&envelope) {
+		log.Println(gossip.ID, "gossip message of", envelope.Origin, "does not match its hash")
+		return
+	}
+	if !gossip.remember(&envelope) {
+		return
+	}
+	gossip.deliver(&envelope)
+	if envelope.TTL > 1 {
+		next := envelope
+		next.TTL--
+		from := request.URL.Query().Get("from")
+		gossip.runtime.Go(func() {
+			gossip.push(&next, from)
+		})
+	}
+}
+
+// HandlePull sends the recent messages the puller lacks, they are not pushed on.
+func (gossip *Gossip) HandlePull(_ http.ResponseWriter, request *http.Request) {
+	var msg PullMsg
+	if err := codec.Decode(request, &msg); err != nil {
+		log.Println(err)
+		return
+	}
+	have := make(map[string]bool, len(msg.Have))
+	for _, hash := range msg.Have {
+		have[hash] = true
+	}
+	missing := make([]*Envelope, 0)
+	gossip.mutex.Lock()
+	gossip.expire()
+	since := time.Unix(0, msg.Since)
+	for _, envelope := range gossip.recent {
+		if !have[envelope.Hash] && !envelope.at.Before(since) {
+			next := *envelope.Envelope
+			next.TTL = 1
+			missing = append(missing, &next)
+		}
+	}
+	gossip.mutex.Unlock()
+	if len(missing) == 0 {
+		return
+	}
+	to := request.URL.Query().Get("from")
+	gossip.runtime.Go(func() {
+		for _, envelope := range missing {
+			gossip.Sender.Send(gossip.ID, to, PushOperation, envelope)
+		}
+	})
+}
+
+// pull asks a random peer for the recent messages this node missed.
+func (gossip *Gossip) pull() {
+	peers := gossip.pick(1)
+	if len(peers) == 0 {
+		return
+	}
+	gossip.mutex.Lock()
+	gossip.expire()
+	have := make([]string, 0, len(gossip.recent))
+	for _, envelope := range gossip.recent {
+		have = append(have, envelope.Hash)
+	}
+	gossip.mutex.Unlock()
+	gossip.Sender.Send(gossip.ID, peers[0], PullOperation, PullMsg{Have: have, Since: gossip.started.UnixNano()})
+}
+
+func (gossip *Gossip) push(envelope *Envelope, from string) (resps []*http.Response, errs []error) {
+	for _, id := range gossip.pick(gossip.Fanout, from, envelope.Origin) {
+		resp, err := gossip.Sender.Send(gossip.ID, id, PushOperation, envelope)
+		resps = append(resps, resp)
+		errs = append(errs, err)
+	}
+	return resps, errs
+}
+
+// pick chooses up to n random peers other than this node and skip.
+func (gossip *Gossip) pick(n int, skip ...string) []string {
+	peers := make([]string, 0)
+	for _, id := range gossip.IDs() {
+		if id == gossip.ID || contains(skip, id) {
+			continue
+		}
+		peers = append(peers, id)
+	}
+	sort.Strings(peers)
+	gossip.mutex.Lock()
+	gossip.rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	gossip.mutex.Unlock()
+	if len(peers) > n {
+		peers = peers[:n]
+	}
+	return peers
+}
+
+// remember records envelope, and tells if it was not seen before.
+// The recent messages out of the window are dropped, also when nobody pulls them.
+func (gossip *Gossip) remember(envelope *Envelope) bool {
+	gossip.mutex.Lock()
+	defer gossip.mutex.Unlock()
+	gossip.expire()
+	if gossip.seen[envelope.Hash] {
+		return false
+	}
+	gossip.seen[envelope.Hash] = true
+	gossip.order = append(gossip.order, envelope.Hash)
+	if len(gossip.order) > gossip.Seen {
+		delete(gossip.seen, gossip.order[0])
+		gossip.order = gossip.order[1:]
+	}
+	gossip.recent = append(gossip.recent, received{envelope, gossip.runtime.Now()})
+	return true
+}
+
+// expire forgets the recent messages out of the window, pulling them could replay stale rounds to new peers.
+func (gossip *Gossip) expire() {
+	deadline := gossip.runtime.Now().Add(-gossip.Window)
+	i := 0
+	for i < len(gossip.recent) && gossip.recent[i].at.Before(deadline) {
+		i++
+	}
+	gossip.recent = gossip.recent[i:]
+}
+
+// deliver runs the operation of envelope on the operator as if the origin sent it directly.
+func (gossip *Gossip) deliver(envelope *Envelope) {
+	if envelope.Origin == gossip.ID {
+		return
+	}
+	query := url.Values{}
+	query.Add("from", envelope.Origin)
+	query.Add("to", gossip.ID)
+	query.Add("operation", envelope.Operation)
+	request, err := http.NewRequest(http.MethodPost, "gossip://"+gossip.ID+"/node?"+query.Encode(), bytes.NewReader(envelope.Payload))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	request.Header.Set("Content-Type", envelope.ContentType)
+	request.Header.Set(OriginHeader, envelope.Origin)
+	gossip.Operator.DoOperation(envelope.Operation, discard{}, request)
+}
+
+func hashOf(envelope *Envelope) string {
+	header, _ := json.Marshal([]string{envelope.Origin, strconv.FormatUint(envelope.Nonce, 10), envelope.Operation, envelope.ContentType})
+	sum := sha256.New()
+	sum.Write(header)
+	sum.Write(envelope.Payload)
+	return hex.EncodeToString(sum.Sum(nil)[:16])
+}
+
+func contains(ids []string, id string) bool {
+	for _, skip := range ids {
+		if skip == id {
+			return true
+		}
+	}
+	return false
+}
+
+// discard drops the responses of delivered messages, nobody waits for them.
+type discard struct{}
+
+func (discard) Header() http.Header {
+	return make(http.Header)
+}
+
+func (discard) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (discard) WriteHeader(int) {}
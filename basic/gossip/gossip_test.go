@@ -0,0 +1,105 @@
+package gossip
+
+import (
+	"bytes"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"net/http"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+)
+
+// manual is a runtime whose clock only moves when told, background work runs at once.
+type manual struct {
+	now time.Time
+}
+
+func (runtime *manual) Now() time.Time {
+	return runtime.now
+}
+
+func (runtime *manual) Go(fn func()) {
+	fn()
+}
+
+func (runtime *manual) Every(_ time.Duration, _ func()) (stop func()) {
+	return func() {}
+}
+
+// network hands pushed messages straight to the gossip of their receiver.
+type network map[string]*Gossip
+
+func (network network) Send(from string, to string, operation string, message interface{}) (*http.Response, error) {
+	c, data, err := codec.Encode(codec.JSON, to, operation, message)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"from": {from}, "to": {to}, "operation": {operation}}
+	request, err := http.NewRequest(http.MethodPost, "memory://"+to+"/node?"+query.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", c.ContentType())
+	network[to].HandlePush(nil, request)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func (network network) Broadcast(_ string, _ string, _ interface{}) ([]*http.Response, []error) {
+	return nil, nil
+}
+
+func (network network) IDs() []string {
+	ids := make([]string, 0, len(network))
+	for id := range network {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// counter counts the messages delivered to it by operation.
+type counter map[string]int
+
+func (counter counter) DoOperation(operation string, _ http.ResponseWriter, _ *http.Request) {
+	counter[operation]++
+}
+
+func newNetwork(runtime Runtime, config Config, ids ...string) (network, map[string]counter) {
+	network, counters := make(network), make(map[string]counter)
+	for _, id := range ids {
+		counters[id] = make(counter)
+		network[id] = New(id, network, counters[id], runtime, config)
+	}
+	return network, counters
+}
+
+func TestRebroadcastIsDelivered(t *testing.T) {
+	config := DefaultConfig
+	config.PullPeriod = 0
+	network, counters := newNetwork(&manual{now: time.Unix(0, 0)}, config, "node-1", "node-2", "node-3")
+	for i := 0; i < 2; i++ {
+		network["node-1"].Broadcast("node-1", "block", map[string]int{"height": 1})
+	}
+	for _, id := range []string{"node-2", "node-3"} {
+		if got := counters[id]["block"]; got != 2 {
+			t.Fatalf("%s got the message broadcast twice %d times", id, got)
+		}
+	}
+}
+
+func TestRecentExpiresWithoutPull(t *testing.T) {
+	config := DefaultConfig
+	config.PullPeriod = 0
+	runtime := &manual{now: time.Unix(0, 0)}
+	network, _ := newNetwork(runtime, config, "node-1", "node-2")
+	for i := 0; i < 10; i++ {
+		network["node-1"].Broadcast("node-1", "block", i)
+		runtime.now = runtime.now.Add(config.Window + time.Millisecond)
+	}
+	for id, gossip := range network {
+		if recent := len(gossip.recent); recent > 1 {
+			t.Fatalf("%s keeps %d recent messages, the window holds 1", id, recent)
+		}
+	}
+}
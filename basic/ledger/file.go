@@ -0,0 +1,298 @@
+package ledger
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+)
+
+// SegmentBlocks is the number of blocks kept in one segment file.
+const SegmentBlocks = 1024
+
+const (
+	indexName = "index"
+	hashSize  = 64
+	// segment, offset, length, crc32, hash
+	entrySize = 4 + 8 + 4 + 4 + hashSize
+)
+
+type entry struct {
+	segment uint32
+	offset  int64
+	length  uint32
+	crc     uint32
+	hash    string
+}
+
+func (e *entry) encode() []byte {
+	buf := make([]byte, entrySize)
+	binary.BigEndian.PutUint32(buf[0:], e.segment)
+	binary.BigEndian.PutUint64(buf[4:], uint64(e.offset))
+	binary.BigEndian.PutUint32(buf[12:], e.length)
+	binary.BigEndian.PutUint32(buf[16:], e.crc)
+	copy(buf[20:], e.hash)
+	return buf
+}
+
+func decodeEntry(buf []byte) entry {
+	return entry{
+		segment: binary.BigEndian.Uint32(buf[0:]),
+		offset:  int64(binary.BigEndian.Uint64(buf[4:])),
+		length:  binary.BigEndian.Uint32(buf[12:]),
+		crc:     binary.BigEndian.Uint32(buf[16:]),
+		hash:    string(buf[20 : 20+hashSize]),
+	}
+}
+
+// FileStore appends blocks as JSON records to segment files of SegmentBlocks blocks,
+// and keeps a fixed-size index entry per height to find them.
+// Data is written before its index entry, so a torn write is dropped on the next Open.
+type FileStore struct {
+	dir string
+	// Sync makes every Append flush to disk before returning.
+	Sync bool
+
+	index    *os.File
+	entries  []entry
+	hashes   map[string]int64
+	segments map[uint32]*os.File
+	head     *block.Block
+}
+
+func segmentName(segment uint32) string {
+	return fmt.Sprintf("%08d.seg", segment)
+}
+
+func Open(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(path.Join(dir, indexName), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	store := &FileStore{
+		dir:      dir,
+		index:    index,
+		entries:  make([]entry, 0),
+		hashes:   make(map[string]int64),
+		segments: make(map[uint32]*os.File),
+	}
+	if err = store.load(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FileStore) load() error {
+	content, err := io.ReadAll(store.index)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+entrySize <= len(content); i += entrySize {
+		e := decodeEntry(content[i : i+entrySize])
+		store.hashes[e.hash] = int64(len(store.entries))
+		store.entries = append(store.entries, e)
+	}
+	// Drop the entries whose data did not reach the disk.
+	for store.Height() >= 0 {
+		head, err := store.Get(store.Height())
+		if err == nil {
+			store.head = head
+			break
+		}
+		last := store.entries[len(store.entries)-1]
+		delete(store.hashes, last.hash)
+		store.entries = store.entries[:len(store.entries)-1]
+	}
+	return store.Truncate(store.Height())
+}
+
+func (store *FileStore) segment(segment uint32) (*os.File, error) {
+	if file, ok := store.segments[segment]; ok {
+		return file, nil
+	}
+	file, err := os.OpenFile(path.Join(store.dir, segmentName(segment)), os.O_CREATE|os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	store.segments[segment] = file
+	return file, nil
+}
+
+func (store *FileStore) Append(b *block.Block) error {
+	if err := checkNext(store, b); err != nil {
+		return err
+	}
+	if len(b.Hash) != hashSize {
+		return errors.New("block hash is malformed")
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	e := entry{
+		segment: uint32(b.Height / SegmentBlocks),
+		length:  uint32(len(data)),
+		crc:     crc32.ChecksumIEEE(data),
+		hash:    b.Hash,
+	}
+	if last := len(store.entries) - 1; last >= 0 && store.entries[last].segment == e.segment {
+		e.offset = store.entries[last].offset + int64(store.entries[last].length)
+	}
+	file, err := store.segment(e.segment)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteAt(data, e.offset); err != nil {
+		return err
+	}
+	if store.Sync {
+		if err = file.Sync(); err != nil {
+			return err
+		}
+	}
+	if _, err = store.index.WriteAt(e.encode(), int64(len(store.entries))*entrySize); err != nil {
+		return err
+	}
+	if store.Sync {
+		if err = store.index.Sync(); err != nil {
+			return err
+		}
+	}
+
+	store.hashes[b.Hash] = b.Height
+	store.entries = append(store.entries, e)
+	store.head = b
+	return nil
+}
+
+func (store *FileStore) Get(height int64) (*block.Block, error) {
+	if height < 0 || height > store.Height() {
+		return nil, ErrNotFound
+	}
+	e := store.entries[height]
+	file, err := store.segment(e.segment)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, e.length)
+	if _, err = file.ReadAt(data, e.offset); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != e.crc {
+		return nil, fmt.Errorf("block %d is corrupted", height)
+	}
+	var b block.Block
+	if err = json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (store *FileStore) GetByHash(hash string) (*block.Block, error) {
+	height, ok := store.hashes[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return store.Get(height)
+}
+
+func (store *FileStore) Range(from int64, to int64, fn func(b *block.Block) bool) error {
+	if from < 0 {
+		from = 0
+	}
+	for height := from; height <= to && height <= store.Height(); height++ {
+		b, err := store.Get(height)
+		if err != nil {
+			return err
+		}
+		if !fn(b) {
+			break
+		}
+	}
+	return nil
+}
+
+func (store *FileStore) Height() int64 {
+	return int64(len(store.entries)) - 1
+}
+
+func (store *FileStore) Head() *block.Block {
+	return store.head
+}
+
+// Truncate also cuts the files, dropping any data not indexed.
+// A height above the head keeps every block.
+func (store *FileStore) Truncate(height int64) error {
+	if height < -1 {
+		height = -1
+	}
+	if height > store.Height() {
+		height = store.Height()
+	}
+	for store.Height() > height {
+		delete(store.hashes, store.entries[len(store.entries)-1].hash)
+		store.entries = store.entries[:len(store.entries)-1]
+	}
+	if err := store.index.Truncate(int64(len(store.entries)) * entrySize); err != nil {
+		return err
+	}
+
+	// Keep the segments up to the head, with the head segment cut after the head.
+	var keep uint32
+	var size int64
+	if height >= 0 {
+		e := store.entries[height]
+		keep, size = e.segment, e.offset+int64(e.length)
+	}
+	names, err := os.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var segment uint32
+		if _, err := fmt.Sscanf(name.Name(), "%08d.seg", &segment); err != nil {
+			continue
+		}
+		if segment > keep || (height < 0 && segment == 0) {
+			if file, ok := store.segments[segment]; ok {
+				file.Close()
+				delete(store.segments, segment)
+			}
+			if err = os.Remove(path.Join(store.dir, name.Name())); err != nil {
+				return err
+			}
+		} else if segment == keep {
+			if err = os.Truncate(path.Join(store.dir, name.Name()), size); err != nil {
+				return err
+			}
+		}
+	}
+
+	store.head = nil
+	if height >= 0 {
+		head, err := store.Get(height)
+		if err != nil {
+			return err
+		}
+		store.head = head
+	}
+	return nil
+}
+
+func (store *FileStore) Close() error {
+	for _, file := range store.segments {
+		file.Close()
+	}
+	store.segments = make(map[uint32]*os.File)
+	return store.index.Close()
+}
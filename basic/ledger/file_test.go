@@ -0,0 +1,147 @@
+package ledger
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFileSegments(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := blocks(t, SegmentBlocks+10)
+	fill(t, store, chain)
+	for _, segment := range []uint32{0, 1} {
+		if _, err = os.Stat(path.Join(dir, segmentName(segment))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	// Reopening reads the chain back from the index.
+	if store, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Height() != int64(len(chain)-1) || store.Head().Hash != chain[len(chain)-1].Hash {
+		t.Fatalf("reopened at height %d", store.Height())
+	}
+	for _, height := range []int64{0, SegmentBlocks - 1, SegmentBlocks, int64(len(chain) - 1)} {
+		if b, err := store.GetByHash(chain[height].Hash); err != nil || b.Height != height {
+			t.Fatalf("block %d after reopening is %v, %v", height, b, err)
+		}
+	}
+
+	// Truncating below a segment removes its file.
+	if err = store.Truncate(SegmentBlocks - 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path.Join(dir, segmentName(1))); !os.IsNotExist(err) {
+		t.Fatalf("segment above the head is kept: %v", err)
+	}
+	fill(t, store, chain[SegmentBlocks:])
+	if b, err := store.Get(int64(len(chain) - 1)); err != nil || b.Hash != chain[len(chain)-1].Hash {
+		t.Fatalf("head after growing again is %v, %v", b, err)
+	}
+}
+
+// TestFileTornRecords cuts the files as a crash in the middle of Append would, reopening drops the torn block.
+func TestFileTornRecords(t *testing.T) {
+	chain := blocks(t, 5)
+	for _, c := range []struct {
+		name string
+		tear func(dir string) error
+	}{
+		{"segment", func(dir string) error {
+			name := path.Join(dir, segmentName(0))
+			info, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			return os.Truncate(name, info.Size()-10)
+		}},
+		{"corrupted segment", func(dir string) error {
+			file, err := os.OpenFile(path.Join(dir, segmentName(0)), os.O_RDWR, 0)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			info, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			_, err = file.WriteAt([]byte("x"), info.Size()-2)
+			return err
+		}},
+	} {
+		dir := t.TempDir()
+		store, err := Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fill(t, store, chain)
+		store.Close()
+		if err = c.tear(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		if store, err = Open(dir); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if store.Height() != 3 || store.Head().Hash != chain[3].Hash {
+			t.Fatalf("%s: reopened at height %d, want the block before the torn one", c.name, store.Height())
+		}
+		if _, err = store.GetByHash(chain[4].Hash); err != ErrNotFound {
+			t.Fatalf("%s: torn block is found by hash: %v", c.name, err)
+		}
+		fill(t, store, chain[4:])
+		store.Close()
+		if store, err = Open(dir); err != nil {
+			t.Fatal(err)
+		}
+		if store.Height() != 4 {
+			t.Fatalf("%s: block appended after recovery is lost, height %d", c.name, store.Height())
+		}
+		store.Close()
+	}
+}
+
+// TestFileTornIndex leaves half an index entry, as a crash while writing it would.
+func TestFileTornIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := blocks(t, 4)
+	fill(t, store, chain[:3])
+	store.Close()
+
+	index, err := os.OpenFile(path.Join(dir, indexName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = index.Write(make([]byte, entrySize/2)); err != nil {
+		t.Fatal(err)
+	}
+	index.Close()
+
+	if store, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if store.Height() != 2 {
+		t.Fatalf("reopened at height %d", store.Height())
+	}
+	info, err := os.Stat(path.Join(dir, indexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 3*entrySize {
+		t.Fatalf("index of %d bytes keeps the torn entry", info.Size())
+	}
+	fill(t, store, chain[3:])
+}
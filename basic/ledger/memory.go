@@ -0,0 +1,74 @@
+package ledger
+
+import "github.com/glimmerzcy/bccp/basic/block"
+
+type MemoryStore struct {
+	blocks []*block.Block
+	hashes map[string]int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blocks: make([]*block.Block, 0),
+		hashes: make(map[string]int64),
+	}
+}
+
+func (store *MemoryStore) Append(b *block.Block) error {
+	if err := checkNext(store, b); err != nil {
+		return err
+	}
+	store.blocks = append(store.blocks, b)
+	store.hashes[b.Hash] = b.Height
+	return nil
+}
+
+func (store *MemoryStore) Get(height int64) (*block.Block, error) {
+	if height < 0 || height > store.Height() {
+		return nil, ErrNotFound
+	}
+	return store.blocks[height], nil
+}
+
+func (store *MemoryStore) GetByHash(hash string) (*block.Block, error) {
+	height, ok := store.hashes[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return store.blocks[height], nil
+}
+
+func (store *MemoryStore) Range(from int64, to int64, fn func(b *block.Block) bool) error {
+	if from < 0 {
+		from = 0
+	}
+	for height := from; height <= to && height <= store.Height(); height++ {
+		if !fn(store.blocks[height]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) Height() int64 {
+	return int64(len(store.blocks)) - 1
+}
+
+func (store *MemoryStore) Head() *block.Block {
+	if len(store.blocks) == 0 {
+		return nil
+	}
+	return store.blocks[len(store.blocks)-1]
+}
+
+func (store *MemoryStore) Truncate(height int64) error {
+	for store.Height() > height && store.Height() >= 0 {
+		delete(store.hashes, store.Head().Hash)
+		store.blocks = store.blocks[:len(store.blocks)-1]
+	}
+	return nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,72 @@
+package ledger
+
+import (
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"path"
+)
+
+var ErrNotFound = errors.New("block not found")
+
+// Store keeps the committed chain, heights start from the genesis block at 0.
+type Store interface {
+	// Append adds the block following the head, the first block must be at height 0.
+	Append(b *block.Block) error
+	Get(height int64) (*block.Block, error)
+	GetByHash(hash string) (*block.Block, error)
+	// Range calls fn on blocks in [from, to] in order until fn returns false.
+	Range(from int64, to int64, fn func(b *block.Block) bool) error
+	// Height is the height of the head, -1 if the store is empty.
+	Height() int64
+	Head() *block.Block
+	// Truncate drops all blocks above height, used when the head is replaced.
+	Truncate(height int64) error
+	Close() error
+}
+
+// Dir is where nodes keep their ledgers, every node in a sub-directory named by its id.
+// Nodes keep the ledger in memory if Dir is empty, so every run starts from its own genesis.
+var Dir = ""
+
+// ForNode opens the ledger of node id, initialized with genesis if it is empty.
+// A ledger kept from an earlier run is resumed if it starts from the same genesis.
+func ForNode(id string, genesis *block.Block) (Store, error) {
+	var store Store = NewMemoryStore()
+	if Dir != "" {
+		fileStore, err := Open(path.Join(Dir, id))
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	if store.Height() < 0 {
+		if err := store.Append(genesis); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	first, err := store.Get(0)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	if first.Hash != genesis.Hash {
+		store.Close()
+		return nil, errors.New("ledger of " + id + " starts from another genesis")
+	}
+	return store, nil
+}
+
+func checkNext(store Store, b *block.Block) error {
+	head := store.Head()
+	if head == nil {
+		if b.Height != 0 {
+			return errors.New("the first block must be at height 0")
+		}
+		return nil
+	}
+	if b.Height != head.Height+1 || b.PrevHash != head.Hash {
+		return errors.New("block does not extend the head")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package ledger
+
+import (
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"testing"
+)
+
+// blocks makes a chain of n blocks starting from the genesis block.
+func blocks(t *testing.T, n int) []*block.Block {
+	genesis, err := block.Genesis(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := []*block.Block{genesis}
+	for len(chain) < n {
+		parent := chain[len(chain)-1]
+		b, err := block.NewBlock(parent, parent.Timestamp+1, "node-1", make([]json.RawMessage, 0), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, b)
+	}
+	return chain
+}
+
+func fill(t *testing.T, store Store, chain []*block.Block) {
+	for _, b := range chain {
+		if err := store.Append(b); err != nil {
+			t.Fatalf("append block %d: %v", b.Height, err)
+		}
+	}
+}
+
+// stores opens every kind of Store, each test runs against all of them.
+func stores(t *testing.T) map[string]Store {
+	file, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		file.Close()
+	})
+	return map[string]Store{"memory": NewMemoryStore(), "file": file}
+}
+
+func TestAppendGet(t *testing.T) {
+	chain := blocks(t, 6)
+	for name, store := range stores(t) {
+		if store.Height() != -1 || store.Head() != nil {
+			t.Fatalf("%s: empty store has height %d", name, store.Height())
+		}
+		if err := store.Append(chain[1]); err == nil {
+			t.Fatalf("%s: first block appended above height 0", name)
+		}
+		fill(t, store, chain[:5])
+		if err := store.Append(chain[3]); err == nil {
+			t.Fatalf("%s: block appended below the head", name)
+		}
+		fork := *chain[5]
+		fork.PrevHash = chain[3].Hash
+		if err := store.Append(&fork); err == nil {
+			t.Fatalf("%s: block appended on another parent", name)
+		}
+
+		if store.Height() != 4 || store.Head().Hash != chain[4].Hash {
+			t.Fatalf("%s: head at %d after 5 blocks", name, store.Height())
+		}
+		for _, b := range chain[:5] {
+			got, err := store.Get(b.Height)
+			if err != nil || got.Hash != b.Hash {
+				t.Fatalf("%s: block %d is %v, %v", name, b.Height, got, err)
+			}
+			if got, err = store.GetByHash(b.Hash); err != nil || got.Height != b.Height {
+				t.Fatalf("%s: block %s is %v, %v", name, b.Hash, got, err)
+			}
+		}
+		for _, height := range []int64{-1, 5} {
+			if _, err := store.Get(height); err != ErrNotFound {
+				t.Fatalf("%s: get %d returned %v", name, height, err)
+			}
+		}
+		if _, err := store.GetByHash(chain[5].Hash); err != ErrNotFound {
+			t.Fatalf("%s: get of a missing hash returned %v", name, err)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	chain := blocks(t, 5)
+	for name, store := range stores(t) {
+		fill(t, store, chain)
+		for _, c := range []struct {
+			from, to int64
+			stop     int
+			heights  []int64
+		}{
+			{0, 4, -1, []int64{0, 1, 2, 3, 4}},
+			{-3, 1, -1, []int64{0, 1}},
+			{3, 10, -1, []int64{3, 4}},
+			{2, 1, -1, []int64{}},
+			{1, 4, 2, []int64{1, 2}},
+		} {
+			heights := make([]int64, 0)
+			err := store.Range(c.from, c.to, func(b *block.Block) bool {
+				heights = append(heights, b.Height)
+				return len(heights) != c.stop
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(heights) != len(c.heights) {
+				t.Fatalf("%s: range [%d, %d] visits %v, want %v", name, c.from, c.to, heights, c.heights)
+			}
+			for i := range heights {
+				if heights[i] != c.heights[i] {
+					t.Fatalf("%s: range [%d, %d] visits %v, want %v", name, c.from, c.to, heights, c.heights)
+				}
+			}
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	chain := blocks(t, 6)
+	for name, store := range stores(t) {
+		fill(t, store, chain[:5])
+		if err := store.Truncate(10); err != nil || store.Height() != 4 {
+			t.Fatalf("%s: truncate above the head left height %d, %v", name, store.Height(), err)
+		}
+		if err := store.Truncate(2); err != nil {
+			t.Fatal(err)
+		}
+		if store.Height() != 2 || store.Head().Hash != chain[2].Hash {
+			t.Fatalf("%s: head at %d after truncating to 2", name, store.Height())
+		}
+		if _, err := store.GetByHash(chain[3].Hash); err != ErrNotFound {
+			t.Fatalf("%s: truncated block is found by hash: %v", name, err)
+		}
+		// The chain grows again from the new head.
+		fill(t, store, chain[3:])
+		if store.Height() != 5 {
+			t.Fatalf("%s: head at %d after appending again", name, store.Height())
+		}
+		if err := store.Truncate(-5); err != nil || store.Height() != -1 || store.Head() != nil {
+			t.Fatalf("%s: truncate below genesis left height %d, %v", name, store.Height(), err)
+		}
+		fill(t, store, chain[:1])
+	}
+}
+
+func TestForNode(t *testing.T) {
+	defer func(dir string) {
+		Dir = dir
+	}(Dir)
+	Dir = t.TempDir()
+	chain := blocks(t, 3)
+	store, err := ForNode("node-1", chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill(t, store, chain[1:])
+	store.Close()
+
+	if store, err = ForNode("node-1", chain[0]); err != nil {
+		t.Fatal(err)
+	}
+	if store.Height() != 2 {
+		t.Fatalf("resumed ledger at height %d", store.Height())
+	}
+	store.Close()
+
+	other, err := block.Genesis(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ForNode("node-1", other); err == nil {
+		t.Fatal("ledger resumed from another genesis")
+	}
+}
@@ -0,0 +1,86 @@
+package mempool
+
+import (
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"log"
+	"net/http"
+)
+
+// GossipOperation is the node operation receiving transactions from peers.
+const GossipOperation = "tx"
+
+type TxMsg struct {
+	Txs []*Tx `json:"txs"`
+}
+
+func init() {
+	codec.Register(0x0001, 1, TxMsg{})
+}
+
+// Submit adds transactions from a local client and announces the accepted ones to all peers.
+func (pool *Pool) Submit(sender server.Sender, from string, txs ...*Tx) []error {
+	errs := make([]error, 0)
+	accepted := make([]*Tx, 0, len(txs))
+	for _, tx := range txs {
+		if err := pool.Add(tx); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		accepted = append(accepted, tx)
+	}
+	if len(accepted) != 0 {
+		broadcast := func() {
+			sender.Broadcast(from, GossipOperation, TxMsg{Txs: accepted})
+		}
+		// A node passed as sender runs the broadcast on its own runtime.
+		if runtime, ok := sender.(interface{ Go(fn func()) }); ok {
+			runtime.Go(broadcast)
+		} else {
+			go broadcast()
+		}
+	}
+	return errs
+}
+
+// HandleTx adds transactions announced by peers, they are not announced again.
+// Only the payload is taken from a peer: derive wraps it again the way a local request is,
+// so the peer can not claim another sender, nonce or priority.
+func (pool *Pool) HandleTx(logger *log.Logger, derive func(data json.RawMessage) (*Tx, error)) node.RouteFunc {
+	return func(_ http.ResponseWriter, request *http.Request) {
+		var msg TxMsg
+		err := codec.Decode(request, &msg)
+		if err != nil {
+			logger.Println(err)
+			return
+		}
+		for _, announced := range msg.Txs {
+			tx, err := derive(announced.Data)
+			if err == nil {
+				err = pool.Add(tx)
+			}
+			if err != nil && err != ErrKnown {
+				logger.Println(err)
+			}
+		}
+	}
+}
+
+// Derive returns the derive of HandleTx for payloads of type T wrapped by toTx,
+// a payload with a Validate method must pass it.
+func Derive[T any](toTx func(msg *T) (*Tx, error)) func(data json.RawMessage) (*Tx, error) {
+	return func(data json.RawMessage) (*Tx, error) {
+		msg := new(T)
+		if err := json.Unmarshal(data, msg); err != nil {
+			return nil, err
+		}
+		if validator, ok := interface{}(msg).(node.Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		return toTx(msg)
+	}
+}
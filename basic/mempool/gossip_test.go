@@ -0,0 +1,58 @@
+package mempool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type transfer struct {
+	From  string `json:"from"`
+	Nonce int64  `json:"nonce"`
+	Fee   int64  `json:"fee"`
+}
+
+func (msg *transfer) Validate() error {
+	if msg.Fee < 0 {
+		return errors.New("fee is negative")
+	}
+	return nil
+}
+
+func wrapTransfer(msg *transfer) (*Tx, error) {
+	return NewTx(msg.From, msg.Nonce, msg.Fee, msg)
+}
+
+func TestHandleTxDerivesFromPayload(t *testing.T) {
+	pool := New(DefaultConfig)
+	announce := func(txs ...*Tx) {
+		data, err := json.Marshal(TxMsg{Txs: txs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(http.MethodPost, "/node?from=node-2&operation=tx", bytes.NewReader(data))
+		request.Header.Set("Content-Type", codec.JSON.ContentType())
+		pool.HandleTx(log.Default(), Derive(wrapTransfer))(httptest.NewRecorder(), request)
+	}
+	// The peer claims another sender and a priority the payload does not pay.
+	claimed := func(msg *transfer) *Tx {
+		data, _ := json.Marshal(msg)
+		return &Tx{Hash: node.Hash(data), Sender: "mallory", Nonce: 7, Priority: 1 << 40, Data: data}
+	}
+	honest, negative := claimed(&transfer{From: "alice", Nonce: 0, Fee: 3}), claimed(&transfer{From: "bob", Fee: -1})
+	announce(honest, negative)
+
+	batch := pool.Batch(10, 1<<20)
+	if len(batch) != 1 {
+		t.Fatalf("pool holds %d transactions, want the valid one", len(batch))
+	}
+	if tx := batch[0]; tx.Sender != "alice" || tx.Nonce != 0 || tx.Priority != 3 {
+		t.Fatalf("announced transaction is kept as sender %s, nonce %d, priority %d", tx.Sender, tx.Nonce, tx.Priority)
+	}
+}
@@ -0,0 +1,291 @@
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKnown       = errors.New("transaction is already known")
+	ErrCorrupted   = errors.New("transaction hash does not match its data")
+	ErrUnderpriced = errors.New("transaction priority is too low to replace or evict")
+	ErrTooLarge    = errors.New("transaction is larger than the pool")
+)
+
+type Config struct {
+	MaxCount int
+	MaxBytes int
+	// transactions waiting longer than TTL are dropped
+	TTL time.Duration
+}
+
+var DefaultConfig = Config{
+	MaxCount: 10000,
+	MaxBytes: 64 << 20,
+	TTL:      time.Minute,
+}
+
+type Pool struct {
+	// Now is the clock of the pool, time.Now by default.
+	Now    func() time.Time
+	config Config
+	all    map[string]*Tx
+	// sender to its transactions sorted by nonce
+	senders map[string][]*Tx
+	bytes   int
+	// hash to the time the transaction left the pool, so it is not added again
+	seen map[string]time.Time
+	// transactions in the order they are added and hashes in the order they are seen,
+	// so expiring only looks at the oldest
+	arrivals   []stamp
+	departures []stamp
+	mutex      sync.Mutex
+}
+
+// stamp is a transaction added, or a hash seen, at a time.
+type stamp struct {
+	tx   *Tx
+	hash string
+	at   time.Time
+}
+
+func New(config Config) *Pool {
+	return &Pool{
+		Now:     time.Now,
+		config:  config,
+		all:     make(map[string]*Tx),
+		senders: make(map[string][]*Tx),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+func (pool *Pool) Len() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.all)
+}
+
+func (pool *Pool) Bytes() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.bytes
+}
+
+func (pool *Pool) Has(hash string) bool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	_, ok := pool.all[hash]
+	return ok
+}
+
+// Add puts tx into the pool. A transaction with the same sender and nonce is replaced
+// if tx has a higher priority, and the lowest priority transactions are evicted when the pool is full.
+func (pool *Pool) Add(tx *Tx) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.expire(pool.Now())
+
+	if _, ok := pool.all[tx.Hash]; ok {
+		return ErrKnown
+	}
+	if _, ok := pool.seen[tx.Hash]; ok {
+		return ErrKnown
+	}
+	if node.Hash(tx.Data) != tx.Hash {
+		return ErrCorrupted
+	}
+	if tx.Size() > pool.config.MaxBytes {
+		return ErrTooLarge
+	}
+
+	txs := pool.senders[tx.Sender]
+	i := sort.Search(len(txs), func(i int) bool { return txs[i].Nonce >= tx.Nonce })
+	if i < len(txs) && txs[i].Nonce == tx.Nonce {
+		if txs[i].Priority >= tx.Priority {
+			return ErrUnderpriced
+		}
+		pool.remove(txs[i])
+	}
+
+	for len(pool.all)+1 > pool.config.MaxCount || pool.bytes+tx.Size() > pool.config.MaxBytes {
+		victim := pool.victim()
+		if victim == nil || victim.Priority >= tx.Priority {
+			return ErrUnderpriced
+		}
+		pool.remove(victim)
+	}
+
+	tx.added = pool.Now()
+	if pool.config.TTL > 0 {
+		pool.arrivals = append(pool.arrivals, stamp{tx: tx, at: tx.added})
+	}
+	txs = pool.senders[tx.Sender]
+	i = sort.Search(len(txs), func(i int) bool { return txs[i].Nonce >= tx.Nonce })
+	txs = append(txs, nil)
+	copy(txs[i+1:], txs[i:])
+	txs[i] = tx
+	pool.senders[tx.Sender] = txs
+	pool.all[tx.Hash] = tx
+	pool.bytes += tx.Size()
+	return nil
+}
+
+// victim is the transaction to evict: the lowest priority one among the last of every sender,
+// so that no sender is left with a gap in its nonces.
+func (pool *Pool) victim() *Tx {
+	var victim *Tx
+	for _, txs := range pool.senders {
+		last := txs[len(txs)-1]
+		if victim == nil || (txHeap{victim, last}).Less(0, 1) {
+			victim = last
+		}
+	}
+	return victim
+}
+
+func (pool *Pool) remove(tx *Tx) {
+	delete(pool.all, tx.Hash)
+	pool.bytes -= tx.Size()
+	txs := pool.senders[tx.Sender]
+	for i := range txs {
+		if txs[i] == tx {
+			txs = append(txs[:i], txs[i+1:]...)
+			break
+		}
+	}
+	if len(txs) == 0 {
+		delete(pool.senders, tx.Sender)
+	} else {
+		pool.senders[tx.Sender] = txs
+	}
+}
+
+// Remove drops the committed transactions, they are not accepted again within TTL.
+func (pool *Pool) Remove(hashes ...string) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	now := pool.Now()
+	for _, hash := range hashes {
+		if tx, ok := pool.all[hash]; ok {
+			pool.remove(tx)
+		}
+		pool.seen[hash] = now
+		if pool.config.TTL > 0 {
+			pool.departures = append(pool.departures, stamp{hash: hash, at: now})
+		}
+	}
+}
+
+// Restore puts back transactions of a block dropped by a reorganization.
+func (pool *Pool) Restore(txs ...*Tx) []error {
+	pool.mutex.Lock()
+	for _, tx := range txs {
+		delete(pool.seen, tx.Hash)
+	}
+	pool.mutex.Unlock()
+
+	errs := make([]error, 0)
+	for _, tx := range txs {
+		if err := pool.Add(tx); err != nil && err != ErrKnown {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Expire drops transactions older than TTL and returns how many are dropped.
+func (pool *Pool) Expire() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.expire(pool.Now())
+}
+
+func (pool *Pool) expire(now time.Time) int {
+	if pool.config.TTL <= 0 {
+		return 0
+	}
+	// Stamps of transactions since removed or added again, and of hashes seen again, are skipped.
+	count := 0
+	for len(pool.arrivals) > 0 && now.Sub(pool.arrivals[0].at) > pool.config.TTL {
+		arrival := pool.arrivals[0]
+		pool.arrivals = pool.arrivals[1:]
+		if pool.all[arrival.tx.Hash] == arrival.tx && arrival.tx.added.Equal(arrival.at) {
+			pool.remove(arrival.tx)
+			count++
+		}
+	}
+	for len(pool.departures) > 0 && now.Sub(pool.departures[0].at) > pool.config.TTL {
+		departure := pool.departures[0]
+		pool.departures = pool.departures[1:]
+		if at, ok := pool.seen[departure.hash]; ok && at.Equal(departure.at) {
+			delete(pool.seen, departure.hash)
+		}
+	}
+	return count
+}
+
+// Batch picks at most maxCount transactions of at most maxBytes in total for a proposal,
+// higher priority first and every sender in nonce order. They stay in the pool until Remove.
+func (pool *Pool) Batch(maxCount int, maxBytes int) []*Tx {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.expire(pool.Now())
+
+	heads := &txHeap{}
+	next := make(map[string]int)
+	for sender, txs := range pool.senders {
+		heap.Push(heads, txs[0])
+		next[sender] = 1
+	}
+	batch := make([]*Tx, 0)
+	bytes := 0
+	for heads.Len() > 0 && len(batch) < maxCount {
+		tx := heap.Pop(heads).(*Tx)
+		if bytes+tx.Size() > maxBytes {
+			// Later transactions of this sender must wait for this one.
+			continue
+		}
+		batch = append(batch, tx)
+		bytes += tx.Size()
+		if txs := pool.senders[tx.Sender]; next[tx.Sender] < len(txs) {
+			heap.Push(heads, txs[next[tx.Sender]])
+			next[tx.Sender]++
+		}
+	}
+	return batch
+}
+
+type txHeap []*Tx
+
+func (h txHeap) Len() int {
+	return len(h)
+}
+
+func (h txHeap) Less(i, j int) bool {
+	if h[i].Priority != h[j].Priority {
+		return h[i].Priority > h[j].Priority
+	}
+	if !h[i].added.Equal(h[j].added) {
+		return h[i].added.Before(h[j].added)
+	}
+	return h[i].Hash < h[j].Hash
+}
+
+func (h txHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *txHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Tx))
+}
+
+func (h *txHeap) Pop() interface{} {
+	old := *h
+	tx := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return tx
+}
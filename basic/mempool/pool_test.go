@@ -0,0 +1,50 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpireOldestFirst(t *testing.T) {
+	now := time.Unix(0, 0)
+	pool := New(Config{MaxCount: 100, MaxBytes: 1 << 20, TTL: time.Minute})
+	pool.Now = func() time.Time { return now }
+	txs := make([]*Tx, 4)
+	for i := range txs {
+		tx, err := NewTx("sender", int64(i), 0, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		txs[i] = tx
+		now = now.Add(time.Second * 20)
+	}
+
+	// The first transaction is committed, then comes back with a reorganization.
+	pool.Remove(txs[0].Hash)
+	if errs := pool.Restore(txs[0]); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	now = now.Add(time.Second * 5)
+	if expired := pool.Expire(); expired != 1 || pool.Has(txs[1].Hash) || !pool.Has(txs[2].Hash) {
+		t.Fatalf("%d expired at 85s, want the one added at 20s", expired)
+	}
+	if !pool.Has(txs[0].Hash) {
+		t.Fatal("restored transaction expired by the time it was first added")
+	}
+
+	pool.Remove(txs[3].Hash)
+	if err := pool.Add(txs[3]); err != ErrKnown {
+		t.Fatalf("committed transaction is added again: %v", err)
+	}
+	now = now.Add(time.Minute + time.Second)
+	pool.Expire()
+	if pool.Len() != 0 {
+		t.Fatalf("%d transactions left after TTL", pool.Len())
+	}
+	if err := pool.Add(txs[3]); err != nil {
+		t.Fatalf("transaction committed before TTL is refused: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package mempool
+
+import (
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"math"
+	"time"
+)
+
+// Tx wraps a payload with what the pool needs to order it.
+// Transactions of one sender are taken in nonce order, senders are served by priority.
+type Tx struct {
+	Hash     string          `json:"hash"`
+	Sender   string          `json:"sender"`
+	Nonce    int64           `json:"nonce"`
+	Priority int64           `json:"priority"`
+	Data     json.RawMessage `json:"data"`
+
+	added time.Time
+}
+
+func NewTx(sender string, nonce int64, priority int64, payload interface{}) (*Tx, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{
+		Hash:     node.Hash(data),
+		Sender:   sender,
+		Nonce:    nonce,
+		Priority: priority,
+		Data:     data,
+	}, nil
+}
+
+// Priority is the priority of a transaction paying fee, fees beyond the range of priorities share the highest one.
+func Priority(fee uint64) int64 {
+	if fee > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(fee)
+}
+
+func (tx *Tx) Size() int {
+	return len(tx.Data)
+}
+
+// Decode unmarshals the payload of every transaction.
+func Decode[T any](txs []*Tx) ([]*T, error) {
+	payloads := make([]*T, 0, len(txs))
+	for _, tx := range txs {
+		var payload T
+		if err := json.Unmarshal(tx.Data, &payload); err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, &payload)
+	}
+	return payloads, nil
+}
+
+// Payloads returns the raw payloads, to be put into a block.
+func Payloads(txs []*Tx) []json.RawMessage {
+	data := make([]json.RawMessage, len(txs))
+	for i, tx := range txs {
+		data[i] = tx.Data
+	}
+	return data
+}
@@ -0,0 +1,145 @@
+package merkle
+
+import "crypto/sha256"
+
+// Depth is the number of levels below the root of a sparse tree, one for every bit of a key path.
+const Depth = sha256.Size * 8
+
+type path = [sha256.Size]byte
+
+// empty[h] is the hash of a subtree of height h without any leaf.
+var empty = func() [Depth + 1]string {
+	var hashes [Depth + 1]string
+	hashes[0] = EmptyRoot
+	for h := 1; h <= Depth; h++ {
+		hashes[h] = HashNode(hashes[h-1], hashes[h-1])
+	}
+	return hashes
+}()
+
+// Sparse is a Merkle tree over all 2^256 key paths, only the non-empty nodes are stored.
+// It proves both the presence and the absence of a key.
+type Sparse struct {
+	nodes  map[string]string
+	values map[string][]byte
+}
+
+func NewSparse() *Sparse {
+	return &Sparse{
+		nodes:  make(map[string]string),
+		values: make(map[string][]byte),
+	}
+}
+
+func pathOf(key string) path {
+	return sha256.Sum256([]byte(key))
+}
+
+func bit(p path, i int) byte {
+	return (p[i/8] >> (7 - i%8)) & 1
+}
+
+// mask clears the lowest height bits, leaving the path of the subtree at that height.
+func mask(p path, height int) path {
+	for i := Depth - height; i < Depth; i++ {
+		p[i/8] &^= 1 << (7 - i%8)
+	}
+	return p
+}
+
+func flip(p path, i int) path {
+	p[i/8] ^= 1 << (7 - i%8)
+	return p
+}
+
+func nodeKey(height int, p path) string {
+	masked := mask(p, height)
+	return string(rune(height)) + string(masked[:])
+}
+
+func (sparse *Sparse) get(height int, p path) string {
+	if hash, ok := sparse.nodes[nodeKey(height, p)]; ok {
+		return hash
+	}
+	return empty[height]
+}
+
+func (sparse *Sparse) set(height int, p path, hash string) {
+	if hash == empty[height] {
+		delete(sparse.nodes, nodeKey(height, p))
+		return
+	}
+	sparse.nodes[nodeKey(height, p)] = hash
+}
+
+func (sparse *Sparse) Root() string {
+	return sparse.get(Depth, path{})
+}
+
+func (sparse *Sparse) Get(key string) ([]byte, bool) {
+	value, ok := sparse.values[key]
+	return value, ok
+}
+
+// Set stores value under key and rehashes its path, a nil value deletes the key.
+func (sparse *Sparse) Set(key string, value []byte) {
+	p := pathOf(key)
+	hash := empty[0]
+	if value == nil {
+		delete(sparse.values, key)
+	} else {
+		sparse.values[key] = value
+		hash = HashLeaf(value)
+	}
+	sparse.set(0, p, hash)
+	for h := 1; h <= Depth; h++ {
+		sibling := sparse.get(h-1, flip(p, Depth-h))
+		if bit(p, Depth-h) == 0 {
+			hash = HashNode(hash, sibling)
+		} else {
+			hash = HashNode(sibling, hash)
+		}
+		sparse.set(h, p, hash)
+	}
+}
+
+func (sparse *Sparse) Delete(key string) {
+	sparse.Set(key, nil)
+}
+
+// SparseProof lists the siblings along a key path from the leaf up, empty subtrees are left out.
+type SparseProof struct {
+	Siblings map[int]string `json:"siblings"`
+}
+
+func (sparse *Sparse) Prove(key string) *SparseProof {
+	p := pathOf(key)
+	proof := &SparseProof{Siblings: make(map[int]string)}
+	for h := 1; h <= Depth; h++ {
+		if sibling := sparse.get(h-1, flip(p, Depth-h)); sibling != empty[h-1] {
+			proof.Siblings[h-1] = sibling
+		}
+	}
+	return proof
+}
+
+// Verify tells if key holds value in the tree with root, a nil value checks the key is absent.
+func (proof *SparseProof) Verify(root string, key string, value []byte) bool {
+	p := pathOf(key)
+	hash := empty[0]
+	if value != nil {
+		hash = HashLeaf(value)
+	}
+	for h := 1; h <= Depth; h++ {
+		sibling, ok := proof.Siblings[h-1]
+		if !ok {
+			sibling = empty[h-1]
+		}
+		if bit(p, Depth-h) == 0 {
+			hash = HashNode(hash, sibling)
+		} else {
+			hash = HashNode(sibling, hash)
+		}
+	}
+	return hash == root
+}
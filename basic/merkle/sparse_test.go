@@ -0,0 +1,110 @@
+package merkle
+
+import "testing"
+
+func TestSparseProofs(t *testing.T) {
+	sparse := NewSparse()
+	if sparse.Root() != empty[Depth] {
+		t.Fatal("empty sparse tree has a root other than the empty subtree")
+	}
+	sparse.Set("alice", []byte("10"))
+	sparse.Set("bob", []byte("20"))
+	root := sparse.Root()
+
+	for _, c := range []struct {
+		name  string
+		key   string
+		value []byte
+		holds bool
+	}{
+		{"presence", "alice", []byte("10"), true},
+		{"other presence", "bob", []byte("20"), true},
+		{"wrong value", "alice", []byte("11"), false},
+		{"absence", "carol", nil, true},
+		{"absence of a present key", "alice", nil, false},
+		{"presence of an absent key", "carol", []byte("10"), false},
+	} {
+		if holds := sparse.Prove(c.key).Verify(root, c.key, c.value); holds != c.holds {
+			t.Fatalf("%s proof holds %t", c.name, holds)
+		}
+	}
+}
+
+func TestSparseTamperedProof(t *testing.T) {
+	sparse := NewSparse()
+	for _, key := range []string{"alice", "bob", "carol"} {
+		sparse.Set(key, []byte(key))
+	}
+	for _, c := range []struct {
+		name   string
+		tamper func(proof *SparseProof)
+	}{
+		{"sibling hash", func(proof *SparseProof) {
+			for h := range proof.Siblings {
+				proof.Siblings[h] = HashLeaf([]byte("forged"))
+			}
+		}},
+		{"dropped sibling", func(proof *SparseProof) {
+			for h := range proof.Siblings {
+				delete(proof.Siblings, h)
+				return
+			}
+		}},
+		{"extra sibling", func(proof *SparseProof) { proof.Siblings[Depth-1] = EmptyRoot }},
+	} {
+		for _, key := range []string{"alice", "dave"} {
+			var value []byte
+			if key == "alice" {
+				value = []byte(key)
+			}
+			proof := sparse.Prove(key)
+			c.tamper(proof)
+			if proof.Verify(sparse.Root(), key, value) {
+				t.Fatalf("proof for %s with tampered %s holds", key, c.name)
+			}
+		}
+	}
+}
+
+// TestSparseUpdate checks the root only depends on the content, not on the order of the writes.
+func TestSparseUpdate(t *testing.T) {
+	forward, backward := NewSparse(), NewSparse()
+	keys := []string{"alice", "bob", "carol", "dave"}
+	for i := range keys {
+		forward.Set(keys[i], []byte(keys[i]))
+		backward.Set(keys[len(keys)-1-i], []byte(keys[len(keys)-1-i]))
+	}
+	if forward.Root() != backward.Root() {
+		t.Fatal("same keys written in another order give another root")
+	}
+
+	before := forward.Root()
+	forward.Set("bob", []byte("changed"))
+	if forward.Root() == before {
+		t.Fatal("updating a value keeps the root")
+	}
+	if value, ok := forward.Get("bob"); !ok || string(value) != "changed" {
+		t.Fatalf("bob holds %q", value)
+	}
+	if !forward.Prove("bob").Verify(forward.Root(), "bob", []byte("changed")) {
+		t.Fatal("updated value is not proved")
+	}
+	forward.Set("bob", []byte("bob"))
+	if forward.Root() != before {
+		t.Fatal("restoring a value does not restore the root")
+	}
+
+	forward.Delete("dave")
+	if _, ok := forward.Get("dave"); ok {
+		t.Fatal("deleted key is still held")
+	}
+	if !forward.Prove("dave").Verify(forward.Root(), "dave", nil) {
+		t.Fatal("absence of a deleted key is not proved")
+	}
+	for _, key := range keys[:3] {
+		forward.Delete(key)
+	}
+	if forward.Root() != empty[Depth] || len(forward.nodes) != 0 {
+		t.Fatalf("tree emptied by deletes keeps %d nodes", len(forward.nodes))
+	}
+}
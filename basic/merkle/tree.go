@@ -0,0 +1,155 @@
+package merkle
+
+import (
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/node"
+)
+
+// Prefixes separate leaves from inner nodes, so a leaf can never be taken as an inner node.
+const (
+	leafPrefix = "\x00"
+	nodePrefix = "\x01"
+)
+
+func HashLeaf(data []byte) string {
+	return node.Hash(append([]byte(leafPrefix), data...))
+}
+
+func HashNode(left string, right string) string {
+	return node.Hash([]byte(nodePrefix + left + right))
+}
+
+// EmptyRoot is the root of a tree without leaves.
+var EmptyRoot = node.Hash(nil)
+
+// Tree is a binary Merkle tree over an ordered list of leaves.
+// A node without sibling is promoted to the upper level unchanged.
+type Tree struct {
+	// levels[0] are the leaf hashes, the last level holds the root
+	levels [][]string
+}
+
+func New(leaves [][]byte) *Tree {
+	tree := &Tree{levels: [][]string{make([]string, 0, len(leaves))}}
+	for _, leaf := range leaves {
+		tree.levels[0] = append(tree.levels[0], HashLeaf(leaf))
+	}
+	tree.build()
+	return tree
+}
+
+// Root computes the root over leaves without keeping the tree.
+func Root[T ~[]byte](leaves []T) string {
+	data := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		data[i] = leaf
+	}
+	return New(data).Root()
+}
+
+func (tree *Tree) build() {
+	tree.levels = tree.levels[:1]
+	for level := tree.levels[0]; len(level) > 1; {
+		next := make([]string, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, HashNode(level[i], level[i+1]))
+			}
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+}
+
+func (tree *Tree) Len() int {
+	return len(tree.levels[0])
+}
+
+func (tree *Tree) Root() string {
+	if tree.Len() == 0 {
+		return EmptyRoot
+	}
+	return tree.levels[len(tree.levels)-1][0]
+}
+
+// Append adds a leaf at the end, only the path of the new leaf is rehashed.
+func (tree *Tree) Append(leaf []byte) {
+	tree.levels[0] = append(tree.levels[0], HashLeaf(leaf))
+	tree.rehash(tree.Len() - 1)
+}
+
+// Update replaces the leaf at index, only its path is rehashed.
+func (tree *Tree) Update(index int, leaf []byte) error {
+	if index < 0 || index >= tree.Len() {
+		return errors.New("leaf index out of range")
+	}
+	tree.levels[0][index] = HashLeaf(leaf)
+	tree.rehash(index)
+	return nil
+}
+
+func (tree *Tree) rehash(index int) {
+	l := 0
+	for ; len(tree.levels[l]) > 1; l++ {
+		if l+1 == len(tree.levels) {
+			tree.levels = append(tree.levels, make([]string, 0, 1))
+		}
+		level, parent := tree.levels[l], index/2
+		hash := level[index]
+		if sibling := index ^ 1; sibling < len(level) {
+			if sibling < index {
+				hash = HashNode(level[sibling], level[index])
+			} else {
+				hash = HashNode(level[index], level[sibling])
+			}
+		}
+		if parent == len(tree.levels[l+1]) {
+			tree.levels[l+1] = append(tree.levels[l+1], hash)
+		} else {
+			tree.levels[l+1][parent] = hash
+		}
+		index = parent
+	}
+	tree.levels = tree.levels[:l+1]
+}
+
+type Step struct {
+	Hash string `json:"hash"`
+	// the sibling is on the left of the path
+	Left bool `json:"left"`
+}
+
+// Proof shows the leaf at Index is included in the tree with some root.
+type Proof struct {
+	Index int    `json:"index"`
+	Steps []Step `json:"steps"`
+}
+
+func (tree *Tree) Prove(index int) (*Proof, error) {
+	if index < 0 || index >= tree.Len() {
+		return nil, errors.New("leaf index out of range")
+	}
+	proof := &Proof{Index: index, Steps: make([]Step, 0, len(tree.levels))}
+	for l := 0; len(tree.levels[l]) > 1; l++ {
+		if sibling := index ^ 1; sibling < len(tree.levels[l]) {
+			proof.Steps = append(proof.Steps, Step{Hash: tree.levels[l][sibling], Left: sibling < index})
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// Verify tells if leaf is included in the tree with root.
+func (proof *Proof) Verify(root string, leaf []byte) bool {
+	hash := HashLeaf(leaf)
+	for _, step := range proof.Steps {
+		if step.Left {
+			hash = HashNode(step.Hash, hash)
+		} else {
+			hash = HashNode(hash, step.Hash)
+		}
+	}
+	return hash == root
+}
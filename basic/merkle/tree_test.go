@@ -0,0 +1,110 @@
+package merkle
+
+import (
+	"strconv"
+	"testing"
+)
+
+func leaves(n int) [][]byte {
+	data := make([][]byte, n)
+	for i := range data {
+		data[i] = []byte("leaf-" + strconv.Itoa(i))
+	}
+	return data
+}
+
+func TestProveVerify(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 7, 8, 13} {
+		tree := New(leaves(n))
+		for i, leaf := range leaves(n) {
+			proof, err := tree.Prove(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !proof.Verify(tree.Root(), leaf) {
+				t.Fatalf("leaf %d of %d is not proved", i, n)
+			}
+			if proof.Verify(tree.Root(), []byte("other")) {
+				t.Fatalf("leaf %d of %d proves another leaf", i, n)
+			}
+		}
+	}
+}
+
+func TestProveOutOfRange(t *testing.T) {
+	tree := New(leaves(3))
+	for _, index := range []int{-1, 3} {
+		if _, err := tree.Prove(index); err == nil {
+			t.Fatalf("proof of leaf %d in a tree of 3", index)
+		}
+	}
+	if _, err := New(nil).Prove(0); err == nil {
+		t.Fatal("proof of a leaf in an empty tree")
+	}
+}
+
+func TestTamperedProof(t *testing.T) {
+	tree := New(leaves(7))
+	for _, c := range []struct {
+		name   string
+		tamper func(proof *Proof)
+	}{
+		{"sibling hash", func(proof *Proof) { proof.Steps[0].Hash = HashLeaf([]byte("forged")) }},
+		{"sibling side", func(proof *Proof) { proof.Steps[1].Left = !proof.Steps[1].Left }},
+		{"dropped step", func(proof *Proof) { proof.Steps = proof.Steps[:len(proof.Steps)-1] }},
+		{"extra step", func(proof *Proof) { proof.Steps = append(proof.Steps, Step{Hash: EmptyRoot}) }},
+	} {
+		proof, err := tree.Prove(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.tamper(proof)
+		if proof.Verify(tree.Root(), leaves(7)[2]) {
+			t.Fatalf("proof with tampered %s holds", c.name)
+		}
+	}
+}
+
+// TestIncremental checks Append and Update give the root of a tree built at once.
+func TestIncremental(t *testing.T) {
+	data := leaves(9)
+	tree := New(nil)
+	if tree.Root() != EmptyRoot {
+		t.Fatal("empty tree has a root other than EmptyRoot")
+	}
+	for i, leaf := range data {
+		tree.Append(leaf)
+		if root := Root(data[:i+1]); tree.Root() != root {
+			t.Fatalf("root after %d appends differs from the tree built at once", i+1)
+		}
+	}
+	for _, index := range []int{0, 4, 8} {
+		data[index] = []byte("updated-" + strconv.Itoa(index))
+		if err := tree.Update(index, data[index]); err != nil {
+			t.Fatal(err)
+		}
+		if tree.Root() != Root(data) {
+			t.Fatalf("root after updating leaf %d differs from the tree built at once", index)
+		}
+		proof, err := tree.Prove(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.Verify(tree.Root(), data[index]) {
+			t.Fatalf("updated leaf %d is not proved", index)
+		}
+	}
+	if err := tree.Update(9, nil); err == nil {
+		t.Fatal("update beyond the last leaf")
+	}
+}
+
+// TestLeafIsNotNode checks an inner node cannot be passed off as a leaf.
+func TestLeafIsNotNode(t *testing.T) {
+	tree := New(leaves(4))
+	forged := []byte(tree.levels[1][0])
+	proof := &Proof{Index: 0, Steps: []Step{{Hash: tree.levels[1][1]}}}
+	if proof.Verify(tree.Root(), forged) {
+		t.Fatal("inner node verifies as a leaf")
+	}
+}
@@ -0,0 +1,92 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"net/http"
+)
+
+// Validator is a message checking itself once decoded, before it reaches its handler.
+type Validator interface {
+	Validate() error
+}
+
+// Register routes operation to handle, which gets the id the sender claims and the message decoded into T.
+// Messages which can not be decoded or are invalid are answered with 400, the errors of handle with 422,
+// unless they have a Status method telling another code.
+func Register[T any](node *Node, operation string, handle func(from string, msg *T) error) {
+	node.Operations[operation] = func(writer http.ResponseWriter, request *http.Request) {
+		msg, ok := decode[T](node, operation, writer, request)
+		if !ok {
+			return
+		}
+		if err := handle(request.URL.Query().Get("from"), msg); err != nil {
+			fail(node, operation, writer, err)
+		}
+	}
+}
+
+// RegisterReply is Register for operations answering the sender, the reply is written as JSON.
+func RegisterReply[T any, R any](node *Node, operation string, handle func(from string, msg *T) (*R, error)) {
+	node.Operations[operation] = func(writer http.ResponseWriter, request *http.Request) {
+		msg, ok := decode[T](node, operation, writer, request)
+		if !ok {
+			return
+		}
+		reply, err := handle(request.URL.Query().Get("from"), msg)
+		if err != nil {
+			fail(node, operation, writer, err)
+			return
+		}
+		jsonMessage, err := json.Marshal(reply)
+		if err != nil {
+			fail(node, operation, writer, &statusError{http.StatusInternalServerError, err})
+			return
+		}
+		writer.Header().Set("Content-Type", codec.JSON.ContentType())
+		writer.Write(jsonMessage)
+	}
+}
+
+func decode[T any](node *Node, operation string, writer http.ResponseWriter, request *http.Request) (*T, bool) {
+	msg := new(T)
+	err := codec.Decode(request, msg)
+	if err == nil {
+		if validator, ok := interface{}(msg).(Validator); ok {
+			err = validator.Validate()
+		}
+	}
+	if err != nil {
+		fail(node, operation, writer, &statusError{http.StatusBadRequest, err})
+		return nil, false
+	}
+	return msg, true
+}
+
+func fail(node *Node, operation string, writer http.ResponseWriter, err error) {
+	node.Println(operation, err)
+	status := http.StatusUnprocessableEntity
+	var statusErr interface{ Status() int }
+	if errors.As(err, &statusErr) {
+		status = statusErr.Status()
+	}
+	writer.WriteHeader(status)
+}
+
+type statusError struct {
+	status int
+	err    error
+}
+
+func (err *statusError) Error() string {
+	return err.err.Error()
+}
+
+func (err *statusError) Unwrap() error {
+	return err.err
+}
+
+func (err *statusError) Status() int {
+	return err.status
+}
@@ -1,24 +1,41 @@
 package node
 
 import (
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/gossip"
+	"github.com/glimmerzcy/bccp/basic/pki"
 	"github.com/glimmerzcy/bccp/basic/server"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"sync"
 	"time"
 )
 
 type Node struct {
 	ID string
 	*log.Logger
-	server.Sender
+	// messages go out through it, see Sender
+	outbox *outbox
+	Runtime
 	Operations map[string]RouteFunc
+	// operations only the center may run, see Control
+	control map[string]bool
+	// background work started through the node, ended by Stop
+	background *background
 }
 
-// Factory TODO: create node with reflection
-type Factory struct {
-	Name string
+type background struct {
+	stops   []func()
+	stopped bool
+	mutex   sync.Mutex
+}
+
+// outbox is shared by the copies of a node, so replacing the sender reaches all of them.
+type outbox struct {
+	sender server.Sender
+	mutex  sync.RWMutex
 }
 
 type RouteFunc = func(http.ResponseWriter, *http.Request)
@@ -27,8 +44,15 @@ func NewNode(id string, sender server.Sender) *Node {
 	node := &Node{
 		ID:         id,
 		Logger:     nil,
-		Sender:     sender,
+		outbox:     &outbox{sender: sender},
+		Runtime:    RealRuntime{},
 		Operations: make(map[string]RouteFunc),
+		control:    make(map[string]bool),
+		background: &background{},
+	}
+	// A sender driving its nodes, like a simulator, also runs them.
+	if runtime, ok := sender.(Runtime); ok {
+		node.Runtime = runtime
 	}
 
 	// log init
@@ -48,7 +72,119 @@ func NewNode(id string, sender server.Sender) *Node {
 	return node
 }
 
+// Start is called once the node is registered, protocols with timers start them here.
+func (node *Node) Start() {}
+
+// Stop ends the timers and the workers of the node, and returns once they are done.
+// Messages arriving afterwards are dropped.
+func (node *Node) Stop() {
+	node.background.mutex.Lock()
+	stops := node.background.stops
+	node.background.stops = nil
+	node.background.stopped = true
+	node.background.mutex.Unlock()
+	for i := len(stops) - 1; i >= 0; i-- {
+		stops[i]()
+	}
+	node.Println("stopped")
+	if file, ok := node.Writer().(*os.File); ok {
+		file.Close()
+	}
+}
+
+// Every calls fn once every period on the runtime of the node, until stop or Stop.
+func (node *Node) Every(period time.Duration, fn func()) (stop func()) {
+	stop = node.Runtime.Every(period, fn)
+	node.onStop(stop)
+	return stop
+}
+
+// Serial returns a function handing work to a single worker of the node, until Stop.
+func (node *Node) Serial() func(fn func()) {
+	run, stop := node.Runtime.Serial()
+	node.onStop(stop)
+	return run
+}
+
+func (node *Node) onStop(stop func()) {
+	node.background.mutex.Lock()
+	stopped := node.background.stopped
+	if !stopped {
+		node.background.stops = append(node.background.stops, stop)
+	}
+	node.background.mutex.Unlock()
+	if stopped {
+		stop()
+	}
+}
+
+// Control makes operations run only for the center, like turning the node Byzantine.
+// Under TLS the certificate of the caller must be the center's, whatever the from of the request claims,
+// and a message relayed by gossip never runs them since no certificate vouches for its origin.
+func (node *Node) Control(operations ...string) {
+	for _, operation := range operations {
+		node.control[operation] = true
+	}
+}
+
 func (node *Node) DoOperation(operation string, writer http.ResponseWriter, request *http.Request) {
 	node.Println("do", operation)
-	node.Operations[operation](writer, request)
+	if node.control[operation] && !fromCenter(request) {
+		node.Println("refuse", operation, "of", pki.PeerName(request), request.Header.Get(gossip.OriginHeader))
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+	// Refuse encodings this node can not read, the sender falls back to JSON.
+	if err := codec.Accept(request); err != nil {
+		node.Println(err)
+		writer.Header().Set("Accept", codec.Accepted())
+		writer.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	route, ok := node.Operations[operation]
+	if !ok {
+		node.Println("unknown operation", operation)
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	route(writer, request)
+}
+
+// fromCenter tells if request may run a control operation.
+func fromCenter(request *http.Request) bool {
+	if request.Header.Get(gossip.OriginHeader) != "" {
+		return false
+	}
+	return request.TLS == nil || pki.PeerName(request) == pki.CenterName
+}
+
+// Sender is the sender messages of the node go out through.
+func (node *Node) Sender() server.Sender {
+	node.outbox.mutex.RLock()
+	defer node.outbox.mutex.RUnlock()
+	return node.outbox.sender
+}
+
+// UseSender sends the messages of the node through sender from now on, also while others are sent.
+func (node *Node) UseSender(sender server.Sender) {
+	node.outbox.mutex.Lock()
+	defer node.outbox.mutex.Unlock()
+	node.outbox.sender = sender
+}
+
+func (node *Node) Send(from string, to string, operation string, message interface{}) (*http.Response, error) {
+	return node.Sender().Send(from, to, operation, message)
+}
+
+func (node *Node) Broadcast(from string, operation string, message interface{}) ([]*http.Response, []error) {
+	return node.Sender().Broadcast(from, operation, message)
+}
+
+// UseGossip makes the broadcasts of the node travel epidemically, peers must use gossip too.
+func (node *Node) UseGossip(config gossip.Config) *gossip.Gossip {
+	g := gossip.New(node.ID, node.Sender(), node, node, config)
+	node.UseSender(g)
+	node.Operations[gossip.PushOperation] = g.HandlePush
+	node.Operations[gossip.PullOperation] = g.HandlePull
+	return g
 }
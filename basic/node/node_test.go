@@ -0,0 +1,117 @@
+package node
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/glimmerzcy/bccp/basic/gossip"
+	"github.com/glimmerzcy/bccp/basic/pki"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain runs in a temporary directory, nodes write their logs to the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "node")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestStopEndsBackground(t *testing.T) {
+	node := NewNode("node-1", nil)
+	var ticks, works int32
+	node.Every(time.Millisecond, func() {
+		atomic.AddInt32(&ticks, 1)
+	})
+	run := node.Serial()
+	run(func() {
+		atomic.AddInt32(&works, 1)
+	})
+	for atomic.LoadInt32(&ticks) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	node.Stop()
+	stopped := atomic.LoadInt32(&ticks)
+	run(func() {
+		atomic.AddInt32(&works, 1)
+	})
+	time.Sleep(10 * time.Millisecond)
+	if ticks := atomic.LoadInt32(&ticks); ticks != stopped {
+		t.Fatalf("ticked %d times after stop", ticks-stopped)
+	}
+	if works := atomic.LoadInt32(&works); works != 1 {
+		t.Fatalf("worker ran %d pieces of work, want 1 before stop", works)
+	}
+}
+
+func TestControlNeedsCenter(t *testing.T) {
+	node := NewNode("node-1", nil)
+	defer node.Stop()
+	node.Operations["byzantine"] = func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}
+	node.Control("byzantine")
+	peer := func(name string) *tls.ConnectionState {
+		certificate := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	}
+	for _, c := range []struct {
+		name  string
+		state *tls.ConnectionState
+		code  int
+	}{
+		{"plain", nil, http.StatusOK},
+		{"center", peer(pki.CenterName), http.StatusOK},
+		{"server claiming to be the center", peer("server-1"), http.StatusForbidden},
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/node?from=center&to=node-1&operation=byzantine", nil)
+		request.TLS = c.state
+		recorder := httptest.NewRecorder()
+		node.DoOperation("byzantine", recorder, request)
+		if recorder.Code != c.code {
+			t.Fatalf("%s: answered %d, want %d", c.name, recorder.Code, c.code)
+		}
+	}
+}
+
+func TestControlRefusedByGossip(t *testing.T) {
+	memory := server.NewMemory(nil)
+	config := gossip.DefaultConfig
+	config.PullPeriod = 0
+	runs := make(map[string]int)
+	for _, id := range []string{"node-1", "node-2"} {
+		node := NewNode(id, memory)
+		node.UseGossip(config)
+		for _, operation := range []string{"genesis", "block"} {
+			operation := operation
+			node.Operations[operation] = func(writer http.ResponseWriter, _ *http.Request) {
+				runs[node.ID+" "+operation]++
+			}
+		}
+		node.Control("genesis")
+		memory.Register(id, node)
+		defer node.Stop()
+	}
+	sender, _ := memory.OperatorTable.Get("node-1")
+	sender.(*Node).Broadcast("node-1", "genesis", struct{}{})
+	sender.(*Node).Broadcast("node-1", "block", struct{}{})
+	if runs["node-2 genesis"] != 0 {
+		t.Fatal("control operation relayed by gossip is run")
+	}
+	if runs["node-2 block"] != 1 {
+		t.Fatalf("operation relayed by gossip is run %d times", runs["node-2 block"])
+	}
+}
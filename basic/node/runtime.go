@@ -0,0 +1,97 @@
+package node
+
+import (
+	"sync"
+	"time"
+)
+
+// Runtime runs the clock, the timers and the background work of a node.
+// A simulator replaces it to drive nodes on virtual time in a fixed order.
+type Runtime interface {
+	Now() time.Time
+	// Go runs fn without blocking the caller.
+	Go(fn func())
+	// Schedule runs fn once delay has passed, without blocking the caller.
+	Schedule(delay time.Duration, fn func())
+	// Every calls fn once every period until stop is called.
+	Every(period time.Duration, fn func()) (stop func())
+	// Serial returns a function handing work to a single worker, which runs it in order until stop is called.
+	Serial() (run func(fn func()), stop func())
+}
+
+// RealRuntime uses the wall clock and goroutines.
+type RealRuntime struct{}
+
+// CanWait tells if the work of node may wait for other nodes, which only holds on the wall clock:
+// a virtual clock stands still until the event waiting has returned.
+func (node *Node) CanWait() bool {
+	_, ok := node.Runtime.(RealRuntime)
+	return ok
+}
+
+func (RealRuntime) Now() time.Time {
+	return time.Now()
+}
+
+func (RealRuntime) Go(fn func()) {
+	go fn()
+}
+
+func (RealRuntime) Schedule(delay time.Duration, fn func()) {
+	time.AfterFunc(delay, fn)
+}
+
+// Every stops after the call of fn running, if any, has returned.
+func (RealRuntime) Every(period time.Duration, fn func()) (stop func()) {
+	quit, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(quit)
+			<-exited
+		})
+	}
+}
+
+// Serial drops the work handed to it after stop.
+func (RealRuntime) Serial() (run func(fn func()), stop func()) {
+	work, quit, exited := make(chan func()), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case fn := <-work:
+				fn()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	run = func(fn func()) {
+		select {
+		case work <- fn:
+		case <-quit:
+		}
+	}
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(quit)
+			<-exited
+		})
+	}
+	return run, stop
+}
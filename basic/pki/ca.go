@@ -0,0 +1,188 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+)
+
+// CenterName is the common name of the center, the only peer allowed to operate servers.
+const CenterName = "center"
+
+// Validity is how long issued certificates last, a lab deployment is short-lived.
+var Validity = 365 * 24 * time.Hour
+
+// Files of a CA directory, and of the identity directory issued for each server.
+const (
+	CAFile   = "ca.pem"
+	CAKey    = "ca-key.pem"
+	CertFile = "cert.pem"
+	KeyFile  = "key.pem"
+)
+
+var ErrNoPEM = errors.New("no PEM block found")
+
+// CA signs the certificates of servers and the center of one deployment.
+type CA struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+}
+
+func NewCA(name string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(Validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert, key}, nil
+}
+
+// LoadCA reads the CA saved in dir.
+func LoadCA(dir string) (*CA, error) {
+	certPEM, err := os.ReadFile(path.Join(dir, CAFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(path.Join(dir, CAKey))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert, key}, nil
+}
+
+// Save writes the certificate and the key of the CA into dir, the key is readable by the owner only.
+func (ca *CA) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	keyPEM, err := encodeKey(ca.Key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dir, CAFile), ca.PEM(), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, CAKey), keyPEM, 0600)
+}
+
+func (ca *CA) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})
+}
+
+// Issue signs a certificate for name, valid both to serve and to dial.
+// hosts are the IPs and DNS names the holder is reached at.
+func (ca *CA) Issue(name string, hosts []string) (certPEM []byte, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// WriteIdentity issues a certificate for name and writes it into dir with its key and the CA,
+// dir is what Config loads on the holder.
+func (ca *CA) WriteIdentity(dir string, name string, hosts []string) error {
+	certPEM, keyPEM, err := ca.Issue(name, hosts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dir, CAFile), ca.PEM(), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dir, CertFile), certPEM, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(dir, KeyFile), keyPEM, 0600)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, ErrNoPEM
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,44 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+)
+
+var ErrBadCA = errors.New("no certificate in CA file")
+
+// Config loads the identity written by WriteIdentity into dir.
+// Both sides of a connection present a certificate signed by the CA, peers without one are rejected.
+func Config(dir string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(path.Join(dir, CertFile), path.Join(dir, KeyFile))
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(path.Join(dir, CAFile))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, ErrBadCA
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// PeerName is the common name in the verified certificate of the peer of request, empty over plain HTTP.
+func PeerName(request *http.Request) string {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return request.TLS.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,16 @@
+package proofbased
+
+const (
+	InitialSubsidy  uint64 = 50_0000_0000
+	HalvingInterval int64  = 210000
+)
+
+// Subsidy is the new coins the proposer of the block at height may claim in its coinbase,
+// halved every HalvingInterval blocks.
+func Subsidy(height int64) uint64 {
+	halvings := height / HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+	return InitialSubsidy >> uint(halvings)
+}
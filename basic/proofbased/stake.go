@@ -0,0 +1,42 @@
+package proofbased
+
+import "time"
+
+// StakeTable records the stake held by every account.
+type StakeTable struct {
+	stakes map[string]int64
+}
+
+func NewStakeTable() *StakeTable {
+	return &StakeTable{
+		stakes: make(map[string]int64),
+	}
+}
+
+func (table *StakeTable) Set(id string, amount int64) {
+	if amount <= 0 {
+		delete(table.stakes, id)
+		return
+	}
+	table.stakes[id] = amount
+}
+
+func (table *StakeTable) Get(id string) int64 {
+	return table.stakes[id]
+}
+
+func (table *StakeTable) Total() int64 {
+	var total int64
+	for _, amount := range table.stakes {
+		total += amount
+	}
+	return total
+}
+
+// SlotAt returns the index of the slot containing now, -1 if the chain has not started.
+func SlotAt(genesis time.Time, now time.Time, duration time.Duration) int64 {
+	if now.Before(genesis) {
+		return -1
+	}
+	return int64(now.Sub(genesis) / duration)
+}
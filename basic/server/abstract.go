@@ -12,5 +12,5 @@ type Operator interface {
 }
 
 type Factory interface {
-	NewOperator(id string, sender Sender) Operator
+	NewOperator(id string, sender Sender) (Operator, error)
 }
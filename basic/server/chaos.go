@@ -0,0 +1,258 @@
+package server
+
+import (
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDropped     = errors.New("message is dropped by a fault")
+	ErrPartitioned = errors.New("message crosses a partition")
+)
+
+// ReorderHold is how long a reordered message is held back, letting later ones overtake it.
+const ReorderHold = time.Millisecond * 100
+
+// Clock is the time faults are injected on, a node.Runtime or a simulator fits it.
+type Clock interface {
+	Now() time.Time
+	// Schedule runs fn once delay has passed, without blocking the caller.
+	Schedule(delay time.Duration, fn func())
+}
+
+// RealClock is the wall clock, with timers.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) Schedule(delay time.Duration, fn func()) {
+	time.AfterFunc(delay, fn)
+}
+
+// Fault hits the messages of Operation on the link From->To, an empty field matches all.
+type Fault struct {
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Operation string        `json:"operation"`
+	Delay     time.Duration `json:"delay"`
+	// extra random delay up to Jitter
+	Jitter time.Duration `json:"jitter"`
+	// probabilities for every message
+	Drop      float64 `json:"drop"`
+	Duplicate float64 `json:"duplicate"`
+	Reorder   float64 `json:"reorder"`
+}
+
+func (fault *Fault) match(from string, to string, operation string) bool {
+	return (fault.From == "" || fault.From == from) &&
+		(fault.To == "" || fault.To == to) &&
+		(fault.Operation == "" || fault.Operation == operation)
+}
+
+// Partition cuts Nodes off from the rest of the network, from After to After+Duration since it is imposed.
+type Partition struct {
+	Nodes    []string      `json:"nodes"`
+	After    time.Duration `json:"after"`
+	Duration time.Duration `json:"duration"`
+	start    time.Time
+}
+
+func (partition *Partition) cuts(from string, to string, now time.Time) bool {
+	if now.Before(partition.start) || !now.Before(partition.start.Add(partition.Duration)) {
+		return false
+	}
+	inside := func(id string) bool {
+		for _, node := range partition.Nodes {
+			if node == id {
+				return true
+			}
+		}
+		return false
+	}
+	return inside(from) != inside(to)
+}
+
+// Chaos wraps a Sender and injects faults and partitions into the messages sent through it.
+// Without faults it passes every message on.
+type Chaos struct {
+	// the wrapped Sender, see UseSender
+	sender     Sender
+	faults     []Fault
+	partitions []*Partition
+	clock      Clock
+	rand       *rand.Rand
+	mutex      sync.Mutex
+}
+
+// NewChaos injects faults drawn from seed, timed by clock, so the same seed and clock fault the same messages.
+func NewChaos(sender Sender, seed int64, clock Clock) *Chaos {
+	return &Chaos{
+		sender:     sender,
+		faults:     make([]Fault, 0),
+		partitions: make([]*Partition, 0),
+		clock:      clock,
+		rand:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (chaos *Chaos) AddFault(fault Fault) {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+	chaos.faults = append(chaos.faults, fault)
+}
+
+// Partition imposes partition on the schedule it carries.
+func (chaos *Chaos) Partition(partition Partition) {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+	partition.start = chaos.clock.Now().Add(partition.After)
+	chaos.partitions = append(chaos.partitions, &partition)
+}
+
+// Heal removes all faults and partitions.
+func (chaos *Chaos) Heal() {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+	chaos.faults = make([]Fault, 0)
+	chaos.partitions = make([]*Partition, 0)
+}
+
+// plan decides the fate of one message: the delays of its copies, none if it is lost.
+func (chaos *Chaos) plan(from string, to string, operation string) ([]time.Duration, error) {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+	now := chaos.clock.Now()
+	alive := make([]*Partition, 0, len(chaos.partitions))
+	cut := false
+	for _, partition := range chaos.partitions {
+		if !now.Before(partition.start.Add(partition.Duration)) {
+			continue
+		}
+		alive = append(alive, partition)
+		cut = cut || partition.cuts(from, to, now)
+	}
+	chaos.partitions = alive
+	if cut {
+		return nil, ErrPartitioned
+	}
+
+	delays := []time.Duration{0}
+	for i := range chaos.faults {
+		fault := &chaos.faults[i]
+		if !fault.match(from, to, operation) {
+			continue
+		}
+		if chaos.rand.Float64() < fault.Drop {
+			return nil, ErrDropped
+		}
+		delay := fault.Delay
+		if fault.Jitter > 0 {
+			delay += time.Duration(chaos.rand.Int63n(int64(fault.Jitter)))
+		}
+		if chaos.rand.Float64() < fault.Reorder {
+			delay += ReorderHold
+		}
+		for j := range delays {
+			delays[j] += delay
+		}
+		if chaos.rand.Float64() < fault.Duplicate {
+			delays = append(delays, delays[0])
+		}
+	}
+	return delays, nil
+}
+
+// Send passes the message on at once if it is not delayed, the caller gets the answer.
+// A delayed message is sent later on the clock, the caller gets 202 Accepted at once and no answer.
+func (chaos *Chaos) Send(from string, to string, operation string, message interface{}) (resp *http.Response, err error) {
+	delays, err := chaos.plan(from, to, operation)
+	if err != nil {
+		return nil, err
+	}
+	// Duplicates arrive on their own.
+	for _, delay := range delays[1:] {
+		chaos.later(delay, from, to, operation, message)
+	}
+	if delays[0] == 0 {
+		return chaos.Sender().Send(from, to, operation, message)
+	}
+	chaos.later(delays[0], from, to, operation, message)
+	return &http.Response{
+		Status:     "202 Accepted",
+		StatusCode: http.StatusAccepted,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+	}, nil
+}
+
+func (chaos *Chaos) later(delay time.Duration, from string, to string, operation string, message interface{}) {
+	chaos.clock.Schedule(delay, func() {
+		if resp, err := chaos.Sender().Send(from, to, operation, message); err == nil {
+			resp.Body.Close()
+		}
+	})
+}
+
+// Broadcast sends to every peer through the faults, when the wrapped Sender tells its peers.
+func (chaos *Chaos) Broadcast(from string, operation string, message interface{}) (resps []*http.Response, errs []error) {
+	ids := chaos.IDs()
+	if ids == nil {
+		return chaos.Sender().Broadcast(from, operation, message)
+	}
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, len(ids))
+	count := 0
+	for _, id := range ids {
+		if id == from {
+			continue
+		}
+		count++
+		go func(id string) {
+			resp, err := chaos.Send(from, id, operation, message)
+			results <- result{resp, err}
+		}(id)
+	}
+	resps, errs = make([]*http.Response, 0, count), make([]error, 0, count)
+	for i := 0; i < count; i++ {
+		r := <-results
+		resps = append(resps, r.resp)
+		errs = append(errs, r.err)
+	}
+	return resps, errs
+}
+
+// IDs of the peers, when the wrapped Sender tells them.
+func (chaos *Chaos) IDs() []string {
+	if router, ok := chaos.Sender().(interface{ IDs() []string }); ok {
+		return router.IDs()
+	}
+	return nil
+}
+
+// Codec is the codec of the wrapped Sender.
+func (chaos *Chaos) Codec() codec.Codec {
+	return codec.Of(chaos.Sender())
+}
+
+// Sender is the wrapped Sender messages are passed on to.
+func (chaos *Chaos) Sender() Sender {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+	return chaos.sender
+}
+
+// UseSender passes the messages on to sender from now on, also while others are sent.
+func (chaos *Chaos) UseSender(sender Sender) {
+	chaos.mutex.Lock()
+	defer chaos.mutex.Unlock()
+	chaos.sender = sender
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+)
+
+// manualClock runs what is scheduled on it only when it is advanced.
+type manualClock struct {
+	now    time.Time
+	events []scheduled
+}
+
+type scheduled struct {
+	at time.Time
+	fn func()
+}
+
+func (clock *manualClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *manualClock) Schedule(delay time.Duration, fn func()) {
+	clock.events = append(clock.events, scheduled{clock.now.Add(delay), fn})
+}
+
+func (clock *manualClock) Advance(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+	sort.SliceStable(clock.events, func(i, j int) bool { return clock.events[i].at.Before(clock.events[j].at) })
+	for len(clock.events) > 0 && !clock.events[0].at.After(clock.now) {
+		event := clock.events[0]
+		clock.events = clock.events[1:]
+		event.fn()
+	}
+}
+
+// sentLog is a Sender keeping the operations sent through it.
+type sentLog struct {
+	sent []string
+}
+
+func (sink *sentLog) Send(_ string, _ string, operation string, _ interface{}) (*http.Response, error) {
+	sink.sent = append(sink.sent, operation)
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func (sink *sentLog) Broadcast(_ string, _ string, _ interface{}) ([]*http.Response, []error) {
+	return nil, nil
+}
+
+func TestChaosDelayOnClock(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	sent := &sentLog{}
+	chaos := NewChaos(sent, 1, clock)
+	chaos.AddFault(Fault{Operation: "prepare", Delay: time.Second})
+
+	resp, err := chaos.Send("node-1", "node-2", "prepare", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted || len(sent.sent) != 0 {
+		t.Fatalf("delayed message answered %d with %v sent", resp.StatusCode, sent.sent)
+	}
+	if _, err = chaos.Send("node-1", "node-2", "commit", nil); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second - 1)
+	if len(sent.sent) != 1 || sent.sent[0] != "commit" {
+		t.Fatalf("sent %v before the delay is over", sent.sent)
+	}
+	clock.Advance(1)
+	if len(sent.sent) != 2 || sent.sent[1] != "prepare" {
+		t.Fatalf("sent %v once the delay is over", sent.sent)
+	}
+}
+
+func TestChaosPartitionOnClock(t *testing.T) {
+	clock := &manualClock{now: time.Unix(0, 0)}
+	chaos := NewChaos(&sentLog{}, 1, clock)
+	chaos.Partition(Partition{Nodes: []string{"node-1"}, After: time.Second, Duration: time.Second})
+	for _, step := range []struct {
+		advance time.Duration
+		cut     bool
+	}{{0, false}, {time.Second, true}, {time.Second, false}} {
+		clock.Advance(step.advance)
+		_, err := chaos.Send("node-1", "node-2", "prepare", nil)
+		if cut := err == ErrPartitioned; cut != step.cut {
+			t.Fatalf("at %v the partition cuts: %v, want %v", clock.Now().Sub(time.Unix(0, 0)), cut, step.cut)
+		}
+	}
+}
+
+func TestChaosSeed(t *testing.T) {
+	drops := func(seed int64) []bool {
+		chaos := NewChaos(&sentLog{}, seed, &manualClock{})
+		chaos.AddFault(Fault{Drop: 0.5})
+		dropped := make([]bool, 64)
+		for i := range dropped {
+			_, err := chaos.Send("node-1", "node-2", "prepare", nil)
+			dropped[i] = err == ErrDropped
+		}
+		return dropped
+	}
+	first, second := drops(7), drops(7)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("message %d is dropped: %v, then %v with the same seed", i, first[i], second[i])
+		}
+	}
+}
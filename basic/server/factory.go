@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	factories   = make(map[string]Factory)
+	factoriesMu sync.RWMutex
+)
+
+// RegisterFactory makes factory selectable by name, e.g. by the algo of the new operation.
+// Implementations register themselves in init, names must be unique.
+func RegisterFactory(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("server: factory %s is already registered", name))
+	}
+	factories[name] = factory
+}
+
+// FactoryOf finds the factory registered as name.
+func FactoryOf(name string) (Factory, error) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm %s, registered: %v", name, factoryNames())
+	}
+	return factory, nil
+}
+
+// Factories are the names of the registered factories, in order.
+func Factories() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	return factoryNames()
+}
+
+func factoryNames() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
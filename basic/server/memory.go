@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// Memory delivers messages to the operators of the same process by calling DoOperation directly,
+// so a whole cluster runs without ports.
+type Memory struct {
+	// id to operator, contains all operators in the network
+	OperatorTable *Operators
+	// inject to it when use
+	Factory
+	// codec the operators send with
+	*codec.Preference
+}
+
+func NewMemory(factory Factory) *Memory {
+	return &Memory{
+		OperatorTable: NewOperators(),
+		Factory:       factory,
+		Preference:    codec.NewPreference(codec.Default),
+	}
+}
+
+// Add creates an operator by the factory, sending through memory.
+func (memory *Memory) Add(id string) (Operator, error) {
+	operator, err := memory.NewOperator(id, memory)
+	if err != nil {
+		return nil, err
+	}
+	memory.Register(id, operator)
+	return operator, nil
+}
+
+// Register starts operator as id, stopping the operator it replaces.
+func (memory *Memory) Register(id string, operator Operator) {
+	if old, ok := memory.OperatorTable.Put(id, operator); ok {
+		StopOperator(old)
+	}
+	StartOperator(operator)
+}
+
+// Remove stops the operator of id.
+func (memory *Memory) Remove(id string) {
+	if operator, ok := memory.OperatorTable.Delete(id); ok {
+		StopOperator(operator)
+	}
+}
+
+func (memory *Memory) IDs() []string {
+	return memory.OperatorTable.IDs()
+}
+
+func (memory *Memory) Send(from string, to string, operation string, message interface{}) (resp *http.Response, err error) {
+	operator, ok := memory.OperatorTable.Get(to)
+	if !ok {
+		return nil, fmt.Errorf("unknown operator %s", to)
+	}
+
+	query := url.Values{}
+	query.Add("from", from)
+	query.Add("to", to)
+	query.Add("operation", operation)
+	for {
+		c, data, err := memory.Encode(to, operation, message)
+		if err != nil {
+			return nil, err
+		}
+		request, err := http.NewRequest(http.MethodPost, "memory://"+to+"/node?"+query.Encode(), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", c.ContentType())
+
+		writer := newRecorder()
+		operator.DoOperation(operation, writer, request)
+		resp = writer.response(request)
+		if !codec.Refused(to, resp) {
+			return resp, nil
+		}
+	}
+}
+
+func (memory *Memory) Broadcast(from string, operation string, message interface{}) (resps []*http.Response, errs []error) {
+	ids := memory.IDs()
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, len(ids))
+	count := 0
+	for _, id := range ids {
+		if id == from {
+			continue
+		}
+		count++
+		go func(id string) {
+			resp, err := memory.Send(from, id, operation, message)
+			results <- result{resp, err}
+		}(id)
+	}
+	resps, errs = make([]*http.Response, 0, count), make([]error, 0, count)
+	for i := 0; i < count; i++ {
+		r := <-results
+		resps = append(resps, r.resp)
+		errs = append(errs, r.err)
+	}
+	log.Println(from, "operation broadcast finished!")
+	return resps, errs
+}
+
+// recorder collects what an operator writes as the response of an in-memory request.
+type recorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header), code: http.StatusOK}
+}
+
+func (recorder *recorder) Header() http.Header {
+	return recorder.header
+}
+
+func (recorder *recorder) Write(data []byte) (int, error) {
+	return recorder.body.Write(data)
+}
+
+func (recorder *recorder) WriteHeader(code int) {
+	recorder.code = code
+}
+
+func (recorder *recorder) response(request *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorder.code, http.StatusText(recorder.code)),
+		StatusCode:    recorder.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorder.header,
+		Body:          io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+		ContentLength: int64(recorder.body.Len()),
+		Request:       request,
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// echo answers every operation with the id it was sent from.
+type echo struct {
+	lifecycleOperator
+}
+
+func (operator *echo) DoOperation(_ string, writer http.ResponseWriter, request *http.Request) {
+	writer.Write([]byte(request.URL.Query().Get("from")))
+}
+
+type echoes map[string]*echo
+
+func (made echoes) NewOperator(id string, _ Sender) (Operator, error) {
+	made[id] = &echo{}
+	return made[id], nil
+}
+
+func TestMemoryCluster(t *testing.T) {
+	made := make(echoes)
+	memory := NewMemory(made)
+	for i := 1; i <= 100; i++ {
+		if _, err := memory.Add("node-" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resps, errs := memory.Broadcast("node-1", "ping", struct{}{})
+	if len(resps) != 99 {
+		t.Fatalf("broadcast reached %d of 99 peers", len(resps))
+	}
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "node-1" {
+			t.Fatalf("peer answered %d %q", resp.StatusCode, body)
+		}
+	}
+	if _, err := memory.Send("node-1", "node-101", "ping", struct{}{}); err == nil {
+		t.Fatal("send to an unknown operator did not fail")
+	}
+
+	memory.Remove("node-50")
+	if operator := made["node-50"]; operator.started != 1 || operator.stopped != 1 {
+		t.Fatalf("removed operator started %d and stopped %d times", operator.started, operator.stopped)
+	}
+	if ids := memory.IDs(); len(ids) != 99 {
+		t.Fatalf("%d operators are left of 100", len(ids))
+	}
+}
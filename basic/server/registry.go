@@ -0,0 +1,114 @@
+package server
+
+import (
+	"sort"
+	"sync"
+)
+
+// Lifecycle is implemented by operators with background work. The owner of an operator starts it
+// once it is registered and stops it before dropping it, Stop returns once the work has ended.
+type Lifecycle interface {
+	Start()
+	Stop()
+}
+
+// StartOperator starts operator if it has a lifecycle.
+func StartOperator(operator Operator) {
+	if lifecycle, ok := operator.(Lifecycle); ok {
+		lifecycle.Start()
+	}
+}
+
+// StopOperator stops operator if it has a lifecycle.
+func StopOperator(operator Operator) {
+	if lifecycle, ok := operator.(Lifecycle); ok {
+		lifecycle.Stop()
+	}
+}
+
+// Operators holds operators by id, safe for concurrent use.
+type Operators struct {
+	table map[string]Operator
+	mutex sync.RWMutex
+}
+
+func NewOperators() *Operators {
+	return &Operators{table: make(map[string]Operator)}
+}
+
+func (operators *Operators) Get(id string) (Operator, bool) {
+	operators.mutex.RLock()
+	defer operators.mutex.RUnlock()
+	operator, ok := operators.table[id]
+	return operator, ok
+}
+
+// Put registers operator as id, and returns the operator it replaces.
+func (operators *Operators) Put(id string, operator Operator) (Operator, bool) {
+	operators.mutex.Lock()
+	defer operators.mutex.Unlock()
+	old, ok := operators.table[id]
+	operators.table[id] = operator
+	return old, ok
+}
+
+func (operators *Operators) Delete(id string) (Operator, bool) {
+	operators.mutex.Lock()
+	defer operators.mutex.Unlock()
+	operator, ok := operators.table[id]
+	delete(operators.table, id)
+	return operator, ok
+}
+
+// IDs in order.
+func (operators *Operators) IDs() []string {
+	operators.mutex.RLock()
+	defer operators.mutex.RUnlock()
+	ids := make([]string, 0, len(operators.table))
+	for id := range operators.table {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Routes holds the address of each operator by id, safe for concurrent use.
+type Routes struct {
+	table map[string]string
+	mutex sync.RWMutex
+}
+
+func NewRoutes() *Routes {
+	return &Routes{table: make(map[string]string)}
+}
+
+func (routes *Routes) Get(id string) (string, bool) {
+	routes.mutex.RLock()
+	defer routes.mutex.RUnlock()
+	addr, ok := routes.table[id]
+	return addr, ok
+}
+
+func (routes *Routes) Put(id string, addr string) {
+	routes.mutex.Lock()
+	defer routes.mutex.Unlock()
+	routes.table[id] = addr
+}
+
+func (routes *Routes) Delete(id string) {
+	routes.mutex.Lock()
+	defer routes.mutex.Unlock()
+	delete(routes.table, id)
+}
+
+// IDs in order.
+func (routes *Routes) IDs() []string {
+	routes.mutex.RLock()
+	defer routes.mutex.RUnlock()
+	ids := make([]string, 0, len(routes.table))
+	for id := range routes.table {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
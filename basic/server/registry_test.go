@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type lifecycleOperator struct {
+	started, stopped int
+}
+
+func (operator *lifecycleOperator) DoOperation(_ string, writer http.ResponseWriter, _ *http.Request) {
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (operator *lifecycleOperator) Start() {
+	operator.started++
+}
+
+func (operator *lifecycleOperator) Stop() {
+	operator.stopped++
+}
+
+func TestOperatorsPutDelete(t *testing.T) {
+	operators := NewOperators()
+	first, second := &lifecycleOperator{}, &lifecycleOperator{}
+	if _, ok := operators.Put("node-2", first); ok {
+		t.Fatal("put into an empty registry replaced an operator")
+	}
+	if old, ok := operators.Put("node-2", second); !ok || old != first {
+		t.Fatalf("put replaced %v, %v, want the first operator", old, ok)
+	}
+	operators.Put("node-1", first)
+	if ids := operators.IDs(); !reflect.DeepEqual(ids, []string{"node-1", "node-2"}) {
+		t.Fatalf("ids are %v", ids)
+	}
+	if operator, ok := operators.Delete("node-2"); !ok || operator != second {
+		t.Fatalf("delete dropped %v, %v, want the second operator", operator, ok)
+	}
+	if _, ok := operators.Get("node-2"); ok {
+		t.Fatal("deleted operator is still registered")
+	}
+	if _, ok := operators.Delete("node-2"); ok {
+		t.Fatal("deleted operator is deleted twice")
+	}
+}
+
+func TestRegistriesConcurrent(t *testing.T) {
+	operators, routes := NewOperators(), NewRoutes()
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			id := "node-" + strconv.Itoa(i)
+			for j := 0; j < 100; j++ {
+				operators.Put(id, &lifecycleOperator{})
+				routes.Put(id, "localhost:1000")
+				operators.Get(id)
+				routes.Get(id)
+				operators.IDs()
+				routes.IDs()
+				operators.Delete(id)
+				routes.Delete(id)
+			}
+		}(i)
+	}
+	wait.Wait()
+	if len(operators.IDs()) != 0 || len(routes.IDs()) != 0 {
+		t.Fatalf("registries keep %v and %v", operators.IDs(), routes.IDs())
+	}
+}
+
+func TestMemoryLifecycle(t *testing.T) {
+	memory := NewMemory(nil)
+	first, second := &lifecycleOperator{}, &lifecycleOperator{}
+	memory.Register("node-1", first)
+	if first.started != 1 || first.stopped != 0 {
+		t.Fatalf("registered operator started %d and stopped %d times", first.started, first.stopped)
+	}
+	memory.Register("node-1", second)
+	if first.stopped != 1 || second.started != 1 {
+		t.Fatalf("replaced operator stopped %d times, new one started %d times", first.stopped, second.started)
+	}
+	memory.Remove("node-1")
+	memory.Remove("node-1")
+	if second.stopped != 1 {
+		t.Fatalf("removed operator stopped %d times", second.stopped)
+	}
+}
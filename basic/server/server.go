@@ -2,53 +2,219 @@ package server
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/pki"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type Server struct {
 	// id to operator, contains all operators managed by this Server
-	OperatorTable map[string]Operator
+	OperatorTable *Operators
 	// id to url, contains all operators in the network
-	RouteTable map[string]string
+	RouteTable *Routes
 	// inject to it when use
 	Factory
-	status chan int
+	// operators send through it, faults are injected by the server operations
+	Chaos *Chaos
+	// persistent transport for operators, nil when they send over HTTP, see TCP
+	tcp *TCP
+	// mutual TLS for all traffic of the server, nil for plain HTTP
+	TLS    *tls.Config
+	Config Config
+	// codec the operators send with, shared with the TCP transport
+	*codec.Preference
+	client   *http.Client
+	mux      *http.ServeMux
+	http     *http.Server
+	listener net.Listener
+	status   chan int
+	// server address to the address of its TCP transport, the TCP routes follow the routes by it
+	transports *Routes
+	// guards tcp, which UseTCP replaces while operators send
+	mutex sync.RWMutex
+}
+
+type Config struct {
+	// address to listen on, e.g. :1000, a free port with :0
+	Listen string `json:"listen"`
+	// address the center and other servers reach this one at, e.g. 106.3.97.70:1000,
+	// the listen address on this machine when empty
+	Advertise string `json:"advertise"`
+	// directory of the identity for mutual TLS written by pki.WriteIdentity, plain HTTP when empty
+	TLS string `json:"tls"`
+	// name of the registered factory making the operators created without an algorithm
+	Algo string `json:"algo"`
+	// used instead of Algo when set
+	Factory Factory `json:"-"`
+	// seed of the faults injected into the messages of the operators, the start time when 0
+	Seed int64 `json:"seed"`
+	// name of the codec the operators of this server send with, peers refusing it get JSON,
+	// codec.Default when empty
+	Codec string `json:"codec"`
+}
+
+var DefaultConfig = Config{
+	Listen: ":1000",
+}
+
+// TLSEnv names the directory holding the identity of this process, see pki.WriteIdentity.
+// EnvConfig turns on mutual TLS when it is set.
+const TLSEnv = "BCCP_TLS"
+
+// EnvConfig is DefaultConfig with the TLS directory of TLSEnv.
+func EnvConfig() Config {
+	config := DefaultConfig
+	config.TLS = os.Getenv(TLSEnv)
+	return config
 }
 
 func NewServer() *Server {
-	return &Server{
-		make(map[string]Operator),
-		make(map[string]string),
-		nil,
-		make(chan int),
+	server := &Server{
+		OperatorTable: NewOperators(),
+		RouteTable:    NewRoutes(),
+		Config:        DefaultConfig,
+		Preference:    codec.NewPreference(codec.Default),
+		client:        http.DefaultClient,
+		mux:           http.NewServeMux(),
+		status:        make(chan int, 1),
+		transports:    NewRoutes(),
+	}
+	server.Chaos = NewChaos(server, time.Now().UnixNano(), RealClock{})
+	return server
+}
+
+// New makes a server from config, it serves once started.
+func New(config Config) (*Server, error) {
+	server := NewServer()
+	if err := server.Configure(config); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// DefaultServer is the server of the package functions, it is started by Start.
+var DefaultServer = NewServer()
+
+var ErrNoFactory = errors.New("no algorithm given and the server has no factory")
+
+// Configure applies config, call it before Start.
+func (server *Server) Configure(config Config) error {
+	if config.TLS != "" {
+		tlsConfig, err := pki.Config(config.TLS)
+		if err != nil {
+			return err
+		}
+		server.UseTLS(tlsConfig)
+	}
+	if config.Factory != nil {
+		server.Factory = config.Factory
+	} else if config.Algo != "" {
+		factory, err := FactoryOf(config.Algo)
+		if err != nil {
+			return err
+		}
+		server.Factory = factory
+	}
+	if config.Seed != 0 {
+		server.Chaos = NewChaos(server, config.Seed, RealClock{})
+	}
+	if config.Codec != "" {
+		c, ok := codec.ByName(config.Codec)
+		if !ok {
+			return fmt.Errorf("%w: %s", codec.ErrUnsupported, config.Codec)
+		}
+		server.UseCodec(c)
 	}
+	server.Config = config
+	return nil
 }
 
-var DefaultServer *Server
+// UseTLS makes the server accept and dial only peers holding a certificate of the CA in config,
+// call it before Start.
+func (server *Server) UseTLS(config *tls.Config) {
+	server.TLS = config
+	server.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: config},
+	}
+}
 
-// TODO: set port with args and flag.
-// e.g. port=1000
-func init() {
-	DefaultServer = NewServer()
-	DefaultServer.Start(":1000")
+func (server *Server) Scheme() string {
+	if server.TLS != nil {
+		return "https"
+	}
+	return "http"
 }
 
-func (server *Server) Start(addr string) {
-	log.Println("Server start")
+// Client sends requests to other servers, with the certificate of this server under TLS.
+func (server *Server) Client() *http.Client {
+	return server.client
+}
+
+// Start listens on the listen address of the config and serves in the background.
+func (server *Server) Start() error {
+	listener, err := net.Listen("tcp", server.Config.Listen)
+	if err != nil {
+		return err
+	}
+	if server.TLS != nil {
+		listener = tls.NewListener(listener, server.TLS)
+	}
+	log.Println("Server start on", listener.Addr())
 
-	mux := http.NewServeMux()
+	mux := server.mux
 	mux.HandleFunc("/server", server.HandleServer)
 	mux.HandleFunc("/node", server.HandleNode)
-
+	server.listener = listener
+	server.http = &http.Server{Handler: mux}
 	go func() {
-		err := http.ListenAndServe(addr, mux)
-		if err != nil {
-			panic(err)
+		if err := server.http.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println(err)
 		}
 	}()
+	return nil
+}
+
+// Addr is the address the server is reached at: the advertised one, or the one it listens on.
+func (server *Server) Addr() string {
+	if server.Config.Advertise != "" || server.listener == nil {
+		return server.Config.Advertise
+	}
+	addr := server.listener.Addr().(*net.TCPAddr)
+	if addr.IP.IsUnspecified() {
+		return "localhost:" + strconv.Itoa(addr.Port)
+	}
+	return addr.String()
+}
+
+// Close stops serving and stops all operators of the server.
+func (server *Server) Close() error {
+	for _, id := range server.Operators() {
+		server.Delete(id)
+	}
+	if tcp := server.TCP(); tcp != nil {
+		tcp.Close()
+	}
+	if server.http == nil {
+		return nil
+	}
+	return server.http.Close()
+}
+
+// Handle serves pattern beside the server and node endpoints, also after Start.
+func (server *Server) Handle(pattern string, handler http.HandlerFunc) {
+	server.mux.HandleFunc(pattern, handler)
 }
 
 func (server *Server) Wait() {
@@ -58,31 +224,262 @@ func (server *Server) Wait() {
 	}
 }
 
-func (server *Server) HandleServer(_ http.ResponseWriter, request *http.Request) {
+func (server *Server) HandleServer(writer http.ResponseWriter, request *http.Request) {
 	query := request.URL.Query()
 	log.Println(query)
+	// Under TLS only the center operates servers, other peers may only talk to nodes.
+	if server.TLS != nil && pki.PeerName(request) != pki.CenterName {
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
 	operation := query.Get("operation")
 	id := query.Get("id")
 	msg := query.Get("msg")
 	switch operation {
 	case "new":
-		server.OperatorTable[id] = server.NewOperator(id, server)
+		// The factory registered as algo makes the operator, the factory of the server when it is empty.
+		if _, err := server.Create(id, query.Get("algo")); err != nil {
+			log.Println(err)
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte(err.Error()))
+		}
 	case "delete":
-		delete(server.OperatorTable, id)
+		server.Delete(id)
 	case "add":
-		server.RouteTable[id] = msg
+		// tcp is the address of the TCP transport of the server at msg.
+		if addr := query.Get("tcp"); addr != "" {
+			server.SetTransport(msg, addr)
+		}
+		server.Route(id, msg)
+	case "routes":
+		// The center replaces the route table, msg maps ids to server addresses.
+		var table map[string]string
+		if err := json.Unmarshal([]byte(msg), &table); err != nil {
+			log.Println(err)
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		server.SetRoutes(table)
+	case "tcp":
+		// Operators send over TCP from now on, msg is the address to listen on.
+		if err := server.UseTCP(msg); err != nil {
+			log.Println(err)
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte(err.Error()))
+		}
+	case "fault":
+		var fault Fault
+		if err := json.Unmarshal([]byte(msg), &fault); err != nil {
+			log.Println(err)
+			return
+		}
+		server.Chaos.AddFault(fault)
+	case "partition":
+		var partition Partition
+		if err := json.Unmarshal([]byte(msg), &partition); err != nil {
+			log.Println(err)
+			return
+		}
+		server.Chaos.Partition(partition)
+	case "heal":
+		server.Chaos.Heal()
+	case "stats":
+		// Bytes on the wire per operation and codec, sent by the operators of this process.
+		json.NewEncoder(writer).Encode(codec.Stats())
+	case "status":
+		json.NewEncoder(writer).Encode(server.Status())
 	case "stop":
+		for _, id := range server.Operators() {
+			server.Delete(id)
+		}
+		// Answer before the process may exit on Wait.
+		writer.WriteHeader(http.StatusOK)
+		if flusher, ok := writer.(http.Flusher); ok {
+			flusher.Flush()
+		}
 		server.status <- 0
 	}
 }
 
-// HandleNode TODO: find handler by reflection
+// HandleNode hands a message to the operator it is sent to, the operator decodes it.
 func (server *Server) HandleNode(writer http.ResponseWriter, request *http.Request) {
 	query := request.URL.Query()
 	log.Println(query)
 	operation := query.Get("operation")
 	id := query.Get("to")
-	server.OperatorTable[id].DoOperation(operation, writer, request)
+	operator, ok := server.OperatorTable.Get(id)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	operator.DoOperation(operation, writer, request)
+}
+
+// Create makes an operator by the factory registered as algo and starts it, replacing the operator of the same id.
+// An empty algo uses the factory of the server.
+func (server *Server) Create(id string, algo string) (Operator, error) {
+	factory := server.Factory
+	if algo != "" {
+		var err error
+		if factory, err = FactoryOf(algo); err != nil {
+			return nil, err
+		}
+	}
+	if factory == nil {
+		return nil, ErrNoFactory
+	}
+	operator, err := factory.NewOperator(id, server.Chaos)
+	if err != nil {
+		return nil, err
+	}
+	if old, ok := server.OperatorTable.Put(id, operator); ok {
+		StopOperator(old)
+	}
+	if tcp := server.TCP(); tcp != nil {
+		tcp.Route(id, tcp.Addr())
+	}
+	StartOperator(operator)
+	return operator, nil
+}
+
+// UseTCP makes the operators send over a TCP transport listening on addr from now on.
+// The transport is routed like the server, and the transport it replaces is closed.
+func (server *Server) UseTCP(addr string) error {
+	tcp := NewTCP(server.Factory)
+	tcp.OperatorTable = server.OperatorTable
+	tcp.Preference = server.Preference
+	tcp.TLS = server.TLS
+	if err := tcp.Listen(addr); err != nil {
+		return err
+	}
+	server.transports.Put(server.Addr(), tcp.Addr())
+	server.mutex.Lock()
+	old := server.tcp
+	server.tcp = tcp
+	server.mutex.Unlock()
+	for _, id := range server.IDs() {
+		if addr, ok := server.Lookup(id); ok {
+			server.routeTCP(id, addr)
+		}
+	}
+	for _, id := range server.Operators() {
+		tcp.Route(id, tcp.Addr())
+	}
+	server.Chaos.UseSender(tcp)
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// TCP is the transport the operators send over, nil over HTTP.
+func (server *Server) TCP() *TCP {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	return server.tcp
+}
+
+// SetTransport records transport as the address of the TCP transport of the server at addr,
+// and routes the operators of that server to it.
+func (server *Server) SetTransport(addr string, transport string) {
+	server.transports.Put(addr, transport)
+	for _, id := range server.IDs() {
+		if current, ok := server.Lookup(id); ok && current == addr {
+			server.routeTCP(id, addr)
+		}
+	}
+}
+
+// routeTCP points id to the TCP transport of the server at addr,
+// the operators of this server to its own one. A server of unknown transport is unrouted.
+func (server *Server) routeTCP(id string, addr string) {
+	tcp := server.TCP()
+	if tcp == nil {
+		return
+	}
+	if _, ok := server.OperatorTable.Get(id); ok {
+		tcp.Route(id, tcp.Addr())
+	} else if transport, ok := server.transports.Get(addr); ok {
+		tcp.Route(id, transport)
+	} else {
+		tcp.Unroute(id)
+	}
+}
+
+// Delete stops the operator of id and drops it.
+func (server *Server) Delete(id string) {
+	if operator, ok := server.OperatorTable.Delete(id); ok {
+		StopOperator(operator)
+	}
+}
+
+// Route points id to the server at addr.
+func (server *Server) Route(id string, addr string) {
+	server.RouteTable.Put(id, addr)
+	server.routeTCP(id, addr)
+}
+
+func (server *Server) Unroute(id string) {
+	server.RouteTable.Delete(id)
+	if tcp := server.TCP(); tcp != nil {
+		tcp.Unroute(id)
+	}
+}
+
+// SetRoutes replaces the routes by table.
+func (server *Server) SetRoutes(table map[string]string) {
+	for _, id := range server.IDs() {
+		if _, ok := table[id]; !ok {
+			server.Unroute(id)
+		}
+	}
+	for id, addr := range table {
+		server.Route(id, addr)
+	}
+}
+
+func (server *Server) Lookup(id string) (string, bool) {
+	return server.RouteTable.Get(id)
+}
+
+func (server *Server) IDs() []string {
+	return server.RouteTable.IDs()
+}
+
+// Operators are the ids of the operators managed by this server, in order.
+func (server *Server) Operators() []string {
+	return server.OperatorTable.IDs()
+}
+
+// Status is the answer to the status operation.
+type Status struct {
+	Addr string `json:"addr"`
+	// the operators of the server
+	Nodes []string `json:"nodes"`
+	// id to the address of the server of every known operator
+	Routes map[string]string `json:"routes"`
+	// address of the TCP transport, empty over HTTP
+	TCP string `json:"tcp,omitempty"`
+	// names of the factories operators can be created by
+	Algorithms []string `json:"algorithms"`
+}
+
+func (server *Server) Status() Status {
+	status := Status{
+		Addr:       server.Addr(),
+		Nodes:      server.Operators(),
+		Routes:     make(map[string]string),
+		Algorithms: Factories(),
+	}
+	for _, id := range server.IDs() {
+		if addr, ok := server.Lookup(id); ok {
+			status.Routes[id] = addr
+		}
+	}
+	if tcp := server.TCP(); tcp != nil {
+		status.TCP = tcp.Addr()
+	}
+	return status
 }
 
 func (server *Server) Send(from string, to string, operation string, message interface{}) (resp *http.Response, err error) {
@@ -90,36 +487,67 @@ func (server *Server) Send(from string, to string, operation string, message int
 	query.Add("from", from)
 	query.Add("to", to)
 	query.Add("operation", operation)
-	queryUrl := "http://" + server.RouteTable[to] + "/node?" + query.Encode()
-	jsonMessage, _ := json.Marshal(message)
-	buff := bytes.NewBuffer(jsonMessage)
-	return http.Post(queryUrl, "application/json", buff)
+	addr, _ := server.Lookup(to)
+	queryUrl := server.Scheme() + "://" + addr + "/node?" + query.Encode()
+	for {
+		c, data, err := server.Encode(to, operation, message)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = server.client.Post(queryUrl, c.ContentType(), bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		// Read the body at once so the connection is reused, even if the caller drops the response.
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		// A peer refusing the encoding gets the message again as JSON.
+		if !codec.Refused(to, resp) {
+			return resp, nil
+		}
+	}
 }
 
 func (server *Server) Broadcast(from string, operation string, message interface{}) (resps []*http.Response, errs []error) {
-	total := len(server.RouteTable)
-	countChan := make(chan int)
-	resps, errs = make([]*http.Response, 0, total), make([]error, 0, total)
-	for id := range server.RouteTable {
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	ids := server.IDs()
+	results := make(chan result, len(ids))
+	count := 0
+	for _, id := range ids {
 		if id == from {
 			continue
 		}
+		count++
 		go func(id string) {
 			resp, err := server.Send(from, id, operation, message)
-			resps = append(resps, resp)
-			errs = append(errs, err)
-			countChan <- 1
+			results <- result{resp, err}
 		}(id)
 	}
-	for i := 0; i < total; i++ {
-		select {
-		case <-countChan:
-		}
+	resps, errs = make([]*http.Response, 0, count), make([]error, 0, count)
+	for i := 0; i < count; i++ {
+		r := <-results
+		resps = append(resps, r.resp)
+		errs = append(errs, r.err)
 	}
 	log.Println(from, "operation broadcast finished!")
 	return resps, errs
 }
 
+// Start configures DefaultServer by config and starts it.
+func Start(config Config) error {
+	if err := DefaultServer.Configure(config); err != nil {
+		return err
+	}
+	return DefaultServer.Start()
+}
+
 func SetFactory(factory Factory) {
 	DefaultServer.Factory = factory
 }
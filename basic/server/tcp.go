@@ -0,0 +1,551 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("send queue of the peer is full")
+	ErrConnLost  = errors.New("connection to the peer is lost")
+)
+
+const (
+	// frames waiting for one peer, Send blocks when the queue is full
+	QueueSize = 1024
+	// Send gives up when the queue stays full this long
+	QueueTimeout = time.Second * 5
+	// a response not back in time fails the Send
+	ResponseTimeout = time.Second * 30
+	MaxFrameBytes   = 64 << 20
+	MinBackoff      = time.Millisecond * 50
+	MaxBackoff      = time.Second * 5
+)
+
+const (
+	kindRequest byte = iota
+	kindResponse
+)
+
+// frameVersion is the layout of the frames written, a frame of another version breaks the connection.
+const frameVersion byte = 1
+
+// frame is one message on a connection: a request to an operator or the response to it.
+// On the wire: uint32 length of the rest, byte version, uint64 id, byte kind, uint16 status,
+// then from, to, operation and content type as uvarint length and bytes, then the body.
+type frame struct {
+	id          uint64
+	kind        byte
+	status      uint16
+	from        string
+	to          string
+	operation   string
+	contentType string
+	body        []byte
+}
+
+func writeFrame(w io.Writer, f *frame) error {
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(make([]byte, 4))
+	buf.WriteByte(frameVersion)
+	buf.Write(make([]byte, 8))
+	buf.WriteByte(f.kind)
+	binary.BigEndian.PutUint16(scratch[:], f.status)
+	buf.Write(scratch[:2])
+	for _, s := range []string{f.from, f.to, f.operation, f.contentType} {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(s)))])
+		buf.WriteString(s)
+	}
+	buf.Write(f.body)
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	binary.BigEndian.PutUint64(data[5:], f.id)
+	_, err := w.Write(data)
+	return err
+}
+
+func readFrame(r *bufio.Reader) (*frame, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(head[:])
+	if length > MaxFrameBytes || length < 1+8+1+2 {
+		return nil, fmt.Errorf("bad frame length %d", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] != frameVersion {
+		return nil, fmt.Errorf("frame version %d is not supported", buf[0])
+	}
+	buf = buf[1:]
+	f := &frame{
+		id:     binary.BigEndian.Uint64(buf),
+		kind:   buf[8],
+		status: binary.BigEndian.Uint16(buf[9:]),
+	}
+	rest := buf[11:]
+	fields := make([]string, 4)
+	for i := range fields {
+		n, size := binary.Uvarint(rest)
+		if size <= 0 || uint64(len(rest)-size) < n {
+			return nil, errors.New("bad frame field")
+		}
+		fields[i] = string(rest[size : size+int(n)])
+		rest = rest[size+int(n):]
+	}
+	f.from, f.to, f.operation, f.contentType = fields[0], fields[1], fields[2], fields[3]
+	f.body = rest
+	return f, nil
+}
+
+// TCP sends messages as frames over one long-lived connection per peer address,
+// with a bounded send queue and reconnection, instead of an HTTP request per message.
+type TCP struct {
+	// id to operator, contains all operators managed by this TCP
+	OperatorTable *Operators
+	// id to the address of the TCP listener holding it
+	RouteTable *Routes
+	// inject to it when use
+	Factory
+	// mutual TLS on every connection, nil for plain TCP
+	TLS *tls.Config
+	// codec the operators send with
+	*codec.Preference
+	listener net.Listener
+	// connections accepted from peers
+	conns map[net.Conn]bool
+	// address to the connection to it
+	peers  map[string]*peer
+	closed bool
+	mutex  sync.RWMutex
+}
+
+func NewTCP(factory Factory) *TCP {
+	return &TCP{
+		OperatorTable: NewOperators(),
+		RouteTable:    NewRoutes(),
+		Factory:       factory,
+		Preference:    codec.NewPreference(codec.Default),
+		conns:         make(map[net.Conn]bool),
+		peers:         make(map[string]*peer),
+	}
+}
+
+// Add creates an operator by the factory, sending through tcp.
+func (tcp *TCP) Add(id string) (Operator, error) {
+	operator, err := tcp.NewOperator(id, tcp)
+	if err != nil {
+		return nil, err
+	}
+	tcp.Register(id, operator)
+	return operator, nil
+}
+
+// Register starts operator as id, stopping the operator it replaces.
+func (tcp *TCP) Register(id string, operator Operator) {
+	if old, ok := tcp.OperatorTable.Put(id, operator); ok {
+		StopOperator(old)
+	}
+	StartOperator(operator)
+}
+
+// Remove stops the operator of id.
+func (tcp *TCP) Remove(id string) {
+	if operator, ok := tcp.OperatorTable.Delete(id); ok {
+		StopOperator(operator)
+	}
+}
+
+func (tcp *TCP) Route(id string, addr string) {
+	tcp.RouteTable.Put(id, addr)
+}
+
+func (tcp *TCP) Unroute(id string) {
+	tcp.RouteTable.Delete(id)
+}
+
+func (tcp *TCP) IDs() []string {
+	return tcp.RouteTable.IDs()
+}
+
+// Listen accepts connections of peers on addr, and returns once it listens.
+func (tcp *TCP) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if tcp.TLS != nil {
+		listener = tls.NewListener(listener, tcp.TLS)
+	}
+	tcp.mutex.Lock()
+	tcp.listener = listener
+	tcp.mutex.Unlock()
+	log.Println("TCP listen on", listener.Addr())
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			go tcp.serve(conn)
+		}
+	}()
+	return nil
+}
+
+func (tcp *TCP) Addr() string {
+	tcp.mutex.RLock()
+	defer tcp.mutex.RUnlock()
+	if tcp.listener == nil {
+		return ""
+	}
+	return tcp.listener.Addr().String()
+}
+
+// serve runs the requests arriving on conn, each in its own goroutine like an HTTP server.
+func (tcp *TCP) serve(conn net.Conn) {
+	tcp.mutex.Lock()
+	if tcp.closed {
+		tcp.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	tcp.conns[conn] = true
+	tcp.mutex.Unlock()
+	defer func() {
+		tcp.mutex.Lock()
+		delete(tcp.conns, conn)
+		tcp.mutex.Unlock()
+		conn.Close()
+	}()
+	// Operators check the certificate of the peer, like over HTTPS.
+	var state *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			log.Println(err)
+			return
+		}
+		connState := tlsConn.ConnectionState()
+		state = &connState
+	}
+	reader := bufio.NewReader(conn)
+	var writeMutex sync.Mutex
+	for {
+		request, err := readFrame(reader)
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			return
+		}
+		if request.kind != kindRequest {
+			continue
+		}
+		go func() {
+			response := tcp.handle(request, state)
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			if err := writeFrame(conn, response); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+func (tcp *TCP) handle(request *frame, state *tls.ConnectionState) *frame {
+	response := &frame{id: request.id, kind: kindResponse, status: http.StatusOK}
+	operator, ok := tcp.OperatorTable.Get(request.to)
+	if !ok {
+		response.status = http.StatusNotFound
+		return response
+	}
+
+	query := url.Values{}
+	query.Add("from", request.from)
+	query.Add("to", request.to)
+	query.Add("operation", request.operation)
+	httpRequest, err := http.NewRequest(http.MethodPost, "tcp://"+request.to+"/node?"+query.Encode(), bytes.NewReader(request.body))
+	if err != nil {
+		response.status = http.StatusBadRequest
+		return response
+	}
+	httpRequest.Header.Set("Content-Type", request.contentType)
+	httpRequest.TLS = state
+	writer := newRecorder()
+	operator.DoOperation(request.operation, writer, httpRequest)
+	response.status = uint16(writer.code)
+	response.body = writer.body.Bytes()
+	return response
+}
+
+func (tcp *TCP) peer(addr string) (*peer, error) {
+	tcp.mutex.Lock()
+	defer tcp.mutex.Unlock()
+	if tcp.closed {
+		return nil, net.ErrClosed
+	}
+	p, ok := tcp.peers[addr]
+	if !ok {
+		p = newPeer(addr, tcp.TLS)
+		tcp.peers[addr] = p
+		go p.run()
+	}
+	return p, nil
+}
+
+func (tcp *TCP) Send(from string, to string, operation string, message interface{}) (resp *http.Response, err error) {
+	addr, ok := tcp.RouteTable.Get(to)
+	if !ok {
+		return nil, fmt.Errorf("no route to %s", to)
+	}
+	p, err := tcp.peer(addr)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		c, data, err := tcp.Encode(to, operation, message)
+		if err != nil {
+			return nil, err
+		}
+		response, err := p.request(&frame{
+			kind:        kindRequest,
+			from:        from,
+			to:          to,
+			operation:   operation,
+			contentType: c.ContentType(),
+			body:        data,
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp = &http.Response{
+			Status:        fmt.Sprintf("%d %s", response.status, http.StatusText(int(response.status))),
+			StatusCode:    int(response.status),
+			Header:        make(http.Header),
+			Body:          io.NopCloser(bytes.NewReader(response.body)),
+			ContentLength: int64(len(response.body)),
+		}
+		if !codec.Refused(to, resp) {
+			return resp, nil
+		}
+	}
+}
+
+func (tcp *TCP) Broadcast(from string, operation string, message interface{}) (resps []*http.Response, errs []error) {
+	ids := tcp.IDs()
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, len(ids))
+	count := 0
+	for _, id := range ids {
+		if id == from {
+			continue
+		}
+		count++
+		go func(id string) {
+			resp, err := tcp.Send(from, id, operation, message)
+			results <- result{resp, err}
+		}(id)
+	}
+	resps, errs = make([]*http.Response, 0, count), make([]error, 0, count)
+	for i := 0; i < count; i++ {
+		r := <-results
+		resps = append(resps, r.resp)
+		errs = append(errs, r.err)
+	}
+	return resps, errs
+}
+
+// Close stops listening and drops the connections from and to all peers.
+func (tcp *TCP) Close() error {
+	tcp.mutex.Lock()
+	defer tcp.mutex.Unlock()
+	tcp.closed = true
+	for _, p := range tcp.peers {
+		p.close()
+	}
+	tcp.peers = make(map[string]*peer)
+	for conn := range tcp.conns {
+		conn.Close()
+	}
+	if tcp.listener != nil {
+		return tcp.listener.Close()
+	}
+	return nil
+}
+
+// peer is the connection to one address, frames are queued and written by one writer.
+type peer struct {
+	addr  string
+	tls   *tls.Config
+	queue chan *frame
+	// id to the caller waiting for the response
+	pending map[uint64]chan *frame
+	nextID  uint64
+	done    chan struct{}
+	mutex   sync.Mutex
+}
+
+func newPeer(addr string, config *tls.Config) *peer {
+	return &peer{
+		addr:    addr,
+		tls:     config,
+		queue:   make(chan *frame, QueueSize),
+		pending: make(map[uint64]chan *frame),
+		done:    make(chan struct{}),
+	}
+}
+
+// request queues f and waits for its response.
+func (p *peer) request(f *frame) (*frame, error) {
+	wait := make(chan *frame, 1)
+	p.mutex.Lock()
+	p.nextID++
+	f.id = p.nextID
+	p.pending[f.id] = wait
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.pending, f.id)
+		p.mutex.Unlock()
+	}()
+
+	// A full queue blocks the sender, so a slow peer slows down who talks to it.
+	select {
+	case p.queue <- f:
+	case <-p.done:
+		return nil, net.ErrClosed
+	case <-time.After(QueueTimeout):
+		return nil, ErrQueueFull
+	}
+
+	select {
+	case response, ok := <-wait:
+		if !ok {
+			return nil, ErrConnLost
+		}
+		return response, nil
+	case <-p.done:
+		return nil, net.ErrClosed
+	case <-time.After(ResponseTimeout):
+		return nil, fmt.Errorf("no response from %s", p.addr)
+	}
+}
+
+// run keeps a connection to the peer, dialing again with backoff when it breaks.
+func (p *peer) run() {
+	backoff := MinBackoff
+	for {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		conn, err := p.dial()
+		if err != nil {
+			log.Println(err)
+			select {
+			case <-time.After(backoff):
+			case <-p.done:
+				return
+			}
+			backoff *= 2
+			if backoff > MaxBackoff {
+				backoff = MaxBackoff
+			}
+			continue
+		}
+		backoff = MinBackoff
+		p.talk(conn)
+	}
+}
+
+func (p *peer) dial() (net.Conn, error) {
+	if p.tls == nil {
+		return net.DialTimeout("tcp", p.addr, MaxBackoff)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: MaxBackoff}, "tcp", p.addr, p.tls)
+}
+
+// talk writes queued frames to conn and reads the responses until the connection breaks.
+func (p *peer) talk(conn net.Conn) {
+	broken := make(chan struct{})
+	go func() {
+		defer close(broken)
+		reader := bufio.NewReader(conn)
+		for {
+			response, err := readFrame(reader)
+			if err != nil {
+				return
+			}
+			p.mutex.Lock()
+			wait, ok := p.pending[response.id]
+			delete(p.pending, response.id)
+			p.mutex.Unlock()
+			if ok {
+				wait <- response
+			}
+		}
+	}()
+
+	writer := bufio.NewWriter(conn)
+	for {
+		select {
+		case f := <-p.queue:
+			err := writeFrame(writer, f)
+			// Write out once the queue is drained, batching frames into few packets.
+			if err == nil && len(p.queue) == 0 {
+				err = writer.Flush()
+			}
+			if err != nil {
+				log.Println(err)
+				conn.Close()
+				<-broken
+				p.fail()
+				return
+			}
+		case <-broken:
+			conn.Close()
+			p.fail()
+			return
+		case <-p.done:
+			conn.Close()
+			<-broken
+			return
+		}
+	}
+}
+
+// fail gives up the requests waiting on a broken connection, their frames may be lost.
+func (p *peer) fail() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for id, wait := range p.pending {
+		close(wait)
+		delete(p.pending, id)
+	}
+}
+
+func (p *peer) close() {
+	close(p.done)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestUseTCPRoutes(t *testing.T) {
+	server := NewServer()
+	server.OperatorTable.Put("node-1", &lifecycleOperator{})
+	server.Route("node-1", "localhost:1000")
+	server.Route("node-2", "10.0.0.2:1000")
+	server.Route("node-3", "10.0.0.3:1000")
+	server.SetTransport("10.0.0.2:1000", "10.0.0.2:2000")
+	if err := server.UseTCP("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	first := server.TCP()
+	defer func() {
+		server.TCP().Close()
+	}()
+
+	if addr, ok := first.RouteTable.Get("node-1"); !ok || addr != first.Addr() {
+		t.Fatalf("own operator is routed to %q, %v, want %s", addr, ok, first.Addr())
+	}
+	if addr, _ := first.RouteTable.Get("node-2"); addr != "10.0.0.2:2000" {
+		t.Fatalf("node-2 is routed to %q", addr)
+	}
+	if _, ok := first.RouteTable.Get("node-3"); ok {
+		t.Fatal("node-3 is routed though its server has no known transport")
+	}
+
+	server.SetTransport("10.0.0.3:1000", "10.0.0.3:2000")
+	server.Route("node-4", "10.0.0.3:1000")
+	for _, id := range []string{"node-3", "node-4"} {
+		if addr, _ := first.RouteTable.Get(id); addr != "10.0.0.3:2000" {
+			t.Fatalf("%s is routed to %q", id, addr)
+		}
+	}
+	server.SetRoutes(map[string]string{"node-1": "localhost:1000", "node-3": "10.0.0.3:1000"})
+	if ids := first.IDs(); len(ids) != 2 || ids[0] != "node-1" || ids[1] != "node-3" {
+		t.Fatalf("tcp routes are %v after the routes are replaced", ids)
+	}
+
+	resp, err := server.TCP().Send("node-9", "node-1", "ping", struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("send over tcp answered %d", resp.StatusCode)
+	}
+
+	if err := server.UseTCP("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if !first.closed {
+		t.Fatal("replaced transport is not closed")
+	}
+	if addr, _ := server.TCP().RouteTable.Get("node-3"); addr != "10.0.0.3:2000" {
+		t.Fatalf("new transport routes node-3 to %q", addr)
+	}
+}
+
+// TestUseTCPWhileSending replaces the transport while operators send through the server, run it with -race.
+func TestUseTCPWhileSending(t *testing.T) {
+	server := NewServer()
+	server.OperatorTable.Put("node-1", &lifecycleOperator{})
+	server.Route("node-1", "localhost:1000")
+	if err := server.UseTCP("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			// The replaced transport may be closed under the message.
+			if resp, err := server.Chaos.Send("node-9", "node-1", "ping", struct{}{}); err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if err := server.UseTCP("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	resp, err := server.Chaos.Send("node-9", "node-1", "ping", struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("send over the last transport answered %d", resp.StatusCode)
+	}
+}
+
+func TestFrameVersion(t *testing.T) {
+	var buf bytes.Buffer
+	sent := &frame{id: 7, kind: kindRequest, status: http.StatusOK, from: "node-1", to: "node-2", operation: "prepare", contentType: "application/json", body: []byte("{}")}
+	if err := writeFrame(&buf, sent); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if data[4] != frameVersion {
+		t.Fatalf("frame is written as version %d", data[4])
+	}
+	got, err := readFrame(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, sent) {
+		t.Fatalf("read %+v, wrote %+v", got, sent)
+	}
+	data[4] = frameVersion + 1
+	if _, err = readFrame(bufio.NewReader(bytes.NewReader(data))); err == nil {
+		t.Fatal("frame of an unknown version is read")
+	}
+}
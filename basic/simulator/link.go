@@ -0,0 +1,65 @@
+package simulator
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Distribution gives the latency of one message.
+type Distribution interface {
+	Sample(r *rand.Rand) time.Duration
+}
+
+type Constant time.Duration
+
+func (constant Constant) Sample(_ *rand.Rand) time.Duration {
+	return time.Duration(constant)
+}
+
+type Uniform struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+func (uniform Uniform) Sample(r *rand.Rand) time.Duration {
+	if uniform.Max <= uniform.Min {
+		return uniform.Min
+	}
+	return uniform.Min + time.Duration(r.Int63n(int64(uniform.Max-uniform.Min)))
+}
+
+// Normal is cut at zero.
+type Normal struct {
+	Mean   time.Duration
+	StdDev time.Duration
+}
+
+func (normal Normal) Sample(r *rand.Rand) time.Duration {
+	latency := normal.Mean + time.Duration(r.NormFloat64()*float64(normal.StdDev))
+	if latency < 0 {
+		return 0
+	}
+	return latency
+}
+
+// Exponential has a long tail, as seen on congested paths.
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (exponential Exponential) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(r.ExpFloat64() * float64(exponential.Mean))
+}
+
+// Link is the path from one node to another.
+type Link struct {
+	Latency Distribution
+	// bytes per second, 0 for no cap
+	Bandwidth int64
+	// probability that a message is lost
+	Loss float64
+}
+
+var DefaultLink = Link{
+	Latency: Uniform{Min: time.Millisecond, Max: time.Millisecond * 5},
+}
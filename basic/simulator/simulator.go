@@ -0,0 +1,321 @@
+package simulator
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Epoch is where the virtual clock starts.
+var Epoch = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Record is one message in the trace of a run.
+type Record struct {
+	// virtual time since Epoch
+	At        time.Duration
+	From      string
+	To        string
+	Operation string
+	Size      int
+	Dropped   bool
+}
+
+// Simulator drives nodes on a virtual clock with a seeded queue of events.
+// It is the Sender and the node.Runtime of its nodes, so the same seed yields the same run,
+// as long as the nodes do their timing and background work through the runtime.
+type Simulator struct {
+	// id to operator, contains all operators in the network
+	OperatorTable *server.Operators
+	// inject to it when use
+	server.Factory
+	// codec the operators send with
+	*codec.Preference
+	DefaultLink Link
+	// "from->to" to the link between them
+	Links map[string]Link
+	Trace []Record
+
+	now   time.Time
+	queue eventQueue
+	seq   int64
+	rand  *rand.Rand
+	// "from->to" to the time the link finishes sending its queued messages
+	busy map[string]time.Time
+	// Send may come from goroutines of nodes not driven by the runtime
+	mutex sync.Mutex
+}
+
+func New(seed int64, factory server.Factory) *Simulator {
+	return &Simulator{
+		OperatorTable: server.NewOperators(),
+		Factory:       factory,
+		Preference:    codec.NewPreference(codec.Default),
+		DefaultLink:   DefaultLink,
+		Links:         make(map[string]Link),
+		Trace:         make([]Record, 0),
+		now:           Epoch,
+		queue:         make(eventQueue, 0),
+		rand:          rand.New(rand.NewSource(seed)),
+		busy:          make(map[string]time.Time),
+	}
+}
+
+// Add creates an operator by the factory, driven by the simulator.
+func (sim *Simulator) Add(id string) (server.Operator, error) {
+	operator, err := sim.NewOperator(id, sim)
+	if err != nil {
+		return nil, err
+	}
+	sim.Register(id, operator)
+	return operator, nil
+}
+
+func (sim *Simulator) Register(id string, operator server.Operator) {
+	if old, ok := sim.OperatorTable.Put(id, operator); ok {
+		server.StopOperator(old)
+	}
+	server.StartOperator(operator)
+}
+
+func (sim *Simulator) Remove(id string) {
+	if operator, ok := sim.OperatorTable.Delete(id); ok {
+		server.StopOperator(operator)
+	}
+}
+
+func linkKey(from string, to string) string {
+	return from + "->" + to
+}
+
+func (sim *Simulator) SetLink(from string, to string, link Link) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	sim.Links[linkKey(from, to)] = link
+}
+
+func (sim *Simulator) link(from string, to string) Link {
+	if link, ok := sim.Links[linkKey(from, to)]; ok {
+		return link
+	}
+	return sim.DefaultLink
+}
+
+// Elapsed is the virtual time since Epoch.
+func (sim *Simulator) Elapsed() time.Duration {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.now.Sub(Epoch)
+}
+
+// Schedule runs fn after delay of virtual time.
+func (sim *Simulator) Schedule(delay time.Duration, fn func()) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	sim.schedule(delay, fn)
+}
+
+func (sim *Simulator) schedule(delay time.Duration, fn func()) {
+	sim.seq++
+	heap.Push(&sim.queue, &event{at: sim.now.Add(delay), seq: sim.seq, fn: fn})
+}
+
+// Step runs the next event, false if there is none.
+func (sim *Simulator) Step() bool {
+	sim.mutex.Lock()
+	if sim.queue.Len() == 0 {
+		sim.mutex.Unlock()
+		return false
+	}
+	e := heap.Pop(&sim.queue).(*event)
+	sim.now = e.at
+	sim.mutex.Unlock()
+
+	e.fn()
+	return true
+}
+
+// Run runs the events within duration of virtual time from now, and stops the clock at its end.
+func (sim *Simulator) Run(duration time.Duration) {
+	sim.mutex.Lock()
+	end := sim.now.Add(duration)
+	sim.mutex.Unlock()
+	sim.RunUntil(func() bool { return false }, end)
+	sim.mutex.Lock()
+	sim.now = end
+	sim.mutex.Unlock()
+}
+
+// RunUntil runs events until done holds or the next event is after deadline, and tells if done holds.
+func (sim *Simulator) RunUntil(done func() bool, deadline time.Time) bool {
+	for !done() {
+		sim.mutex.Lock()
+		next := sim.queue.Len() != 0 && !sim.queue[0].at.After(deadline)
+		sim.mutex.Unlock()
+		if !next || !sim.Step() {
+			return false
+		}
+	}
+	return true
+}
+
+// Now of the virtual clock, nodes reading it see time stand still within an event.
+func (sim *Simulator) Now() time.Time {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	return sim.now
+}
+
+// Go runs fn as the next event at the current virtual time.
+func (sim *Simulator) Go(fn func()) {
+	sim.Schedule(0, fn)
+}
+
+func (sim *Simulator) Every(period time.Duration, fn func()) (stop func()) {
+	var stopped int32
+	var tick func()
+	tick = func() {
+		if atomic.LoadInt32(&stopped) != 0 {
+			return
+		}
+		fn()
+		sim.Schedule(period, tick)
+	}
+	sim.Schedule(period, tick)
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+	}
+}
+
+// Serial needs no worker, events already run one at a time in order.
+func (sim *Simulator) Serial() (run func(fn func()), stop func()) {
+	var stopped int32
+	run = func(fn func()) {
+		sim.Go(func() {
+			if atomic.LoadInt32(&stopped) == 0 {
+				fn()
+			}
+		})
+	}
+	return run, func() {
+		atomic.StoreInt32(&stopped, 1)
+	}
+}
+
+// Send puts the message on the link from from to to and returns at once,
+// the message reaches the operator after the latency and the transmission time of the link, or is lost.
+func (sim *Simulator) Send(from string, to string, operation string, message interface{}) (resp *http.Response, err error) {
+	c, data, err := sim.Encode(to, operation, message)
+	if err != nil {
+		return nil, err
+	}
+
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+	record := Record{
+		At:        sim.now.Sub(Epoch),
+		From:      from,
+		To:        to,
+		Operation: operation,
+		Size:      len(data),
+	}
+	operator, ok := sim.OperatorTable.Get(to)
+	if !ok {
+		record.Dropped = true
+		sim.Trace = append(sim.Trace, record)
+		return nil, fmt.Errorf("unknown operator %s", to)
+	}
+	link := sim.link(from, to)
+	// Draw the loss and the latency for every message, so the draws only depend on the order of sends.
+	lost := sim.rand.Float64() < link.Loss
+	latency := time.Duration(0)
+	if link.Latency != nil {
+		latency = link.Latency.Sample(sim.rand)
+	}
+	if lost {
+		record.Dropped = true
+		sim.Trace = append(sim.Trace, record)
+		return accepted(), nil
+	}
+
+	// A capped link sends its messages one after another.
+	sent := sim.now
+	if link.Bandwidth > 0 {
+		key := linkKey(from, to)
+		if busy := sim.busy[key]; busy.After(sent) {
+			sent = busy
+		}
+		sent = sent.Add(time.Duration(int64(len(data)) * int64(time.Second) / link.Bandwidth))
+		sim.busy[key] = sent
+	}
+	sim.Trace = append(sim.Trace, record)
+	sim.schedule(sent.Sub(sim.now)+latency, func() {
+		deliver(operator, from, to, operation, c.ContentType(), data)
+	})
+	return accepted(), nil
+}
+
+// IDs of all operators, sorted since map order is random.
+func (sim *Simulator) IDs() []string {
+	return sim.OperatorTable.IDs()
+}
+
+func (sim *Simulator) Broadcast(from string, operation string, message interface{}) (resps []*http.Response, errs []error) {
+	ids := sim.IDs()
+	resps, errs = make([]*http.Response, 0, len(ids)), make([]error, 0, len(ids))
+	for _, id := range ids {
+		if id == from {
+			continue
+		}
+		resp, err := sim.Send(from, id, operation, message)
+		resps = append(resps, resp)
+		errs = append(errs, err)
+	}
+	return resps, errs
+}
+
+func deliver(operator server.Operator, from string, to string, operation string, contentType string, data []byte) {
+	query := url.Values{}
+	query.Add("from", from)
+	query.Add("to", to)
+	query.Add("operation", operation)
+	request, err := http.NewRequest(http.MethodPost, "sim://"+to+"/node?"+query.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	request.Header.Set("Content-Type", contentType)
+	operator.DoOperation(operation, discard{header: make(http.Header)}, request)
+}
+
+// accepted is the response of a message put on a link, the reply of the operator is not waited for.
+func accepted() *http.Response {
+	return &http.Response{
+		Status:     "202 Accepted",
+		StatusCode: http.StatusAccepted,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+	}
+}
+
+// discard drops what an operator writes back, nobody waits for it.
+type discard struct {
+	header http.Header
+}
+
+func (discard discard) Header() http.Header {
+	return discard.header
+}
+
+func (discard) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (discard) WriteHeader(_ int) {}
@@ -0,0 +1,203 @@
+package utxo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrMissingOutput = errors.New("input spends an output which does not exist or is spent")
+	ErrOverspend     = errors.New("outputs exceed inputs")
+	ErrBadCoinbase   = errors.New("coinbase is misplaced or claims too much")
+	ErrOverflow      = errors.New("amounts overflow")
+)
+
+// Set holds the unspent outputs.
+type Set struct {
+	outputs map[OutPoint]*Output
+	mutex   sync.RWMutex
+}
+
+func NewSet() *Set {
+	return &Set{
+		outputs: make(map[OutPoint]*Output),
+	}
+}
+
+func (set *Set) Get(point OutPoint) (*Output, bool) {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	output, ok := set.outputs[point]
+	return output, ok
+}
+
+func (set *Set) Len() int {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	return len(set.outputs)
+}
+
+// Unspent lists the outputs owned by owner, in a stable order.
+func (set *Set) Unspent(owner string) []OutPoint {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	points := make([]OutPoint, 0)
+	for point, output := range set.outputs {
+		if output.Owner == owner {
+			points = append(points, point)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].TxID != points[j].TxID {
+			return points[i].TxID < points[j].TxID
+		}
+		return points[i].Index < points[j].Index
+	})
+	return points
+}
+
+func (set *Set) Balance(owner string) uint64 {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	var balance uint64
+	for _, output := range set.outputs {
+		if output.Owner == owner {
+			balance += output.Amount
+		}
+	}
+	return balance
+}
+
+// Validate checks a non-coinbase tx against the set and returns its fee.
+func (set *Set) Validate(tx *Transaction) (uint64, error) {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+	return set.validate(tx)
+}
+
+func (set *Set) validate(tx *Transaction) (uint64, error) {
+	if tx.IsCoinbase() {
+		return 0, ErrBadCoinbase
+	}
+	var in, out uint64
+	spent := make(map[OutPoint]bool)
+	for _, input := range tx.Inputs {
+		output, ok := set.outputs[input.Prev]
+		if !ok || spent[input.Prev] {
+			return 0, ErrMissingOutput
+		}
+		spent[input.Prev] = true
+		if err := tx.verifyInput(input, output); err != nil {
+			return 0, err
+		}
+		if in+output.Amount < in {
+			return 0, ErrOverflow
+		}
+		in += output.Amount
+	}
+	for _, output := range tx.Outputs {
+		if out+output.Amount < out {
+			return 0, ErrOverspend
+		}
+		out += output.Amount
+	}
+	if out > in {
+		return 0, ErrOverspend
+	}
+	return in - out, nil
+}
+
+func (set *Set) apply(tx *Transaction) {
+	for _, input := range tx.Inputs {
+		delete(set.outputs, input.Prev)
+	}
+	id := tx.ID()
+	for i, output := range tx.Outputs {
+		set.outputs[OutPoint{TxID: id, Index: uint32(i)}] = output
+	}
+}
+
+// Apply spends the inputs of tx and adds its outputs, it returns the fee.
+func (set *Set) Apply(tx *Transaction) (uint64, error) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	fee, err := set.validate(tx)
+	if err != nil {
+		return 0, err
+	}
+	set.apply(tx)
+	return fee, nil
+}
+
+// Fees sums the fees of the valid non-coinbase txs when applied in order, the set is not changed.
+func (set *Set) Fees(txs []*Transaction) uint64 {
+	set.mutex.RLock()
+	scratch := &Set{outputs: make(map[OutPoint]*Output, len(set.outputs))}
+	for point, output := range set.outputs {
+		scratch.outputs[point] = output
+	}
+	set.mutex.RUnlock()
+
+	var fees uint64
+	for _, tx := range txs {
+		if fee, err := scratch.validate(tx); err == nil && fees+fee >= fees {
+			scratch.apply(tx)
+			fees += fee
+		}
+	}
+	return fees
+}
+
+// ApplyBlock applies the transactions of the block at height in order and returns the error of each.
+// Only the first transaction may be a coinbase, claiming at most subsidy plus the fees of the block.
+// Sums overflowing are rejected, so no coinbase can wrap around its claim.
+func (set *Set) ApplyBlock(height int64, txs []*Transaction, subsidy uint64) []error {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	errs := make([]error, len(txs))
+	var fees uint64
+	for i, tx := range txs {
+		if tx.IsCoinbase() {
+			if i != 0 {
+				errs[i] = ErrBadCoinbase
+			}
+			continue
+		}
+		fee, err := set.validate(tx)
+		if err == nil && fees+fee < fees {
+			err = ErrOverflow
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		set.apply(tx)
+		fees += fee
+	}
+
+	if len(txs) != 0 && txs[0].IsCoinbase() {
+		errs[0] = set.applyCoinbase(height, txs[0], subsidy, fees)
+	}
+	return errs
+}
+
+func (set *Set) applyCoinbase(height int64, coinbase *Transaction, subsidy uint64, fees uint64) error {
+	allowed := subsidy + fees
+	if allowed < subsidy {
+		return fmt.Errorf("%w: subsidy and fees %v", ErrBadCoinbase, ErrOverflow)
+	}
+	var claimed uint64
+	for _, output := range coinbase.Outputs {
+		if claimed+output.Amount < claimed {
+			return fmt.Errorf("%w: claim %v", ErrBadCoinbase, ErrOverflow)
+		}
+		claimed += output.Amount
+	}
+	if coinbase.Height != height || claimed > allowed {
+		return fmt.Errorf("%w: claims %d of %d", ErrBadCoinbase, claimed, allowed)
+	}
+	set.apply(coinbase)
+	return nil
+}
@@ -0,0 +1,32 @@
+package utxo
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCoinbaseClaimOverflow(t *testing.T) {
+	set := NewSet()
+	// Both outputs together wrap around to 10, below the subsidy.
+	coinbase := &Transaction{
+		Height: 1,
+		Inputs: make([]*Input, 0),
+		Outputs: []*Output{
+			{Amount: math.MaxUint64, Owner: "producer"},
+			{Amount: 11, Owner: "producer"},
+		},
+	}
+	errs := set.ApplyBlock(1, []*Transaction{coinbase}, 50)
+	if !errors.Is(errs[0], ErrBadCoinbase) {
+		t.Fatalf("wrapping coinbase is applied, error %v", errs[0])
+	}
+	if set.Len() != 0 {
+		t.Fatalf("set holds %d outputs after a rejected coinbase", set.Len())
+	}
+
+	errs = set.ApplyBlock(1, []*Transaction{NewCoinbase(1, "producer", 50)}, 50)
+	if errs[0] != nil || set.Balance("producer") != 50 {
+		t.Fatalf("coinbase claiming the subsidy: error %v, balance %d", errs[0], set.Balance("producer"))
+	}
+}
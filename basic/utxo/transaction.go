@@ -0,0 +1,111 @@
+package utxo
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/node"
+)
+
+type OutPoint struct {
+	TxID  string `json:"txID"`
+	Index uint32 `json:"index"`
+}
+
+// Input spends a previous output, proved by a signature of the key owning it.
+type Input struct {
+	Prev      OutPoint `json:"prev"`
+	PublicKey string   `json:"publicKey"`
+	Signature string   `json:"signature"`
+}
+
+// Output is owned by the address of a key, there is no script.
+type Output struct {
+	Amount uint64 `json:"amount"`
+	Owner  string `json:"owner"`
+}
+
+// Transaction is a coinbase if it has no input, Height keeps coinbases of different blocks distinct.
+type Transaction struct {
+	Inputs  []*Input  `json:"inputs"`
+	Outputs []*Output `json:"outputs"`
+	Height  int64     `json:"height"`
+}
+
+func NewCoinbase(height int64, owner string, amount uint64) *Transaction {
+	return &Transaction{
+		Inputs:  make([]*Input, 0),
+		Outputs: []*Output{{Amount: amount, Owner: owner}},
+		Height:  height,
+	}
+}
+
+func (tx *Transaction) IsCoinbase() bool {
+	return len(tx.Inputs) == 0
+}
+
+// ID commits to the whole transaction, signatures included.
+func (tx *Transaction) ID() string {
+	content, _ := json.Marshal(tx)
+	return node.Hash(content)
+}
+
+// signingContent is the transaction with all keys and signatures cleared,
+// so every owner signs all of it independently.
+func (tx *Transaction) signingContent() ([]byte, error) {
+	unsigned := &Transaction{
+		Inputs:  make([]*Input, len(tx.Inputs)),
+		Outputs: tx.Outputs,
+		Height:  tx.Height,
+	}
+	for i, input := range tx.Inputs {
+		unsigned.Inputs[i] = &Input{Prev: input.Prev}
+	}
+	return json.Marshal(unsigned)
+}
+
+// Sign signs the inputs spending outputs owned by key.
+func (tx *Transaction) Sign(key *account.Key, set *Set) error {
+	publicKey := hex.EncodeToString(key.PublicKey)
+	for _, input := range tx.Inputs {
+		if output, ok := set.Get(input.Prev); ok && output.Owner == key.Address {
+			input.PublicKey = publicKey
+		}
+	}
+	content, err := tx.signingContent()
+	if err != nil {
+		return err
+	}
+	signature := hex.EncodeToString(ed25519.Sign(key.PrivateKey, content))
+	for _, input := range tx.Inputs {
+		if input.PublicKey == publicKey {
+			input.Signature = signature
+		}
+	}
+	return nil
+}
+
+// verifyInput checks input is signed by the owner of output.
+func (tx *Transaction) verifyInput(input *Input, output *Output) error {
+	publicKey, err := hex.DecodeString(input.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("input public key is malformed")
+	}
+	if account.Address(publicKey) != output.Owner {
+		return errors.New("input public key does not own the output")
+	}
+	signature, err := hex.DecodeString(input.Signature)
+	if err != nil {
+		return errors.New("input signature is malformed")
+	}
+	content, err := tx.signingContent()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, content, signature) {
+		return errors.New("input signature is invalid")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package votingbased
+
+import "sort"
+
+// Ballot is the latest choice of one voter, a newer ballot replaces the older one.
+type Ballot struct {
+	Voter      string
+	Candidates []string
+}
+
+type Tally struct {
+	ballots map[string]Ballot
+}
+
+func NewTally() *Tally {
+	return &Tally{
+		ballots: make(map[string]Ballot),
+	}
+}
+
+func (tally *Tally) Cast(ballot Ballot) {
+	tally.ballots[ballot.Voter] = ballot
+}
+
+func (tally *Tally) Revoke(voter string) {
+	delete(tally.ballots, voter)
+}
+
+func (tally *Tally) Reset() {
+	tally.ballots = make(map[string]Ballot)
+}
+
+// Count sums the weight of every voter for each candidate it votes for.
+func (tally *Tally) Count(weight func(voter string) int64) map[string]int64 {
+	counts := make(map[string]int64)
+	for voter, ballot := range tally.ballots {
+		w := weight(voter)
+		for _, candidate := range ballot.Candidates {
+			counts[candidate] += w
+		}
+	}
+	return counts
+}
+
+// Top returns at most n candidates with the largest weight, ties broken by id.
+func (tally *Tally) Top(n int, weight func(voter string) int64) []string {
+	counts := tally.Count(weight)
+	candidates := make([]string, 0, len(counts))
+	for candidate, count := range counts {
+		if count > 0 {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if counts[candidates[i]] != counts[candidates[j]] {
+			return counts[candidates[i]] > counts[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// Equal counts one for every voter.
+func Equal(_ string) int64 {
+	return 1
+}
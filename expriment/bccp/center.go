@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	orchestrator "github.com/glimmerzcy/bccp/basic/center"
+	util "github.com/glimmerzcy/bccp/basic/log"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"log"
+	"strings"
+	"time"
+)
+
+// runCenter serves the REST API of the center, e.g. curl -d '{"id":"node-1"}' localhost:1100/center/nodes,
+// until it is stopped by bccp stop.
+func runCenter(args []string) {
+	config := server.EnvConfig()
+	flags := flag.NewFlagSet("center", flag.ExitOnError)
+	listen := flags.String("listen", ":1100", "address to listen on")
+	servers := flags.String("server", "", "comma separated servers to register at once")
+	health := flags.Duration("health", time.Second, "period of the health checks")
+	flags.Parse(args)
+
+	util.LogInit()
+	config.Listen = *listen
+	if err := server.Start(config); err != nil {
+		log.Fatal(err)
+	}
+	c := orchestrator.DefaultCenter
+	c.Serve()
+	if *servers != "" {
+		for _, addr := range strings.Split(*servers, ",") {
+			if _, err := c.Register(addr); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	c.Start(*health)
+	defer c.Stop()
+	server.Wait()
+}
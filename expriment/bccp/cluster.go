@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/cluster"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// clusterUp runs the servers of a spec on this machine, until interrupted or for a while.
+func clusterUp(args []string) {
+	flags := flag.NewFlagSet("cluster up", flag.ExitOnError)
+	specPath := flags.String("spec", "cluster.json", "cluster spec")
+	dir := flags.String("dir", "run", "working directory of the servers, one per host")
+	hold := flags.Duration("for", 0, "tear the cluster down after this long, 0 waits for an interrupt")
+	flags.Parse(args)
+
+	spec, err := cluster.Load(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := cluster.Up(spec, *dir, exe, "server")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, host := range spec.Hosts {
+		fmt.Println(host.Name, host.Addr)
+	}
+	for _, node := range spec.Nodes {
+		fmt.Println(node.ID, node.Host)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	var timeout <-chan time.Time
+	if *hold > 0 {
+		timeout = time.After(*hold)
+	}
+	select {
+	case <-interrupt:
+	case <-timeout:
+	}
+	c.Down()
+}
@@ -0,0 +1,36 @@
+OLD_CGO_ENABLED=$(go env CGO_ENABLED)
+OLD_GOOS=$(go env GOOS)
+OLD_GOARCH=$(go env GOARCH)
+
+go env -w CGO_ENABLED=0
+go env -w GOOS=linux
+go env -w GOARCH=amd64
+go build -o bccp .
+
+go env -w CGO_ENABLED="${OLD_CGO_ENABLED}"
+go env -w GOOS="${OLD_GOOS}"
+go env -w GOARCH="${OLD_GOARCH}"
+
+servers=(106.3.97.70 106.3.97.36 106.3.97.28 106.3.97.67 106.3.97.45 106.3.97.120 106.3.97.212)
+
+# shellcheck disable=SC2088
+path="~/consensus/server"
+file="$path/bccp"
+certs="../ca/certs"
+
+for server in "${servers[@]}"
+do
+  host="root@${server}"
+  echo "mkdir -p $path" | ssh "$host"
+  scp bccp "$host:$file"
+  # certificates issued by ../ca turn on mutual TLS, see basic/server.TLSEnv
+  tls=""
+  if [ -d "${certs}/${server}" ]; then
+    echo "mkdir -p $path/tls" | ssh "$host"
+    scp "${certs}/${server}"/*.pem "$host:$path/tls/"
+    tls="BCCP_TLS=tls"
+  fi
+  # servers find each other through the first one, see basic/discovery
+  args="-advertise ${server}:1000 -bootstrap ${servers[0]}:1000"
+  echo "chmod +x $file && cd $path && $tls nohup $file server $args &" | ssh "$host"
+done
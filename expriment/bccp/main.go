@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/cluster"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// e.g. ./bccp cluster up -spec cluster.json, then ./bccp status -spec cluster.json
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	args := os.Args[2:]
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch os.Args[1] + " " + sub {
+	case "cluster up":
+		clusterUp(args[1:])
+	case "node add":
+		nodeAdd(args[1:])
+	case "node remove":
+		nodeRemove(args[1:])
+	case "node list":
+		nodeList(args[1:])
+	case "route list":
+		routeList(args[1:])
+	default:
+		switch os.Args[1] {
+		case "server":
+			runServer(args)
+		case "center":
+			runCenter(args)
+		case "send":
+			send(args)
+		case "bench":
+			bench(args)
+		case "status":
+			status(args)
+		case "stop":
+			stop(args)
+		default:
+			usage()
+		}
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  bccp server [-listen :1000] [-algo pbft] [-codec json] [-ledger dir] [-advertise addr -bootstrap addrs]
+  bccp center [-listen :1100] [-server addrs] [-health 1s]
+  bccp cluster up -spec cluster.json [-dir run] [-for duration]
+  bccp node add -server addr -id node-5 [-algo pbft] [-route addrs]
+  bccp node remove -server addr -id node-5
+  bccp node list -server addrs | -spec cluster.json
+  bccp route list -server addrs | -spec cluster.json
+  bccp send -server addr -to node-1 [-op client] [-msg json]
+  bccp bench -server addr -to node-1,node-2 [-n 10] [-c 1]
+  bccp status -server addrs | -spec cluster.json
+  bccp stop -server addrs | -spec cluster.json
+The center identity in BCCP_TLS is used against servers speaking mutual TLS.`)
+	os.Exit(2)
+}
+
+// center talks to the servers, with the identity in server.TLSEnv if any.
+func center() *server.Server {
+	c, err := server.New(server.EnvConfig())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// targets are the servers given by a comma separated list, or the hosts of a cluster spec.
+func targets(servers string, specPath string) []string {
+	if specPath != "" {
+		spec, err := cluster.Load(specPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addrs := make([]string, 0, len(spec.Hosts))
+		for _, host := range spec.Hosts {
+			addrs = append(addrs, host.Addr)
+		}
+		return addrs
+	}
+	if servers == "" {
+		log.Fatal("no server given, use -server or -spec")
+	}
+	return strings.Split(servers, ",")
+}
+
+// operate runs a server operation on the server at addr, and returns the answer.
+func operate(c *server.Server, addr string, operation string, params url.Values) ([]byte, error) {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("operation", operation)
+	resp, err := c.Client().Get(c.Scheme() + "://" + addr + "/server?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return read(resp, addr+" "+operation)
+}
+
+// post sends a JSON message to the node to at the server at addr, as the center.
+func post(c *server.Server, addr string, to string, operation string, msg string) ([]byte, error) {
+	query := url.Values{}
+	query.Add("from", "center")
+	query.Add("to", to)
+	query.Add("operation", operation)
+	resp, err := c.Client().Post(c.Scheme()+"://"+addr+"/node?"+query.Encode(), "application/json", bytes.NewReader([]byte(msg)))
+	if err != nil {
+		return nil, err
+	}
+	return read(resp, to+" "+operation)
+}
+
+func read(resp *http.Response, what string) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, fmt.Errorf("%s: %s %s", what, resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
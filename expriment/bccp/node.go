@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// nodeAdd creates a node on a server, and routes it there on that server and the other ones given.
+func nodeAdd(args []string) {
+	flags := flag.NewFlagSet("node add", flag.ExitOnError)
+	addr := flags.String("server", "localhost:1000", "server to create the node on")
+	id := flags.String("id", "", "id of the node")
+	algo := flags.String("algo", "", "algorithm of the node, the default one of the server when empty")
+	route := flags.String("route", "", "comma separated other servers to route the node on")
+	flags.Parse(args)
+	if *id == "" {
+		log.Fatal("no node id given, use -id")
+	}
+
+	c := center()
+	if _, err := operate(c, *addr, "new", url.Values{"id": {*id}, "algo": {*algo}}); err != nil {
+		log.Fatal(err)
+	}
+	servers := []string{*addr}
+	if *route != "" {
+		servers = append(servers, strings.Split(*route, ",")...)
+	}
+	for _, s := range servers {
+		if _, err := operate(c, s, "add", url.Values{"id": {*id}, "msg": {*addr}}); err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Println(*id, *addr)
+}
+
+func nodeRemove(args []string) {
+	flags := flag.NewFlagSet("node remove", flag.ExitOnError)
+	addr := flags.String("server", "localhost:1000", "server running the node")
+	id := flags.String("id", "", "id of the node")
+	flags.Parse(args)
+	if *id == "" {
+		log.Fatal("no node id given, use -id")
+	}
+
+	if _, err := operate(center(), *addr, "delete", url.Values{"id": {*id}}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// nodeList prints the nodes run by each server.
+func nodeList(args []string) {
+	flags := flag.NewFlagSet("node list", flag.ExitOnError)
+	servers := flags.String("server", "localhost:1000", "comma separated servers")
+	specPath := flags.String("spec", "", "cluster spec whose hosts are listed instead")
+	flags.Parse(args)
+
+	c := center()
+	for _, addr := range targets(*servers, *specPath) {
+		status, err := statusOf(c, addr)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, id := range status.Nodes {
+			fmt.Println(id, addr)
+		}
+	}
+}
+
+// routeList prints where each server sends the messages to every node.
+func routeList(args []string) {
+	flags := flag.NewFlagSet("route list", flag.ExitOnError)
+	servers := flags.String("server", "localhost:1000", "comma separated servers")
+	specPath := flags.String("spec", "", "cluster spec whose hosts are listed instead")
+	flags.Parse(args)
+
+	c := center()
+	for _, addr := range targets(*servers, *specPath) {
+		status, err := statusOf(c, addr)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		ids := make([]string, 0, len(status.Routes))
+		for id := range status.Routes {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			fmt.Println(addr, id, status.Routes[id])
+		}
+	}
+}
+
+// routes are where the server at addr sends to each node.
+type routes struct {
+	addr   string
+	routes map[string]string
+}
+
+func routesOf(c *server.Server, addr string) (*routes, error) {
+	status, err := statusOf(c, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &routes{addr, status.Routes}, nil
+}
+
+// of is the server of node id, the server asked when it has no route.
+func (routes *routes) of(id string) string {
+	if addr, ok := routes.routes[id]; ok {
+		return addr
+	}
+	return routes.addr
+}
+
+func statusOf(c *server.Server, addr string) (*server.Status, error) {
+	body, err := operate(c, addr, "status", nil)
+	if err != nil {
+		return nil, err
+	}
+	var status server.Status
+	if err = json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return &status, nil
+}
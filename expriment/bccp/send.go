@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientRequest is what the client operation of every algorithm takes, the reply carries the delay in microseconds.
+const clientRequest = `{"Operation":"Test"}`
+
+// send posts one message to a node and prints the answer.
+func send(args []string) {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	addr := flags.String("server", "localhost:1000", "server running the node or routing to it")
+	to := flags.String("to", "node-1", "node receiving the message")
+	operation := flags.String("op", "client", "operation of the node")
+	msg := flags.String("msg", clientRequest, "message in JSON")
+	flags.Parse(args)
+
+	c := center()
+	routes, err := routesOf(c, *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	body, err := post(c, routes.of(*to), *to, *operation, *msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(strings.TrimSpace(string(body)))
+}
+
+// bench submits client requests to the nodes in turn from c workers, and prints the delays.
+func bench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	addr := flags.String("server", "localhost:1000", "server running the nodes or routing to them")
+	to := flags.String("to", "node-1", "comma separated nodes to submit to, in turn")
+	n := flags.Int("n", 10, "requests in total")
+	workers := flags.Int("c", 1, "requests at once, a pbft node serves one client request at a time")
+	flags.Parse(args)
+
+	c := center()
+	routes, err := routesOf(c, *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nodes := strings.Split(*to, ",")
+	jobs := make(chan int)
+	delays := make([]int64, 0, *n)
+	failed := 0
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < *workers; w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range jobs {
+				node := nodes[i%len(nodes)]
+				body, err := post(c, routes.of(node), node, "client", clientRequest)
+				var reply struct{ Delay int64 }
+				if err == nil {
+					err = json.Unmarshal(body, &reply)
+				}
+				mutex.Lock()
+				if err != nil || reply.Delay < 0 {
+					log.Println(err)
+					failed++
+				} else {
+					delays = append(delays, reply.Delay)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < *n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wait.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("%d requests, %d failed, %.2f requests/s\n", *n, failed, float64(len(delays))/elapsed.Seconds())
+	if len(delays) == 0 {
+		return
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	var sum int64
+	for _, delay := range delays {
+		sum += delay
+	}
+	fmt.Printf("delay µs: avg %d, min %d, p50 %d, p90 %d, max %d\n",
+		sum/int64(len(delays)), delays[0], delays[len(delays)/2], delays[len(delays)*9/10], delays[len(delays)-1])
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/discovery"
+	"github.com/glimmerzcy/bccp/basic/ledger"
+	util "github.com/glimmerzcy/bccp/basic/log"
+	"github.com/glimmerzcy/bccp/basic/server"
+	_ "github.com/glimmerzcy/bccp/implement/dpos"
+	_ "github.com/glimmerzcy/bccp/implement/pbft"
+	_ "github.com/glimmerzcy/bccp/implement/poa"
+	"log"
+	"strings"
+)
+
+// runServer serves nodes until the center stops the server, e.g. by bccp stop.
+func runServer(args []string) {
+	config := server.EnvConfig()
+	flags := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := flags.String("listen", config.Listen, "address to listen on")
+	tls := flags.String("tls", config.TLS, "directory of the identity for mutual TLS, plain HTTP when empty")
+	advertise := flags.String("advertise", "", "address other servers reach this one at, enables discovery")
+	bootstrap := flags.String("bootstrap", "", "comma separated servers to discover the others from")
+	local := flags.Bool("local", false, "discover the servers on this machine")
+	algo := flags.String("algo", "pbft", "algorithm of the nodes created without one, one of "+strings.Join(server.Factories(), ", "))
+	codecName := flags.String("codec", codec.Default.Name(), "codec the nodes send with, json or binary, peers refusing it get json")
+	ledgers := flags.String("ledger", ledger.Dir, "directory to keep the ledgers in and resume them from, in memory when empty")
+	flags.Parse(args)
+
+	util.LogInit()
+	config.Listen = *listen
+	config.TLS = *tls
+	config.Advertise = *advertise
+	config.Algo = *algo
+	config.Codec = *codecName
+	ledger.Dir = *ledgers
+	if err := server.Start(config); err != nil {
+		log.Fatal(err)
+	}
+	if *advertise != "" {
+		peers := discovery.DefaultConfig
+		peers.Advertise = *advertise
+		peers.Local = *local
+		if *bootstrap != "" {
+			peers.Bootstrap = strings.Split(*bootstrap, ",")
+		}
+		d := discovery.New(server.DefaultServer, peers)
+		if err := d.Start(); err != nil {
+			log.Fatal(err)
+		}
+		defer d.Stop()
+	}
+	server.Wait()
+}
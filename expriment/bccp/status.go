@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// status prints the nodes, routes and transport of each server, or that it is down.
+func status(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	servers := flags.String("server", "localhost:1000", "comma separated servers")
+	specPath := flags.String("spec", "", "cluster spec whose hosts are shown instead")
+	flags.Parse(args)
+
+	c := center()
+	for _, addr := range targets(*servers, *specPath) {
+		status, err := statusOf(c, addr)
+		if err != nil {
+			fmt.Println(addr, "down:", err)
+			continue
+		}
+		transport := "http"
+		if status.TCP != "" {
+			transport = "tcp " + status.TCP
+		}
+		fmt.Printf("%s up, %s, %d nodes, %d routes, algorithms %s\n",
+			addr, transport, len(status.Nodes), len(status.Routes), strings.Join(status.Algorithms, ","))
+		if len(status.Nodes) != 0 {
+			fmt.Println("  nodes:", strings.Join(status.Nodes, " "))
+		}
+	}
+}
+
+// stop stops the nodes of each server and the server.
+func stop(args []string) {
+	flags := flag.NewFlagSet("stop", flag.ExitOnError)
+	servers := flags.String("server", "localhost:1000", "comma separated servers")
+	specPath := flags.String("spec", "", "cluster spec whose hosts are stopped instead")
+	flags.Parse(args)
+
+	c := center()
+	failed := false
+	for _, addr := range targets(*servers, *specPath) {
+		if _, err := operate(c, addr, "stop", nil); err != nil {
+			log.Println(err)
+			failed = true
+			continue
+		}
+		fmt.Println(addr, "stopped")
+	}
+	if failed {
+		log.Fatal("some servers are not stopped")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"github.com/glimmerzcy/bccp/basic/pki"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// Issues the certificates of a deployment, one identity directory per server and one for the center.
+// Copy dir/<server> to each server and run it with BCCP_TLS pointing at that copy.
+// The CA is created on the first run and reused afterwards, so servers can be added later.
+// e.g. go run main.go -dir certs 106.3.97.70 106.3.97.36
+func main() {
+	dir := flag.String("dir", "certs", "directory of the CA and the issued identities")
+	center := flag.String("center", "localhost,127.0.0.1", "comma separated hosts the center is reached at")
+	flag.Parse()
+
+	ca, err := pki.LoadCA(*dir)
+	if os.IsNotExist(err) {
+		log.Println("create CA in", *dir)
+		if ca, err = pki.NewCA("bccp"); err != nil {
+			log.Fatal(err)
+		}
+		if err = ca.Save(*dir); err != nil {
+			log.Fatal(err)
+		}
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	if err = ca.WriteIdentity(path.Join(*dir, pki.CenterName), pki.CenterName, strings.Split(*center, ",")); err != nil {
+		log.Fatal(err)
+	}
+	for _, host := range flag.Args() {
+		if err = ca.WriteIdentity(path.Join(*dir, host), host, []string{host}); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("issued", host)
+	}
+}
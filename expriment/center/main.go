@@ -2,6 +2,8 @@ package main
 
 import (
 	"github.com/glimmerzcy/bccp/basic/expriment"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"log"
 )
 
 //106.3.97.70
@@ -15,6 +17,10 @@ import (
 //MA12345abcde
 
 func main() {
+	// The nodes of TestServer run on the server of the center.
+	if err := server.Start(server.EnvConfig()); err != nil {
+		log.Fatal(err)
+	}
 	expriment.TestServer(50, 10)
 
 	//expriment.Test(10, 10)
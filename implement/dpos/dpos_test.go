@@ -0,0 +1,73 @@
+package dpos
+
+import (
+	"github.com/glimmerzcy/bccp/basic/parse"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"github.com/glimmerzcy/bccp/basic/votingbased"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain runs in a temporary directory, nodes write their logs to the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "dpos")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestVoteIsTalliedFromTheChain(t *testing.T) {
+	memory := server.NewMemory(Factory{Name: "dpos"})
+	nodes := make([]*Node, 0, 3)
+	ids := make([]string, 0, 3)
+	for i := 1; i <= 3; i++ {
+		operator, err := memory.Add(parse.ID2name(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, operator.(*Node))
+		ids = append(ids, parse.ID2name(i))
+	}
+	defer func() {
+		for _, id := range ids {
+			memory.Remove(id)
+		}
+	}()
+	stakes := map[string]int64{"node-1": 1, "node-2": 1, "node-3": 1}
+	memory.Broadcast("center", "genesis", GenesisMsg{Timestamp: time.Now().UnixMilli(), Stakes: stakes, Delegates: ids})
+
+	resp, err := memory.Send("center", "node-1", "vote", VoteMsg{Voter: "node-1", Candidates: []string{"node-2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("vote answered %d", resp.StatusCode)
+	}
+	tallied := func(node *Node) bool {
+		node.mutex.Lock()
+		defer node.mutex.Unlock()
+		return node.Votes.Count(votingbased.Equal)["node-2"] == 1
+	}
+	for _, node := range nodes {
+		if tallied(node) {
+			t.Fatalf("%s tallies the vote before a block carries it", node.ID)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, node := range nodes {
+		for !tallied(node) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s has not tallied the vote from the chain", node.ID)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package dpos
+
+import (
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/forkchoice"
+	"github.com/glimmerzcy/bccp/basic/utxo"
+)
+
+type GenesisMsg struct {
+	// unix milliseconds of slot 0
+	Timestamp int64            `json:"timestamp"`
+	Stakes    map[string]int64 `json:"stakes"`
+	Delegates []string         `json:"delegates"`
+	// balances of the account state
+	Alloc map[string]uint64 `json:"alloc"`
+	// owner to amount of the outputs created at genesis
+	Coins map[string]uint64 `json:"coins"`
+	// delegate id to the address its coinbase pays, the id itself by default
+	Beneficiaries map[string]string `json:"beneficiaries"`
+	// name of the fork-choice rule, the longest chain when empty
+	ForkChoice string `json:"forkChoice,omitempty"`
+}
+
+// Validate rejects fork-choice rules without a name known to forkchoice.RuleByName.
+func (msg *GenesisMsg) Validate() error {
+	if msg.ForkChoice != "" && forkchoice.RuleByName(msg.ForkChoice) == nil {
+		return fmt.Errorf("unknown fork-choice rule %s", msg.ForkChoice)
+	}
+	return nil
+}
+
+type StakeMsg struct {
+	Holder string `json:"holder"`
+	Amount int64  `json:"amount"`
+}
+
+type VoteMsg struct {
+	Voter      string   `json:"voter"`
+	Candidates []string `json:"candidates"`
+}
+
+type RequestMsg struct {
+	Timestamp   int64                `json:"timestamp"`
+	ClientID    string               `json:"clientID"`
+	Operation   string               `json:"operation"`
+	Transaction *account.Transaction `json:"transaction,omitempty"`
+	UTXO        *utxo.Transaction    `json:"utxo,omitempty"`
+	// tallied once the block carrying them is applied, so the delegates follow from the chain alone
+	Stake *StakeMsg `json:"stake,omitempty"`
+	Vote  *VoteMsg  `json:"vote,omitempty"`
+}
+
+// Validate rejects transactions whose signature does not hold.
+func (msg *RequestMsg) Validate() error {
+	return msg.Transaction.Validate()
+}
+
+// Extra is put in the block header.
+type Extra struct {
+	Epoch int64 `json:"epoch"`
+	Slot  int64 `json:"slot"`
+}
+
+type ClientMsg struct {
+	Operation   string
+	Transaction *account.Transaction
+	UTXO        *utxo.Transaction
+	Delay       int64
+}
+
+// Type IDs of the dpos messages on the wire.
+func init() {
+	codec.Register(0x0201, 2, GenesisMsg{})
+	codec.Register(0x0202, 1, StakeMsg{})
+	codec.Register(0x0203, 1, VoteMsg{})
+	codec.Register(0x0204, 2, RequestMsg{})
+	codec.Register(0x0205, 1, ClientMsg{})
+}
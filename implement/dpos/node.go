@@ -0,0 +1,619 @@
+package dpos
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"github.com/glimmerzcy/bccp/basic/forkchoice"
+	"github.com/glimmerzcy/bccp/basic/gossip"
+	"github.com/glimmerzcy/bccp/basic/ledger"
+	"github.com/glimmerzcy/bccp/basic/mempool"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"github.com/glimmerzcy/bccp/basic/proofbased"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"github.com/glimmerzcy/bccp/basic/utxo"
+	"github.com/glimmerzcy/bccp/basic/votingbased"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Node struct {
+	proofbased.Node
+
+	// stakes and votes of the chain up to the head, starting from the stakes of the genesis
+	Stakes *proofbased.StakeTable
+	Votes  *votingbased.Tally
+	// delegates of Epoch, the epoch of the head
+	Delegates []string
+	Epoch     int64
+	Genesis   time.Time
+	// the message the chain started from, replayed after a reorg
+	GenesisMsg *GenesisMsg
+	Ledger     ledger.Store
+	// competing chains above the ledger, the one chosen by the rule of the genesis is followed
+	Tree    *forkchoice.Tree
+	Mempool *mempool.Pool
+	State   *account.State
+	UTXOs   *utxo.Set
+	// address paid by the coinbase of the blocks produced by this node
+	Beneficiary string
+
+	// delegate id to the slots it left without a block on the chain, counted once their epoch has ended
+	Missed map[string]int
+
+	pending  map[string]chan int64
+	lastSlot int64
+	started  bool
+	mutex    sync.Mutex
+}
+
+const (
+	SlotDuration    = time.Millisecond * 500
+	DelegateNum     = 5
+	EpochRounds     = 4
+	EpochSlots      = DelegateNum * EpochRounds
+	MaxMissedSlots  = EpochRounds / 2
+	TickDuration    = time.Millisecond * 10
+	ClientTimeout   = time.Second * 30
+	MaxBlockRequest = 500
+	MaxBlockBytes   = 1 << 20
+	// blocks deeper than PruneDepth below the head are final
+	PruneDepth = EpochSlots * 2
+)
+
+func NewNode(id string, sender server.Sender) *Node {
+	node := &Node{
+		Node:        proofbased.Node{Node: *node.NewNode(id, sender)},
+		Stakes:      proofbased.NewStakeTable(),
+		Votes:       votingbased.NewTally(),
+		Delegates:   make([]string, 0),
+		Mempool:     mempool.New(mempool.DefaultConfig),
+		State:       account.NewState(nil),
+		UTXOs:       utxo.NewSet(),
+		Beneficiary: id,
+		Missed:      make(map[string]int),
+		pending:     make(map[string]chan int64),
+		lastSlot:    -1,
+	}
+
+	register(node)
+
+	return node
+}
+
+func register(replica *Node) {
+	node.Register(&replica.Node.Node, "genesis", replica.handleGenesis)
+	node.Register(&replica.Node.Node, "stake", replica.handleStake)
+	node.Register(&replica.Node.Node, "vote", replica.handleVote)
+	node.Register(&replica.Node.Node, "req", replica.handleRequest)
+	replica.Operations[mempool.GossipOperation] = replica.Mempool.HandleTx(replica.Logger, mempool.Derive(replica.wrap))
+	node.Register(&replica.Node.Node, "block", replica.handleBlock)
+	node.RegisterReply(&replica.Node.Node, "client", replica.handleClient)
+	replica.Operations["metrics"] = replica.handleMetrics
+	replica.Control("genesis", "stake")
+}
+
+// Start the slot ticker once the node is registered.
+func (node *Node) Start() {
+	node.Every(TickDuration, node.alarmToProducer)
+}
+
+// Stop ends the slot ticker, then closes the ledger.
+func (node *Node) Stop() {
+	node.Node.Stop()
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.Ledger != nil {
+		node.Ledger.Close()
+	}
+}
+
+// Factory makes dpos nodes, it is registered as "dpos".
+type Factory struct {
+	Name string
+	// broadcast by gossip instead of to every peer directly, when set
+	Gossip *gossip.Config
+}
+
+func init() {
+	server.RegisterFactory("dpos", Factory{Name: "dpos"})
+}
+
+func (factory Factory) NewOperator(id string, sender server.Sender) (server.Operator, error) {
+	node := NewNode(id, sender)
+	if factory.Gossip != nil {
+		node.UseGossip(*factory.Gossip)
+	}
+	return node, nil
+}
+
+// Producer returns the delegate scheduled for slot, delegates take turns in round-robin.
+// Slots of a later epoch than the head follow the delegates elected from the chain.
+func (node *Node) Producer(slot int64) string {
+	return producerOf(node.delegatesOf(epochOf(slot)), slot)
+}
+
+func producerOf(delegates []string, slot int64) string {
+	if len(delegates) == 0 {
+		return ""
+	}
+	return delegates[slot%int64(len(delegates))]
+}
+
+func epochOf(slot int64) int64 {
+	return slot / EpochSlots
+}
+
+func (node *Node) head() *block.Block {
+	return node.Ledger.Head()
+}
+
+func slotOf(b *block.Block) int64 {
+	var extra Extra
+	if err := b.DecodeExtra(&extra); err != nil {
+		return -1
+	}
+	return extra.Slot
+}
+
+func (node *Node) alarmToProducer() {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.started {
+		node.onTick(proofbased.SlotAt(node.Genesis, time.Now(), SlotDuration))
+	}
+}
+
+func (node *Node) onTick(slot int64) {
+	if slot <= node.lastSlot {
+		return
+	}
+	node.lastSlot = slot
+
+	if node.Producer(slot) == node.ID {
+		newBlock, err := node.produce(slot)
+		if err == nil {
+			err = node.apply(newBlock)
+		}
+		if err != nil {
+			node.Println(err)
+			return
+		}
+		go node.Broadcast(node.ID, "block", newBlock)
+	}
+}
+
+// delegatesOf elects the delegates of epoch one epoch after another from the one of the head,
+// by the slots missed on the chain, so every node on the same chain elects the same delegates.
+// A block of the last slot arriving after the epoch has ended still counts, until a block of the new epoch is applied.
+func (node *Node) delegatesOf(epoch int64) []string {
+	delegates := node.Delegates
+	for ended := node.Epoch; ended < epoch; ended++ {
+		delegates = node.elect(delegates, node.missed(ended, delegates))
+	}
+	return delegates
+}
+
+// advance moves the head to epoch, recording the slots missed in the epochs which have ended.
+func (node *Node) advance(epoch int64) {
+	for ended := node.Epoch; ended < epoch; ended++ {
+		missed := node.missed(ended, node.Delegates)
+		for delegate, slots := range missed {
+			node.Missed[delegate] += slots
+			node.Println("missed", slots, "slots of epoch", ended, "by", delegate)
+		}
+		node.Delegates = node.elect(node.Delegates, missed)
+		node.Epoch = ended + 1
+		node.Println("epoch", node.Epoch, "delegates:", node.Delegates)
+	}
+}
+
+// missed counts the slots of epoch without a block on the chain, by the delegate scheduled for them.
+func (node *Node) missed(epoch int64, delegates []string) map[string]int {
+	first, end := epoch*EpochSlots, (epoch+1)*EpochSlots
+	produced := make(map[int64]bool)
+	for height := node.Ledger.Height(); height > 0; height-- {
+		b, err := node.Ledger.Get(height)
+		if err != nil || slotOf(b) < first {
+			break
+		}
+		produced[slotOf(b)] = true
+	}
+	missed := make(map[string]int)
+	for slot := first; slot < end; slot++ {
+		if !produced[slot] {
+			missed[producerOf(delegates, slot)]++
+		}
+	}
+	return missed
+}
+
+// elect returns the delegates voted for the coming epoch, delegates missing too many slots
+// in the last epoch are not eligible. The delegates are kept if nobody is eligible.
+func (node *Node) elect(delegates []string, missed map[string]int) []string {
+	candidates := node.Votes.Top(DelegateNum+len(missed), node.Stakes.Get)
+	elected := make([]string, 0, DelegateNum)
+	for _, candidate := range candidates {
+		if missed[candidate] > MaxMissedSlots {
+			continue
+		}
+		if len(elected) < DelegateNum {
+			elected = append(elected, candidate)
+		}
+	}
+	if len(elected) == 0 {
+		return delegates
+	}
+	return elected
+}
+
+func (node *Node) produce(slot int64) (*block.Block, error) {
+	batch := node.Mempool.Batch(MaxBlockRequest, MaxBlockBytes)
+	reqMsgs, err := mempool.Decode[RequestMsg](batch)
+	if err != nil {
+		return nil, err
+	}
+
+	// The coinbase comes first, claiming the subsidy and the fees of the batch.
+	height := node.head().Height + 1
+	spends := make([]*utxo.Transaction, 0)
+	for _, reqMsg := range reqMsgs {
+		if reqMsg.UTXO != nil {
+			spends = append(spends, reqMsg.UTXO)
+		}
+	}
+	coinbase := &RequestMsg{
+		Timestamp: time.Now().UnixNano(),
+		ClientID:  node.ID,
+		Operation: "coinbase",
+		UTXO:      utxo.NewCoinbase(height, node.Beneficiary, proofbased.Subsidy(height)+node.UTXOs.Fees(spends)),
+	}
+	txs, err := block.Encode([]*RequestMsg{coinbase})
+	if err != nil {
+		return nil, err
+	}
+	txs = append(txs, mempool.Payloads(batch)...)
+
+	extra := &Extra{
+		Epoch: epochOf(slot),
+		Slot:  slot,
+	}
+	return block.NewBlock(node.head(), time.Now().UnixMilli(), node.ID, txs, extra)
+}
+
+func (node *Node) verify(newBlock *block.Block) error {
+	var extra Extra
+	if err := newBlock.DecodeExtra(&extra); err != nil {
+		return err
+	}
+	if extra.Slot <= slotOf(node.head()) {
+		return errors.New("block slot is not after the head")
+	}
+	if extra.Epoch != epochOf(extra.Slot) {
+		return errors.New("block epoch does not match its slot")
+	}
+	if newBlock.Proposer != node.Producer(extra.Slot) {
+		return errors.New("block is not produced by the scheduled delegate")
+	}
+	return newBlock.Verify(node.head())
+}
+
+// apply adds newBlock to the block tree and follows the chain chosen by the fork-choice rule.
+func (node *Node) apply(newBlock *block.Block) error {
+	if err := node.Tree.Add(newBlock); err != nil {
+		return err
+	}
+	return node.follow()
+}
+
+// follow moves the ledger to the head of the tree, the blocks failing verification are removed from the tree.
+func (node *Node) follow() error {
+	var rejected error
+	for node.Tree.Head().Hash != node.head().Hash {
+		reorg, err := node.Tree.Path(node.head().Hash, node.Tree.Head().Hash)
+		if err != nil {
+			return err
+		}
+		bad, err := node.switchTo(reorg)
+		if err == nil {
+			node.Tree.Metrics.Record(reorg)
+			if reorg.Depth > 0 {
+				node.Printf("Reorganized %d blocks at height %d to %s", reorg.Depth, reorg.Ancestor.Height, reorg.NewHead.Hash)
+			}
+			node.Tree.Prune(node.head().Height - PruneDepth)
+			return rejected
+		}
+		rejected = err
+		node.Println("block", bad.Hash, "is rejected:", err)
+		if err = node.Tree.Remove(bad.Hash); err != nil {
+			return err
+		}
+	}
+	return rejected
+}
+
+// switchTo rolls the ledger back to the common ancestor and applies the new chain,
+// returning the first block that can not be applied.
+func (node *Node) switchTo(reorg *forkchoice.Reorg) (*block.Block, error) {
+	if reorg.Depth > 0 {
+		if err := node.Ledger.Truncate(reorg.Ancestor.Height); err != nil {
+			return reorg.NewHead, err
+		}
+		node.rebuild()
+		// Return the requests of the dropped blocks to the mempool, but their coinbases.
+		txs := make([]*mempool.Tx, 0)
+		for _, dropped := range reorg.Dropped {
+			for _, raw := range dropped.Transactions {
+				var req RequestMsg
+				if err := json.Unmarshal(raw, &req); err != nil || req.Operation == "coinbase" {
+					continue
+				}
+				if tx, err := node.toTx(&req); err == nil {
+					txs = append(txs, tx)
+				}
+			}
+		}
+		node.Mempool.Restore(txs...)
+	}
+
+	for _, newBlock := range reorg.Added {
+		if err := node.verify(newBlock); err != nil {
+			return newBlock, err
+		}
+		var extra Extra
+		_ = newBlock.DecodeExtra(&extra)
+		// Elect from the chain before the block, its epoch starts with it.
+		node.advance(extra.Epoch)
+		newBlock.Certificate = &block.Certificate{
+			View:     extra.Epoch,
+			Sequence: extra.Slot,
+			Digest:   newBlock.Hash,
+			Signers:  []string{newBlock.Proposer},
+		}
+		if err := node.Ledger.Append(newBlock); err != nil {
+			return newBlock, err
+		}
+		node.execute(newBlock)
+
+		hashes := make([]string, 0)
+		for _, tx := range newBlock.Transactions {
+			key, _ := digest(tx)
+			hashes = append(hashes, key)
+			if ch, ok := node.pending[key]; ok {
+				ch <- time.Now().UnixMicro()
+				delete(node.pending, key)
+			}
+		}
+		node.Mempool.Remove(hashes...)
+
+		node.Printf("Block applied: height %d, slot %d, producer %s, %d transactions",
+			newBlock.Height, extra.Slot, newBlock.Proposer, len(newBlock.Transactions))
+	}
+	return nil, nil
+}
+
+// rebuild replays the chain from genesis to recover the stakes, the votes, the account state, the UTXO set
+// and the delegates of the head.
+func (node *Node) rebuild() {
+	msg := node.GenesisMsg
+	node.Stakes = proofbased.NewStakeTable()
+	for holder, amount := range msg.Stakes {
+		node.Stakes.Set(holder, amount)
+	}
+	node.Votes = votingbased.NewTally()
+	node.Delegates, node.Epoch = msg.Delegates, 0
+	node.Missed = make(map[string]int)
+	node.State = account.NewState(msg.Alloc)
+	node.UTXOs = utxo.NewSet()
+	var coins uint64
+	outputs := make([]*utxo.Output, 0, len(msg.Coins))
+	for owner, amount := range msg.Coins {
+		outputs = append(outputs, &utxo.Output{Amount: amount, Owner: owner})
+		coins += amount
+	}
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Owner < outputs[j].Owner })
+	node.UTXOs.ApplyBlock(0, []*utxo.Transaction{{Inputs: make([]*utxo.Input, 0), Outputs: outputs}}, coins)
+	// Elect at every epoch a block starts, with the stakes and votes of the chain before it.
+	err := node.Ledger.Range(1, node.Ledger.Height(), func(b *block.Block) bool {
+		node.advance(epochOf(slotOf(b)))
+		node.execute(b)
+		return true
+	})
+	if err != nil {
+		node.Println(err)
+	}
+}
+
+// toTx wraps a request for the mempool, a transaction is ordered by the account nonce and prioritized by fee.
+// A UTXO transaction must be valid against the set now, its fee is what its inputs leave over.
+// The caller holds the mutex.
+func (node *Node) toTx(msg *RequestMsg) (*mempool.Tx, error) {
+	if tx := msg.Transaction; tx != nil {
+		return tx.ToMempool(msg)
+	}
+	if tx := msg.UTXO; tx != nil {
+		fee, err := node.UTXOs.Validate(tx)
+		if err != nil {
+			return nil, err
+		}
+		return mempool.NewTx(tx.ID(), 0, mempool.Priority(fee), msg)
+	}
+	return mempool.NewTx(msg.ClientID, msg.Timestamp, 0, msg)
+}
+
+// wrap is toTx under the mutex, the UTXO set is replaced on a reorganization.
+func (node *Node) wrap(msg *RequestMsg) (*mempool.Tx, error) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.toTx(msg)
+}
+
+// execute applies the transactions, stakes and votes carried by the requests of b, the invalid transactions are skipped.
+func (node *Node) execute(b *block.Block) {
+	spends := make([]*utxo.Transaction, 0)
+	for _, raw := range b.Transactions {
+		var msg RequestMsg
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			continue
+		}
+		if msg.UTXO != nil {
+			spends = append(spends, msg.UTXO)
+		}
+		if msg.Stake != nil {
+			node.Stakes.Set(msg.Stake.Holder, msg.Stake.Amount)
+		}
+		if msg.Vote != nil {
+			node.Votes.Cast(votingbased.Ballot{Voter: msg.Vote.Voter, Candidates: msg.Vote.Candidates})
+		}
+		if msg.Transaction == nil {
+			continue
+		}
+		if err := node.State.Apply(msg.Transaction, b.Proposer); err != nil {
+			node.Println("transaction", msg.Transaction.Hash(), "is rejected:", err)
+		}
+	}
+	for i, err := range node.UTXOs.ApplyBlock(b.Height, spends, proofbased.Subsidy(b.Height)) {
+		if err != nil {
+			node.Println("utxo transaction", spends[i].ID(), "is rejected:", err)
+		}
+	}
+}
+
+// StartRequest submits msg as a client of this node and waits for it to be included.
+func (node *Node) StartRequest(msg *RequestMsg) (int64, error) {
+	msg.ClientID = node.ID
+	msg.Timestamp = time.Now().UnixNano()
+	tx, err := node.wrap(msg)
+	if err != nil {
+		return -1, err
+	}
+	key := tx.Hash
+	start := time.Now().UnixMicro()
+	done := make(chan int64, 1)
+
+	node.mutex.Lock()
+	node.pending[key] = done
+	node.mutex.Unlock()
+
+	if errs := node.Mempool.Submit(node, node.ID, tx); len(errs) != 0 {
+		node.mutex.Lock()
+		delete(node.pending, key)
+		node.mutex.Unlock()
+		return -1, errs[0]
+	}
+
+	select {
+	case end := <-done:
+		node.Printf("Request Finished! Delay: %d", end-start)
+		return end - start, nil
+	case <-time.After(ClientTimeout):
+		node.mutex.Lock()
+		delete(node.pending, key)
+		node.mutex.Unlock()
+		return -1, errors.New("request is not included before timeout")
+	}
+}
+
+func (node *Node) handleGenesis(_ string, msg *GenesisMsg) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	genesis, err := block.Genesis(msg.Timestamp, &Extra{Slot: -1})
+	if err != nil {
+		return err
+	}
+	// Keep the current ledger if the one of the new genesis can not be opened.
+	store, err := ledger.ForNode(node.ID, genesis)
+	if err != nil {
+		return err
+	}
+	if node.Ledger != nil {
+		node.Ledger.Close()
+	}
+	node.Ledger = store
+	node.GenesisMsg = msg
+	if beneficiary, ok := msg.Beneficiaries[node.ID]; ok {
+		node.Beneficiary = beneficiary
+	}
+	// Replay the resumed ledger on the account state, the UTXO set and the delegates.
+	node.rebuild()
+	var rule forkchoice.Rule = forkchoice.LongestChain{}
+	if msg.ForkChoice != "" {
+		rule = forkchoice.RuleByName(msg.ForkChoice)
+	}
+	node.Tree = forkchoice.NewTree(node.head(), rule)
+	node.Genesis = time.UnixMilli(msg.Timestamp)
+	node.started = true
+	node.Println("genesis at", node.Genesis, "delegates:", node.Delegates)
+	return nil
+}
+
+// handleStake submits the stake as a request, it counts once a block carrying it is applied.
+func (node *Node) handleStake(_ string, msg *StakeMsg) error {
+	return node.submit(&RequestMsg{Operation: "stake", Stake: msg})
+}
+
+// handleVote submits the vote as a request, it counts once a block carrying it is applied.
+func (node *Node) handleVote(_ string, msg *VoteMsg) error {
+	return node.submit(&RequestMsg{Operation: "vote", Vote: msg})
+}
+
+func (node *Node) submit(msg *RequestMsg) error {
+	msg.ClientID = node.ID
+	msg.Timestamp = time.Now().UnixNano()
+	return node.handleRequest(node.ID, msg)
+}
+
+func (node *Node) handleRequest(_ string, msg *RequestMsg) error {
+	tx, err := node.wrap(msg)
+	if err != nil {
+		return err
+	}
+	for _, err := range node.Mempool.Submit(node, node.ID, tx) {
+		node.Println(err)
+	}
+	return nil
+}
+
+func (node *Node) handleBlock(_ string, msg *block.Block) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if !node.started {
+		return errors.New("block received before genesis")
+	}
+	return node.apply(msg)
+}
+
+func (node *Node) handleClient(_ string, msg *ClientMsg) (*ClientMsg, error) {
+	delay, err := node.StartRequest(&RequestMsg{
+		Operation:   "Test",
+		Transaction: msg.Transaction,
+		UTXO:        msg.UTXO,
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.Delay = delay
+	return msg, nil
+}
+
+func (node *Node) handleMetrics(writer http.ResponseWriter, _ *http.Request) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.Tree == nil {
+		node.Println("metrics requested before genesis")
+		return
+	}
+	jsonMessage, _ := json.Marshal(node.Tree.Metrics)
+	writer.Write(jsonMessage)
+}
+
+func digest(object interface{}) (string, error) {
+	msg, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	return node.Hash(msg), nil
+}
@@ -0,0 +1,250 @@
+package pbft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSilent = errors.New("byzantine replica keeps silent")
+	ErrHeld   = errors.New("byzantine replica holds the message back")
+)
+
+// Strategy is the misbehaviour of a Byzantine replica, applied to every message it sends.
+type Strategy interface {
+	Name() string
+	// Send delivers message to peer to in its faulty way, byzantine.Sender still sends honestly.
+	Send(byzantine *Byzantine, to string, operation string, message interface{}) (*http.Response, error)
+}
+
+// Strategies by name, each call gives a strategy with its own state.
+var Strategies = map[string]func() Strategy{
+	"equivocate":   func() Strategy { return Equivocate{} },
+	"silent":       func() Strategy { return Silent{} },
+	"wrong-digest": func() Strategy { return WrongDigest{} },
+	"replay": func() Strategy {
+		return &Replay{current: make(map[string]interface{}), previous: make(map[string]interface{})}
+	},
+	"delay-flood": func() Strategy { return &DelayFlood{Hold: time.Second, Copies: 5} },
+}
+
+// Byzantine decorates the Sender of a replica, every message goes out through the strategy.
+type Byzantine struct {
+	server.Sender
+	Node     *Node
+	Strategy Strategy
+	// ends the timer of the strategy, if it has one
+	stop func()
+}
+
+// MakeByzantine turns node into a faulty replica, replacing the strategy if it is faulty already.
+func MakeByzantine(node *Node, strategy string) error {
+	newStrategy, ok := Strategies[strategy]
+	if !ok {
+		return fmt.Errorf("unknown byzantine strategy %s", strategy)
+	}
+	honest := node.Sender()
+	if byzantine, ok := honest.(*Byzantine); ok {
+		if byzantine.stop != nil {
+			byzantine.stop()
+		}
+		honest = byzantine.Sender
+	}
+	byzantine := &Byzantine{
+		Sender:   honest,
+		Node:     node,
+		Strategy: newStrategy(),
+	}
+	if flood, ok := byzantine.Strategy.(*DelayFlood); ok {
+		byzantine.stop = node.Every(flood.Hold, func() {
+			flood.flush(byzantine)
+		})
+	}
+	node.UseSender(byzantine)
+	node.Println("turn byzantine:", strategy)
+	return nil
+}
+
+func (byzantine *Byzantine) Send(_ string, to string, operation string, message interface{}) (*http.Response, error) {
+	return byzantine.Strategy.Send(byzantine, to, operation, message)
+}
+
+// Broadcast sends to the peers one by one, so the strategy may treat each of them differently.
+func (byzantine *Byzantine) Broadcast(from string, operation string, message interface{}) (resps []*http.Response, errs []error) {
+	peers := byzantine.peers()
+	if peers == nil {
+		return byzantine.Sender.Broadcast(from, operation, message)
+	}
+	resps, errs = make([]*http.Response, 0, len(peers)), make([]error, 0, len(peers))
+	for _, id := range peers {
+		resp, err := byzantine.Send(from, id, operation, message)
+		resps = append(resps, resp)
+		errs = append(errs, err)
+	}
+	return resps, errs
+}
+
+// peers are the other nodes in order, nil if the honest Sender does not tell them.
+func (byzantine *Byzantine) peers() []string {
+	router, ok := byzantine.Sender.(interface{ IDs() []string })
+	if !ok {
+		return nil
+	}
+	peers := make([]string, 0)
+	for _, id := range router.IDs() {
+		if id != byzantine.Node.ID {
+			peers = append(peers, id)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+func (byzantine *Byzantine) honest(to string, operation string, message interface{}) (*http.Response, error) {
+	return byzantine.Sender.Send(byzantine.Node.ID, to, operation, message)
+}
+
+// Equivocate is a primary sending a pre-prepare for other requests to every second backup.
+type Equivocate struct{}
+
+func (Equivocate) Name() string {
+	return "equivocate"
+}
+
+func (Equivocate) Send(byzantine *Byzantine, to string, operation string, message interface{}) (*http.Response, error) {
+	prePrepareMsg, ok := message.(*PrePrepareMsg)
+	if !ok || operation != "pre-prepare" {
+		return byzantine.honest(to, operation, message)
+	}
+	peers := byzantine.peers()
+	index := sort.SearchStrings(peers, to)
+	if index%2 == 0 {
+		return byzantine.honest(to, operation, message)
+	}
+
+	forged := *prePrepareMsg
+	forged.RequestMsgs = make([]*RequestMsg, 0, len(prePrepareMsg.RequestMsgs))
+	for _, reqMsg := range prePrepareMsg.RequestMsgs {
+		conflicting := *reqMsg
+		conflicting.Operation += "'"
+		forged.RequestMsgs = append(forged.RequestMsgs, &conflicting)
+	}
+	forgedDigest, err := digest(forged.RequestMsgs)
+	if err != nil {
+		return nil, err
+	}
+	forged.Digest = forgedDigest
+	return byzantine.honest(to, operation, &forged)
+}
+
+// Silent is a replica sending nothing at all.
+type Silent struct{}
+
+func (Silent) Name() string {
+	return "silent"
+}
+
+func (Silent) Send(_ *Byzantine, _ string, _ string, _ interface{}) (*http.Response, error) {
+	return nil, ErrSilent
+}
+
+// WrongDigest is a replica voting for requests nobody proposed.
+type WrongDigest struct{}
+
+func (WrongDigest) Name() string {
+	return "wrong-digest"
+}
+
+func (WrongDigest) Send(byzantine *Byzantine, to string, operation string, message interface{}) (*http.Response, error) {
+	voteMsg, ok := message.(*VoteMsg)
+	if !ok {
+		return byzantine.honest(to, operation, message)
+	}
+	wrong := *voteMsg
+	wrong.Digest = node.Hash([]byte(voteMsg.Digest))
+	return byzantine.honest(to, operation, &wrong)
+}
+
+// Replay is a replica sending the consensus message of the previous round again before every new one.
+type Replay struct {
+	// operation to the message of the current round and of the one before
+	current  map[string]interface{}
+	previous map[string]interface{}
+	mutex    sync.Mutex
+}
+
+func (replay *Replay) Name() string {
+	return "replay"
+}
+
+func (replay *Replay) Send(byzantine *Byzantine, to string, operation string, message interface{}) (*http.Response, error) {
+	switch operation {
+	case "pre-prepare", "prepare", "commit":
+	default:
+		return byzantine.honest(to, operation, message)
+	}
+	replay.mutex.Lock()
+	if replay.current[operation] != message {
+		replay.previous[operation] = replay.current[operation]
+		replay.current[operation] = message
+	}
+	old := replay.previous[operation]
+	replay.mutex.Unlock()
+
+	if old != nil {
+		if resp, err := byzantine.honest(to, operation, old); err == nil {
+			resp.Body.Close()
+		}
+	}
+	return byzantine.honest(to, operation, message)
+}
+
+// DelayFlood is a replica holding its messages back for Hold, then sending each of them Copies times at once.
+type DelayFlood struct {
+	Hold   time.Duration
+	Copies int
+	held   []heldMsg
+	mutex  sync.Mutex
+}
+
+type heldMsg struct {
+	to        string
+	operation string
+	message   interface{}
+}
+
+func (flood *DelayFlood) Name() string {
+	return "delay-flood"
+}
+
+func (flood *DelayFlood) Send(_ *Byzantine, to string, operation string, message interface{}) (*http.Response, error) {
+	flood.mutex.Lock()
+	defer flood.mutex.Unlock()
+	flood.held = append(flood.held, heldMsg{to, operation, message})
+	return nil, ErrHeld
+}
+
+func (flood *DelayFlood) flush(byzantine *Byzantine) {
+	flood.mutex.Lock()
+	held := flood.held
+	flood.held = nil
+	flood.mutex.Unlock()
+	if len(held) == 0 {
+		return
+	}
+	byzantine.Node.Go(func() {
+		for i := 0; i < flood.Copies; i++ {
+			for _, msg := range held {
+				if resp, err := byzantine.honest(msg.to, msg.operation, msg.message); err == nil {
+					resp.Body.Close()
+				}
+			}
+		}
+	})
+}
@@ -3,34 +3,49 @@ package pbft
 import (
 	"errors"
 	"log"
+	"sync"
 	"time"
 )
 
 const IntMin int = ^0x3f3f3f3f
 
+// Client is the request a node runs as client, replies arrive from other goroutines than the one starting it.
 type Client struct {
 	Count     int
 	StartTime int64
 	Msg       chan int64
+	mutex     sync.Mutex
 }
 
 func NewClient() *Client {
-	return &Client{Count: IntMin, Msg: make(chan int64)}
+	return &Client{Count: IntMin, Msg: make(chan int64, 1)}
 }
 
-func (client *Client) Start() error {
+func (client *Client) Start(now time.Time) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	if client.Count >= 0 {
 		return errors.New("another request is running")
 	}
-	client.StartTime = time.Now().UnixMicro()
-	log.Println("client started!", client.StartTime, time.Now().UnixMicro())
+	client.StartTime = now.UnixMicro()
+	log.Println("client started!", client.StartTime, now.UnixMicro())
 	//fmt.Println(client.StartTime)
 	client.Count = 0
 	return nil
 }
 
-func (client *Client) End() {
+// Reply counts a reply, the request ends with the reply after the f-th one.
+func (client *Client) Reply(f int, now time.Time) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.Count++
+	if client.Count > f {
+		client.end(now)
+	}
+}
+
+func (client *Client) end(now time.Time) {
 	client.Count = IntMin
-	log.Println("client ended!", client.StartTime, time.Now().UnixMicro())
-	client.Msg <- time.Now().UnixMicro() - client.StartTime
+	log.Println("client ended!", client.StartTime, now.UnixMicro())
+	client.Msg <- now.UnixMicro() - client.StartTime
 }
@@ -1,10 +1,39 @@
 package pbft
 
+import (
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/codec"
+)
+
 type SetFMsg struct {
 	Total int
 }
 
 type ClientMsg struct {
-	Operation string
-	Delay     int64
+	Operation   string
+	Transaction *account.Transaction
+	Delay       int64
+}
+
+// AllocMsg sets the balances the account state starts from, it must be sent to every node.
+type AllocMsg struct {
+	Balances map[string]uint64
+}
+
+// ByzantineMsg turns the node into a faulty replica with one of Strategies.
+type ByzantineMsg struct {
+	Strategy string
+}
+
+// Type IDs of the pbft messages on the wire.
+func init() {
+	codec.Register(0x0101, 1, RequestMsg{})
+	codec.Register(0x0102, 1, ReplyMsg{})
+	codec.Register(0x0103, 1, PrePrepareMsg{})
+	codec.Register(0x0104, 1, VoteMsg{})
+	codec.Register(0x0105, 1, SetFMsg{})
+	codec.Register(0x0106, 1, ClientMsg{})
+	codec.Register(0x0107, 1, AllocMsg{})
+	codec.Register(0x0108, 1, ByzantineMsg{})
+	codec.Register(0x0109, 1, CheckpointMsg{})
 }
@@ -4,23 +4,37 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"github.com/glimmerzcy/bccp/basic/gossip"
+	"github.com/glimmerzcy/bccp/basic/ledger"
 	log2 "github.com/glimmerzcy/bccp/basic/log"
+	"github.com/glimmerzcy/bccp/basic/mempool"
 	"github.com/glimmerzcy/bccp/basic/node"
 	"github.com/glimmerzcy/bccp/basic/server"
 	"math"
 	"net/http"
-	"sync"
+	"sort"
 	"time"
 )
 
+// Node is a pbft replica. CurrentState, MsgBuffer and the f values are only touched by the work
+// handed to deliver, which runs one piece after another.
 type Node struct {
 	node.Node
 
-	View          *View
-	CurrentState  *State
-	CommittedMsgs []*RequestMsg // kinda block.
-	MsgBuffer     *MsgBuffer
-	MsgDelivery   chan interface{}
+	View         *View
+	CurrentState *State
+	Ledger       ledger.Store
+	Mempool      *mempool.Pool
+	State        *account.State
+	MsgBuffer    *MsgBuffer
+	// the last checkpoint 2f+1 replicas agree on, nil before the first
+	Stable *CheckpointMsg
+	// digests announced by the replicas for the heights above Stable
+	checkpoints map[int64]map[string]string
+	// hands work on the consensus state to the single resolver
+	deliver func(fn func())
 
 	Client *Client
 
@@ -31,7 +45,6 @@ type Node struct {
 }
 
 type MsgBuffer struct {
-	ReqMsgs        []*RequestMsg
 	PrePrepareMsgs []*PrePrepareMsg
 	PrepareMsgs    []*VoteMsg
 	CommitMsgs     []*VoteMsg
@@ -44,8 +57,22 @@ type View struct {
 
 const ResolvingTimeDuration = time.Millisecond * 10 // 1 second.
 
-func NewNode(id string, sender server.Sender) *Node {
+// Every CheckpointPeriod blocks the replicas exchange the digest of the blocks since the last checkpoint.
+const CheckpointPeriod = 5
+
+// The primary proposes at most MaxBatchCount requests of MaxBatchBytes at once.
+const (
+	MaxBatchCount = 100
+	MaxBatchBytes = 1 << 20
+)
+
+func NewNode(id string, sender server.Sender) (*Node, error) {
 	const viewID = 10000000000 // temporary.
+	genesis, _ := block.Genesis(0, nil)
+	store, err := ledger.ForNode(id, genesis)
+	if err != nil {
+		return nil, err
+	}
 	node := &Node{
 		Node: *node.NewNode(id, sender),
 		// Hard-coded for test.
@@ -55,95 +82,134 @@ func NewNode(id string, sender server.Sender) *Node {
 		},
 
 		// Consensus-related struct
-		CurrentState:  nil,
-		CommittedMsgs: make([]*RequestMsg, 0),
+		CurrentState: nil,
+		Ledger:       store,
+		Mempool:      mempool.New(mempool.DefaultConfig),
+		State:        account.NewState(nil),
 		MsgBuffer: &MsgBuffer{
-			ReqMsgs:        make([]*RequestMsg, 0),
 			PrePrepareMsgs: make([]*PrePrepareMsg, 0),
 			PrepareMsgs:    make([]*VoteMsg, 0),
 			CommitMsgs:     make([]*VoteMsg, 0),
 		},
-
-		// Channels
-		MsgDelivery: make(chan interface{}),
+		checkpoints: make(map[int64]map[string]string),
 
 		Client: NewClient(),
 		total:  0,
 	}
 
-	node.Operations["req"] = node.handleRequest
-	node.Operations["pre-prepare"] = node.handlePrePrepare
-	node.Operations["prepare"] = node.handlePrepare
-	node.Operations["commit"] = node.handleCommit
-	node.Operations["reply"] = node.handleReply
-	node.Operations["add"] = node.handleAdd
-	node.Operations["setF"] = node.handleSetF
-	node.Operations["client"] = node.handleClient
-
-	// Start alarm trigger
-	go node.alarmToDispatcher()
+	register(node)
+	node.Mempool.Now = node.Now
 
 	// Start message resolver
-	go node.resolveMsg()
+	node.deliver = node.Serial()
+
+	return node, nil
+}
+
+func register(replica *Node) {
+	node.Register(&replica.Node, "req", replica.handleRequest)
+	node.Register(&replica.Node, "pre-prepare", replica.handlePrePrepare)
+	node.Register(&replica.Node, "prepare", replica.handlePrepare)
+	node.Register(&replica.Node, "commit", replica.handleCommit)
+	node.Register(&replica.Node, "reply", replica.handleReply)
+	node.Register(&replica.Node, "checkpoint", replica.handleCheckpoint)
+	replica.Operations["add"] = replica.handleAdd
+	node.Register(&replica.Node, "setF", replica.handleSetF)
+	node.RegisterReply(&replica.Node, "client", replica.handleClient)
+	node.Register(&replica.Node, "alloc", replica.handleAlloc)
+	node.Register(&replica.Node, "byzantine", replica.handleByzantine)
+	replica.Operations[mempool.GossipOperation] = replica.Mempool.HandleTx(replica.Logger, mempool.Derive(toTx))
+	replica.Control("add", "setF", "alloc", "byzantine")
+}
+
+// Start the alarm trigger once the node is registered.
+func (node *Node) Start() {
+	node.Every(ResolvingTimeDuration, node.alarmToDispatcher)
+}
 
-	return node
+// Stop ends the alarm and the resolver, then closes the ledger.
+func (node *Node) Stop() {
+	node.Node.Stop()
+	node.Ledger.Close()
 }
 
-// Factory TODO: create node with reflection
+// Factory makes pbft nodes, it is registered as "pbft".
 type Factory struct {
 	Name string
+	// broadcast by gossip instead of to every peer directly, when set
+	Gossip *gossip.Config
 }
 
-func (factory Factory) NewOperator(id string, sender server.Sender) server.Operator {
-	return NewNode(id, sender)
+func init() {
+	server.RegisterFactory("pbft", Factory{Name: "pbft"})
 }
 
-func (node *Node) StartRequest(operation string) (int64, error) {
-	err := node.Client.Start()
+func (factory Factory) NewOperator(id string, sender server.Sender) (server.Operator, error) {
+	node, err := NewNode(id, sender)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	msg := &RequestMsg{
-		ClientID:  node.ID,
-		Operation: operation,
-		Timestamp: time.Now().Unix(),
+	if factory.Gossip != nil {
+		node.UseGossip(*factory.Gossip)
+	}
+	return node, nil
+}
+
+func (node *Node) StartRequest(operation string, transaction *account.Transaction) (int64, error) {
+	err := node.SendRequest(operation, transaction)
+	if err != nil {
+		return -1, err
 	}
-	node.Println("Start request as Client, time:", node.Client.StartTime)
-	go node.Send(node.ID, node.View.Primary, "req", msg)
 
 	select {
 	case delay := <-node.Client.Msg:
 		node.Printf("Request Finished! Delay: %d", delay)
-		node.Println(node.Client.StartTime, time.Now().UnixMicro())
+		node.Println(node.Client.StartTime, node.Now().UnixMicro())
 		return delay, nil
 	}
 }
 
+// SendRequest starts a request as client without waiting, the delay arrives at Client.Msg.
+func (node *Node) SendRequest(operation string, transaction *account.Transaction) error {
+	err := node.Client.Start(node.Now())
+	if err != nil {
+		return err
+	}
+	msg := &RequestMsg{
+		ClientID:    node.ID,
+		Operation:   operation,
+		Transaction: transaction,
+		// Nanoseconds keep requests of one client distinct in the mempool.
+		Timestamp: node.Now().UnixNano(),
+	}
+	node.Println("Start request as Client, time:", node.Client.StartTime)
+	node.Go(func() {
+		node.Send(node.ID, node.View.Primary, "req", msg)
+	})
+	return nil
+}
+
 func (node *Node) GetReply(msg *ReplyMsg) {
 	node.Printf("Result: %s by %s\n", msg.Result, msg.NodeID)
-	node.Client.Count++
-	//fmt.Println(node.Client.Count)
-	if node.Client.Count > node.f {
-		node.Client.End()
-	}
+	node.Client.Reply(node.f, node.Now())
 }
 
 func (node *Node) Reply(msg *ReplyMsg) {
-	// Print all committed messages.
-	for _, value := range node.CommittedMsgs {
-		node.Printf("Committed value: %s, %d, %s, %d", value.ClientID, value.Timestamp, value.Operation, value.SequenceID)
-	}
-	node.Println()
+	// Print the committed block.
+	head := node.head()
+	node.Printf("Committed block: %d, %s, %s, %d", head.Height, head.Hash, head.Proposer, head.Certificate.Sequence)
 
 	// send reply msg to the Client
-	go node.Send(node.ID, msg.ClientID, "reply", msg)
+	node.Go(func() {
+		node.Send(node.ID, msg.ClientID, "reply", msg)
+	})
 	node.Println("Reply Finished!")
 }
 
 // GetReq can be called when the node's CurrentState is nil.
 // Consensus start procedure for the Primary.
-func (node *Node) GetReq(reqMsg *RequestMsg) error {
-	log2.LogMsg(reqMsg)
+func (node *Node) GetReq(reqMsgs []*RequestMsg) error {
+	log2.LogMsg(reqMsgs)
 
 	// Create a new state for the new consensus.
 	err := node.createStateForNewConsensus()
@@ -152,7 +218,7 @@ func (node *Node) GetReq(reqMsg *RequestMsg) error {
 	}
 
 	// Start the consensus process.
-	prePrepareMsg, err := node.CurrentState.StartConsensus(reqMsg)
+	prePrepareMsg, err := node.CurrentState.StartConsensus(reqMsgs)
 	if err != nil {
 		return err
 	}
@@ -161,7 +227,9 @@ func (node *Node) GetReq(reqMsg *RequestMsg) error {
 
 	// Send handlePrePrepare message
 	if prePrepareMsg != nil {
-		go node.Broadcast(node.ID, "pre-prepare", prePrepareMsg)
+		node.Go(func() {
+			node.Broadcast(node.ID, "pre-prepare", prePrepareMsg)
+		})
 		log2.LogStage("Pre-prepare", true)
 	}
 
@@ -187,9 +255,13 @@ func (node *Node) GetPrePrepare(prePrepareMsg *PrePrepareMsg) error {
 	if prePareMsg != nil {
 		// Attach node ID to the message
 		prePareMsg.NodeID = node.ID
+		// A backup counts its own prepare towards the 2f prepares, as in the paper.
+		node.CurrentState.MsgLogs.PrepareMsgs[node.ID] = prePareMsg
 
 		log2.LogStage("Pre-prepare", true)
-		go node.Broadcast(node.ID, "prepare", prePareMsg)
+		node.Go(func() {
+			node.Broadcast(node.ID, "prepare", prePareMsg)
+		})
 		log2.LogStage("Prepare", false)
 	}
 
@@ -209,7 +281,9 @@ func (node *Node) GetPrepare(prepareMsg *VoteMsg) error {
 		commitMsg.NodeID = node.ID
 
 		log2.LogStage("Prepare", true)
-		go node.Broadcast(node.ID, "commit", commitMsg)
+		node.Go(func() {
+			node.Broadcast(node.ID, "commit", commitMsg)
+		})
 		log2.LogStage("Commit", false)
 	}
 
@@ -223,24 +297,33 @@ func (node *Node) GetCommit(commitMsg *VoteMsg) error {
 	//util.LogMsg(commitMsg)
 	//fmt.Println(node.ID)
 	//fmt.Println(node.CurrentState)
-	replyMsg, committedMsg, err := node.CurrentState.Commit(commitMsg)
+	replyMsgs, committedMsgs, err := node.CurrentState.Commit(commitMsg)
 	if err != nil {
 		return err
 	}
 
-	if replyMsg != nil {
-		if committedMsg == nil {
+	if replyMsgs != nil {
+		if committedMsgs == nil {
 			return errors.New("committed message is nil, even though the reply message is not nil")
 		}
 
-		// Attach node ID to the message
-		replyMsg.NodeID = node.ID
-
-		// Save the last version of committed messages to node.
-		node.CommittedMsgs = append(node.CommittedMsgs, committedMsg)
+		// Save the committed messages to node as a new block.
+		err = node.appendBlock(committedMsgs)
+		if err != nil {
+			return err
+		}
+		node.removeCommitted(committedMsgs)
+		node.execute(committedMsgs, replyMsgs)
+		if err = node.checkpoint(); err != nil {
+			node.Println(err)
+		}
 
 		log2.LogStage("Commit", true)
-		node.Reply(replyMsg)
+		for _, replyMsg := range replyMsgs {
+			// Attach node ID to the message
+			replyMsg.NodeID = node.ID
+			node.Reply(replyMsg)
+		}
 		log2.LogStage("Reply", true)
 		node.CurrentState = nil
 	}
@@ -257,28 +340,188 @@ func (node *Node) createStateForNewConsensus() error {
 
 	// Get the last sequence ID
 	var lastSequenceID int64
-	if len(node.CommittedMsgs) == 0 {
+	if head := node.head(); head.Certificate == nil {
 		lastSequenceID = -1
 	} else {
-		lastSequenceID = node.CommittedMsgs[len(node.CommittedMsgs)-1].SequenceID
+		lastSequenceID = head.Certificate.Sequence
 	}
 
 	// Create a new state for this new consensus process in the Primary
 	node.CurrentState = CreateState(node.View.ID, lastSequenceID, node.f, node.ff)
+	node.CurrentState.clock = node.Now
 
 	log2.LogStage("Create the replica status", true)
 
 	return nil
 }
 
+func (node *Node) head() *block.Block {
+	return node.Ledger.Head()
+}
+
+// appendBlock chains the committed requests, certified by the commit votes of the current state.
+func (node *Node) appendBlock(reqMsgs []*RequestMsg) error {
+	txs, err := block.Encode(reqMsgs)
+	if err != nil {
+		return err
+	}
+	// Timestamp and proposer come from the requests so that all replicas build the same block.
+	var timestamp int64
+	for _, reqMsg := range reqMsgs {
+		if reqMsg.Timestamp > timestamp {
+			timestamp = reqMsg.Timestamp
+		}
+	}
+	newBlock, err := block.NewBlock(node.head(), timestamp, node.View.Primary, txs, nil)
+	if err != nil {
+		return err
+	}
+	reqDigest, err := digest(reqMsgs)
+	if err != nil {
+		return err
+	}
+	signers := make([]string, 0, len(node.CurrentState.MsgLogs.CommitMsgs))
+	for signer := range node.CurrentState.MsgLogs.CommitMsgs {
+		signers = append(signers, signer)
+	}
+	sort.Strings(signers)
+	newBlock.Certificate = &block.Certificate{
+		View:     node.CurrentState.ViewID,
+		Sequence: reqMsgs[0].SequenceID,
+		Digest:   reqDigest,
+		Signers:  signers,
+	}
+	return node.Ledger.Append(newBlock)
+}
+
+// checkpoint announces the digest of the last CheckpointPeriod blocks once the head reaches a checkpoint height.
+func (node *Node) checkpoint() error {
+	height := node.head().Height
+	if height%CheckpointPeriod != 0 {
+		return nil
+	}
+	blocks := make([]*block.Block, 0, CheckpointPeriod)
+	err := node.Ledger.Range(height-CheckpointPeriod+1, height, func(b *block.Block) bool {
+		blocks = append(blocks, b)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	msg := &CheckpointMsg{Height: height, Digest: block.Checkpoint(blocks), NodeID: node.ID}
+	node.recordCheckpoint(msg)
+	node.Go(func() {
+		node.Broadcast(node.ID, "checkpoint", msg)
+	})
+	return nil
+}
+
+// recordCheckpoint counts the digest of msg, the checkpoint becomes stable
+// when 2f+1 replicas, this one included, announced the same digest.
+func (node *Node) recordCheckpoint(msg *CheckpointMsg) {
+	if node.Stable != nil && msg.Height <= node.Stable.Height {
+		return
+	}
+	digests, ok := node.checkpoints[msg.Height]
+	if !ok {
+		digests = make(map[string]string)
+		node.checkpoints[msg.Height] = digests
+	}
+	digests[msg.NodeID] = msg.Digest
+
+	own, ok := digests[node.ID]
+	if !ok {
+		return
+	}
+	agreed := 0
+	for _, digest := range digests {
+		if digest == own {
+			agreed++
+		}
+	}
+	if agreed <= node.ff {
+		return
+	}
+	node.Stable = &CheckpointMsg{Height: msg.Height, Digest: own, NodeID: node.ID}
+	for height := range node.checkpoints {
+		if height <= msg.Height {
+			delete(node.checkpoints, height)
+		}
+	}
+	node.Printf("Stable checkpoint: %d, %s", msg.Height, own)
+}
+
+// toTx wraps a request as it arrives from the client, before the primary assigns a sequence ID.
+// Requests carrying a transaction are ordered by the account nonce and prioritized by fee.
+func toTx(reqMsg *RequestMsg) (*mempool.Tx, error) {
+	arrived := *reqMsg
+	arrived.SequenceID = 0
+	if tx := arrived.Transaction; tx != nil {
+		return tx.ToMempool(&arrived)
+	}
+	return mempool.NewTx(arrived.ClientID, arrived.Timestamp, 0, &arrived)
+}
+
+// execute applies the transactions of the committed requests and puts the outcome in their replies.
+func (node *Node) execute(reqMsgs []*RequestMsg, replyMsgs []*ReplyMsg) {
+	for i, reqMsg := range reqMsgs {
+		if reqMsg.Transaction == nil {
+			continue
+		}
+		if err := node.State.Apply(reqMsg.Transaction, node.View.Primary); err != nil {
+			replyMsgs[i].Result = err.Error()
+		}
+	}
+}
+
+// replay rebuilds the account state from the blocks kept in the ledger.
+func (node *Node) replay() error {
+	return node.Ledger.Range(1, node.Ledger.Height(), func(b *block.Block) bool {
+		for _, raw := range b.Transactions {
+			var reqMsg RequestMsg
+			if err := json.Unmarshal(raw, &reqMsg); err == nil && reqMsg.Transaction != nil {
+				_ = node.State.Apply(reqMsg.Transaction, b.Proposer)
+			}
+		}
+		return true
+	})
+}
+
+func (node *Node) removeCommitted(reqMsgs []*RequestMsg) {
+	hashes := make([]string, 0, len(reqMsgs))
+	for _, reqMsg := range reqMsgs {
+		tx, err := toTx(reqMsg)
+		if err != nil {
+			node.Println(err)
+			continue
+		}
+		hashes = append(hashes, tx.Hash)
+	}
+	node.Mempool.Remove(hashes...)
+}
+
+// pullBatch takes the next batch of requests from the mempool if this node is the primary.
+func (node *Node) pullBatch() []*RequestMsg {
+	if node.View.Primary != node.ID {
+		return nil
+	}
+	txs := node.Mempool.Batch(MaxBatchCount, MaxBatchBytes)
+	if len(txs) == 0 {
+		return nil
+	}
+	reqMsgs, err := mempool.Decode[RequestMsg](txs)
+	if err != nil {
+		node.Println(err)
+		return nil
+	}
+	return reqMsgs
+}
+
 func (node *Node) routeMsgWhenAlarmed() []error {
 	if node.CurrentState == nil {
-		// Check ReqMsgs, send them.
-		if len(node.MsgBuffer.ReqMsgs) != 0 {
-			msgs := make([]*RequestMsg, len(node.MsgBuffer.ReqMsgs))
-			copy(msgs, node.MsgBuffer.ReqMsgs)
-
-			node.MsgDelivery <- msgs
+		// Check the mempool, propose a batch.
+		if msgs := node.pullBatch(); msgs != nil {
+			node.resolveMsg(msgs)
 		}
 
 		// Check PrePrepareMsgs, send them.
@@ -286,7 +529,7 @@ func (node *Node) routeMsgWhenAlarmed() []error {
 			msgs := make([]*PrePrepareMsg, len(node.MsgBuffer.PrePrepareMsgs))
 			copy(msgs, node.MsgBuffer.PrePrepareMsgs)
 
-			node.MsgDelivery <- msgs
+			node.resolveMsg(msgs)
 		}
 	} else {
 		switch node.CurrentState.CurrentStage {
@@ -296,7 +539,7 @@ func (node *Node) routeMsgWhenAlarmed() []error {
 				msgs := make([]*VoteMsg, len(node.MsgBuffer.PrepareMsgs))
 				copy(msgs, node.MsgBuffer.PrepareMsgs)
 
-				node.MsgDelivery <- msgs
+				node.resolveMsg(msgs)
 			}
 		case Prepared:
 			// Check CommitMsgs, send them.
@@ -304,7 +547,7 @@ func (node *Node) routeMsgWhenAlarmed() []error {
 				msgs := make([]*VoteMsg, len(node.MsgBuffer.CommitMsgs))
 				copy(msgs, node.MsgBuffer.CommitMsgs)
 
-				node.MsgDelivery <- msgs
+				node.resolveMsg(msgs)
 			}
 		}
 	}
@@ -312,77 +555,65 @@ func (node *Node) routeMsgWhenAlarmed() []error {
 	return nil
 }
 
-var mutex sync.Mutex
+func (node *Node) resolveMsg(msgs interface{}) {
+	switch msgs.(type) {
+	case []*RequestMsg:
+		errs := node.resolveRequestMsg(msgs.([]*RequestMsg))
+		if len(errs) != 0 {
+			for _, err := range errs {
+				node.Println(err)
+			}
+			// TODO: send err to ErrorChannel
+		}
+	case []*PrePrepareMsg:
+		errs := node.resolvePrePrepareMsg(msgs.([]*PrePrepareMsg))
+		if len(errs) != 0 {
+			for _, err := range errs {
+				node.Println(err)
+			}
+			// TODO: send err to ErrorChannel
+		}
+	case []*VoteMsg:
+		voteMsgs := msgs.([]*VoteMsg)
+		if len(voteMsgs) == 0 {
+			break
+		}
 
-func (node *Node) resolveMsg() {
-	for {
-		// Get buffered messages from the dispatcher.
-		//mutex.Lock()
-		msgs := <-node.MsgDelivery
-		switch msgs.(type) {
-		case []*RequestMsg:
-			errs := node.resolveRequestMsg(msgs.([]*RequestMsg))
+		if voteMsgs[0].MsgType == PrepareMsg {
+			errs := node.resolvePrepareMsg(voteMsgs)
 			if len(errs) != 0 {
 				for _, err := range errs {
-					fmt.Println(err)
+					node.Println(err)
 				}
 				// TODO: send err to ErrorChannel
 			}
-		case []*PrePrepareMsg:
-			errs := node.resolvePrePrepareMsg(msgs.([]*PrePrepareMsg))
+		} else if voteMsgs[0].MsgType == CommitMsg {
+			errs := node.resolveCommitMsg(voteMsgs)
 			if len(errs) != 0 {
 				for _, err := range errs {
-					fmt.Println(err)
+					node.Println(err)
 				}
 				// TODO: send err to ErrorChannel
 			}
-		case []*VoteMsg:
-			voteMsgs := msgs.([]*VoteMsg)
-			if len(voteMsgs) == 0 {
-				break
-			}
-
-			if voteMsgs[0].MsgType == PrepareMsg {
-				errs := node.resolvePrepareMsg(voteMsgs)
-				if len(errs) != 0 {
-					for _, err := range errs {
-						fmt.Println(err)
-					}
-					// TODO: send err to ErrorChannel
-				}
-			} else if voteMsgs[0].MsgType == CommitMsg {
-				errs := node.resolveCommitMsg(voteMsgs)
-				if len(errs) != 0 {
-					for _, err := range errs {
-						fmt.Println(err)
-					}
-					// TODO: send err to ErrorChannel
-				}
-			}
 		}
-		//mutex.Unlock()
 	}
 }
 
 func (node *Node) alarmToDispatcher() {
-	for {
-		time.Sleep(ResolvingTimeDuration)
-		err := node.routeMsgWhenAlarmed()
-		if err != nil {
-			node.Println(err)
+	node.deliver(func() {
+		if errs := node.routeMsgWhenAlarmed(); errs != nil {
+			node.Println(errs)
 		}
-	}
+	})
 }
 
 func (node *Node) resolveRequestMsg(msgs []*RequestMsg) []error {
 	errs := make([]error, 0)
 
-	// Resolve messages
-	for _, reqMsg := range msgs {
-		err := node.GetReq(reqMsg)
-		if err != nil {
-			errs = append(errs, err)
-		}
+	// Resolve the batch
+	err := node.GetReq(msgs)
+	if err != nil {
+		errs = append(errs, err)
 	}
 
 	if len(errs) != 0 {
@@ -446,136 +677,134 @@ func (node *Node) resolveCommitMsg(msgs []*VoteMsg) []error {
 	return nil
 }
 
-func (node *Node) handleRequest(_ http.ResponseWriter, request *http.Request) {
-	var msg RequestMsg
-	err := json.NewDecoder(request.Body).Decode(&msg)
-	if err != nil {
-		node.Println(err)
-		return
+func (node *Node) handleRequest(_ string, msg *RequestMsg) error {
+	// Reject transactions which can never be applied.
+	if msg.Transaction != nil && msg.Transaction.Nonce < node.State.Nonce(msg.Transaction.From) {
+		return account.ErrBadNonce
 	}
 
-	if node.CurrentState == nil {
-		// Copy buffered messages first.
-		msgs := make([]*RequestMsg, len(node.MsgBuffer.ReqMsgs))
-		copy(msgs, node.MsgBuffer.ReqMsgs)
-
-		// Append a newly arrived message.
-		msgs = append(msgs, &msg)
-
-		// Empty the buffer.
-		node.MsgBuffer.ReqMsgs = make([]*RequestMsg, 0)
-
-		// Send messages.
-		node.MsgDelivery <- msgs
-	} else {
-		node.MsgBuffer.ReqMsgs = append(node.MsgBuffer.ReqMsgs, &msg)
-	}
-}
-
-func (node *Node) handlePrePrepare(_ http.ResponseWriter, request *http.Request) {
-	var msg PrePrepareMsg
-	err := json.NewDecoder(request.Body).Decode(&msg)
+	// Keep the request in the mempool and share it with the other replicas.
+	tx, err := toTx(msg)
 	if err != nil {
+		return err
+	}
+	for _, err := range node.Mempool.Submit(node, node.ID, tx) {
 		node.Println(err)
-		return
 	}
 
-	if node.CurrentState == nil {
-		// Copy buffered messages first.
-		msgs := make([]*PrePrepareMsg, len(node.MsgBuffer.PrePrepareMsgs))
-		copy(msgs, node.MsgBuffer.PrePrepareMsgs)
+	node.deliver(func() {
+		if node.CurrentState == nil {
+			// Send a batch at once.
+			if msgs := node.pullBatch(); msgs != nil {
+				node.resolveMsg(msgs)
+			}
+		}
+	})
+	return nil
+}
 
-		// Append a newly arrived message.
-		msgs = append(msgs, &msg)
+func (node *Node) handlePrePrepare(_ string, msg *PrePrepareMsg) error {
+	node.deliver(func() {
+		if node.CurrentState == nil {
+			// Copy buffered messages first.
+			msgs := make([]*PrePrepareMsg, len(node.MsgBuffer.PrePrepareMsgs))
+			copy(msgs, node.MsgBuffer.PrePrepareMsgs)
 
-		// Empty the buffer.
-		node.MsgBuffer.PrePrepareMsgs = make([]*PrePrepareMsg, 0)
+			// Append a newly arrived message.
+			msgs = append(msgs, msg)
 
-		// Send messages.
-		node.MsgDelivery <- msgs
-	} else {
-		node.MsgBuffer.PrePrepareMsgs = append(node.MsgBuffer.PrePrepareMsgs, &msg)
-	}
-}
+			// Empty the buffer.
+			node.MsgBuffer.PrePrepareMsgs = make([]*PrePrepareMsg, 0)
 
-func (node *Node) handlePrepare(_ http.ResponseWriter, request *http.Request) {
-	var msg VoteMsg
-	err := json.NewDecoder(request.Body).Decode(&msg)
-	if err != nil {
-		node.Println(err)
-		return
-	}
+			// Resolve messages.
+			node.resolveMsg(msgs)
+		} else {
+			node.MsgBuffer.PrePrepareMsgs = append(node.MsgBuffer.PrePrepareMsgs, msg)
+		}
+	})
+	return nil
+}
 
-	if node.CurrentState == nil || node.CurrentState.CurrentStage != PrePrepared {
-		node.MsgBuffer.PrepareMsgs = append(node.MsgBuffer.PrepareMsgs, &msg)
-	} else {
-		// Copy buffered messages first.
-		msgs := make([]*VoteMsg, len(node.MsgBuffer.PrepareMsgs))
-		copy(msgs, node.MsgBuffer.PrepareMsgs)
+func (node *Node) handlePrepare(_ string, msg *VoteMsg) error {
+	node.deliver(func() {
+		if node.CurrentState == nil || node.CurrentState.CurrentStage != PrePrepared {
+			node.MsgBuffer.PrepareMsgs = append(node.MsgBuffer.PrepareMsgs, msg)
+		} else {
+			// Copy buffered messages first.
+			msgs := make([]*VoteMsg, len(node.MsgBuffer.PrepareMsgs))
+			copy(msgs, node.MsgBuffer.PrepareMsgs)
 
-		// Append a newly arrived message.
-		msgs = append(msgs, &msg)
+			// Append a newly arrived message.
+			msgs = append(msgs, msg)
 
-		// Empty the buffer.
-		node.MsgBuffer.PrepareMsgs = make([]*VoteMsg, 0)
+			// Empty the buffer.
+			node.MsgBuffer.PrepareMsgs = make([]*VoteMsg, 0)
 
-		// Send messages.
-		node.MsgDelivery <- msgs
-	}
+			// Resolve messages.
+			node.resolveMsg(msgs)
+		}
+	})
+	return nil
 }
 
-func (node *Node) handleCommit(_ http.ResponseWriter, request *http.Request) {
-	var msg VoteMsg
-	err := json.NewDecoder(request.Body).Decode(&msg)
-	if err != nil {
-		node.Println(err)
-		return
-	}
+func (node *Node) handleCommit(_ string, msg *VoteMsg) error {
+	node.deliver(func() {
+		if node.CurrentState == nil || node.CurrentState.CurrentStage != Prepared {
+			node.MsgBuffer.CommitMsgs = append(node.MsgBuffer.CommitMsgs, msg)
+		} else {
+			// Copy buffered messages first.
+			msgs := make([]*VoteMsg, len(node.MsgBuffer.CommitMsgs))
+			copy(msgs, node.MsgBuffer.CommitMsgs)
 
-	if node.CurrentState == nil || node.CurrentState.CurrentStage != Prepared {
-		node.MsgBuffer.CommitMsgs = append(node.MsgBuffer.CommitMsgs, &msg)
-	} else {
-		// Copy buffered messages first.
-		msgs := make([]*VoteMsg, len(node.MsgBuffer.CommitMsgs))
-		copy(msgs, node.MsgBuffer.CommitMsgs)
+			// Append a newly arrived message.
+			msgs = append(msgs, msg)
 
-		// Append a newly arrived message.
-		msgs = append(msgs, &msg)
+			// Empty the buffer.
+			node.MsgBuffer.CommitMsgs = make([]*VoteMsg, 0)
 
-		// Empty the buffer.
-		node.MsgBuffer.CommitMsgs = make([]*VoteMsg, 0)
+			// Resolve messages.
+			node.resolveMsg(msgs)
+		}
+	})
+	return nil
+}
 
-		// Send messages.
-		node.MsgDelivery <- msgs
-	}
+func (node *Node) handleReply(_ string, msg *ReplyMsg) error {
+	node.deliver(func() {
+		node.GetReply(msg)
+	})
+	return nil
 }
 
-func (node *Node) handleReply(_ http.ResponseWriter, request *http.Request) {
-	var msg ReplyMsg
-	err := json.NewDecoder(request.Body).Decode(&msg)
-	if err != nil {
-		node.Println(err)
-		return
+func (node *Node) handleCheckpoint(from string, msg *CheckpointMsg) error {
+	if from != msg.NodeID {
+		return fmt.Errorf("checkpoint of %s sent by %s", msg.NodeID, from)
 	}
-
-	node.GetReply(&msg)
+	node.deliver(func() {
+		node.recordCheckpoint(msg)
+	})
+	return nil
 }
 
 func (node *Node) handleAdd(_ http.ResponseWriter, _ *http.Request) {
-	node.SetF(node.total + 1)
+	node.deliver(func() {
+		node.setF(node.total + 1)
+	})
 }
 
-func (node *Node) handleSetF(_ http.ResponseWriter, request *http.Request) {
-	var msg SetFMsg
-	err := json.NewDecoder(request.Body).Decode(&msg)
-	if err != nil {
-		node.Println(err)
-		return
-	}
+func (node *Node) handleSetF(_ string, msg *SetFMsg) error {
 	node.SetF(msg.Total)
+	return nil
 }
 
+// SetF sets the number of replicas, f and 2f follow from it.
 func (node *Node) SetF(total int) {
+	node.deliver(func() {
+		node.setF(total)
+	})
+}
+
+func (node *Node) setF(total int) {
 	node.total = total
 	node.f = node.getF(total)
 	node.ff = node.getFF(total)
@@ -592,19 +821,42 @@ func (node *Node) getFF(total int) int {
 	return int(math.Ceil(ff))
 }
 
-func (node *Node) handleClient(writer http.ResponseWriter, request *http.Request) {
-	var msg ClientMsg
-	err := json.NewDecoder(request.Body).Decode(&msg)
-	if err != nil {
-		node.Println(err)
-		return
+// handleClient answers the delay of a request started as client. A node which can not wait only starts it,
+// the delay arrives at Client.Msg and the reply carries -1.
+func (node *Node) handleClient(_ string, msg *ClientMsg) (*ClientMsg, error) {
+	if !node.CanWait() {
+		if err := node.SendRequest("Test", msg.Transaction); err != nil {
+			return nil, err
+		}
+		msg.Delay = -1
+		return msg, nil
 	}
-	delay, err2 := node.StartRequest("Test")
-	if err2 != nil {
-		node.Println(err)
-		return
+	delay, err := node.StartRequest("Test", msg.Transaction)
+	if err != nil {
+		return nil, err
 	}
 	msg.Delay = delay
-	jsonMessage, _ := json.Marshal(msg)
-	writer.Write(jsonMessage)
+	return msg, nil
+}
+
+func (node *Node) handleAlloc(_ string, msg *AllocMsg) error {
+	node.deliver(func() {
+		node.State.Reset(msg.Balances)
+		if err := node.replay(); err != nil {
+			node.Println(err)
+		}
+	})
+	return nil
+}
+
+func (node *Node) handleByzantine(_ string, msg *ByzantineMsg) error {
+	if _, ok := Strategies[msg.Strategy]; !ok {
+		return fmt.Errorf("unknown byzantine strategy %s", msg.Strategy)
+	}
+	node.deliver(func() {
+		if err := MakeByzantine(node, msg.Strategy); err != nil {
+			node.Println(err)
+		}
+	})
+	return nil
 }
@@ -13,13 +13,17 @@ type State struct {
 	ViewID         int64
 	MsgLogs        *MsgLogs
 	LastSequenceID int64
-	CurrentStage   Stage
-	f              int
-	ff             int
+	// sequence ID of the round, set once its requests are pre-prepared
+	SequenceID   int64
+	CurrentStage Stage
+	f            int
+	ff           int
+	// clock of the node, giving the first sequence ID
+	clock func() time.Time
 }
 
 type MsgLogs struct {
-	ReqMsg      *RequestMsg
+	ReqMsgs     []*RequestMsg
 	PrepareMsgs map[string]*VoteMsg
 	CommitMsgs  map[string]*VoteMsg
 	ReplyMsgs   map[string]*ReplyMsg
@@ -43,7 +47,7 @@ func CreateState(viewID int64, lastSequenceID int64, f int, ff int) *State {
 	return &State{
 		ViewID: viewID,
 		MsgLogs: &MsgLogs{
-			ReqMsg:      nil,
+			ReqMsgs:     nil,
 			PrepareMsgs: make(map[string]*VoteMsg),
 			CommitMsgs:  make(map[string]*VoteMsg),
 			ReplyMsgs:   make(map[string]*ReplyMsg),
@@ -52,12 +56,13 @@ func CreateState(viewID int64, lastSequenceID int64, f int, ff int) *State {
 		CurrentStage:   Idle,
 		f:              f,
 		ff:             ff,
+		clock:          time.Now,
 	}
 }
 
-func (state *State) StartConsensus(request *RequestMsg) (*PrePrepareMsg, error) {
+func (state *State) StartConsensus(requests []*RequestMsg) (*PrePrepareMsg, error) {
 	// `sequenceID` will be the index of this message.
-	sequenceID := time.Now().UnixNano()
+	sequenceID := state.clock().UnixNano()
 
 	// Find the unique and largest number for the sequence ID
 	if state.LastSequenceID != -1 {
@@ -67,33 +72,35 @@ func (state *State) StartConsensus(request *RequestMsg) (*PrePrepareMsg, error)
 		//}
 	}
 
-	// Assign a new sequence ID to the request message object.
-	request.SequenceID = sequenceID
+	// Assign a new sequence ID to the request message objects.
+	for _, request := range requests {
+		request.SequenceID = sequenceID
+	}
 
 	// Save ReqMsgs to its logs.
-	state.MsgLogs.ReqMsg = request
+	state.MsgLogs.ReqMsgs = requests
 
-	// Get the digest of the request message
-	digest, err := digest(request)
+	// Get the digest of the request messages
+	digest, err := digest(requests)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
 	// Change the stage to pre-prepared.
+	state.SequenceID = sequenceID
 	state.CurrentStage = PrePrepared
 
 	return &PrePrepareMsg{
-		ViewID:     state.ViewID,
-		SequenceID: sequenceID,
-		Digest:     digest,
-		RequestMsg: request,
+		ViewID:      state.ViewID,
+		SequenceID:  sequenceID,
+		Digest:      digest,
+		RequestMsgs: requests,
 	}, nil
 }
 
 func (state *State) PrePrepare(prePrepareMsg *PrePrepareMsg) (*VoteMsg, error) {
 	// Get ReqMsgs and save it to its logs like the primary.
-	state.MsgLogs.ReqMsg = prePrepareMsg.RequestMsg
+	state.MsgLogs.ReqMsgs = prePrepareMsg.RequestMsgs
 
 	// Verify if v, n(a.k.a. sequenceID), d are correct.
 	if !state.verifyMsg(prePrepareMsg.ViewID, prePrepareMsg.SequenceID, prePrepareMsg.Digest) {
@@ -101,6 +108,7 @@ func (state *State) PrePrepare(prePrepareMsg *PrePrepareMsg) (*VoteMsg, error) {
 	}
 
 	// Change the stage to pre-prepared.
+	state.SequenceID = prePrepareMsg.SequenceID
 	state.CurrentStage = PrePrepared
 
 	return &VoteMsg{
@@ -112,6 +120,9 @@ func (state *State) PrePrepare(prePrepareMsg *PrePrepareMsg) (*VoteMsg, error) {
 }
 
 func (state *State) Prepare(prepareMsg *VoteMsg) (*VoteMsg, error) {
+	if err := state.verifyRound(prepareMsg); err != nil {
+		return nil, err
+	}
 	if !state.verifyMsg(prepareMsg.ViewID, prepareMsg.SequenceID, prepareMsg.Digest) {
 		return nil, errors.New("prepare message is corrupted")
 	}
@@ -137,7 +148,10 @@ func (state *State) Prepare(prepareMsg *VoteMsg) (*VoteMsg, error) {
 	return nil, nil
 }
 
-func (state *State) Commit(commitMsg *VoteMsg) (*ReplyMsg, *RequestMsg, error) {
+func (state *State) Commit(commitMsg *VoteMsg) ([]*ReplyMsg, []*RequestMsg, error) {
+	if err := state.verifyRound(commitMsg); err != nil {
+		return nil, nil, err
+	}
 	if !state.verifyMsg(commitMsg.ViewID, commitMsg.SequenceID, commitMsg.Digest) {
 		return nil, nil, errors.New("commit message is corrupted")
 	}
@@ -155,48 +169,62 @@ func (state *State) Commit(commitMsg *VoteMsg) (*ReplyMsg, *RequestMsg, error) {
 		// Change the stage to prepared.
 		state.CurrentStage = Committed
 
-		return &ReplyMsg{
-			ViewID:    state.ViewID,
-			Timestamp: state.MsgLogs.ReqMsg.Timestamp,
-			ClientID:  state.MsgLogs.ReqMsg.ClientID,
-			Result:    result,
-		}, state.MsgLogs.ReqMsg, nil
+		// Every client in the batch gets its own reply.
+		replyMsgs := make([]*ReplyMsg, 0, len(state.MsgLogs.ReqMsgs))
+		for _, reqMsg := range state.MsgLogs.ReqMsgs {
+			replyMsgs = append(replyMsgs, &ReplyMsg{
+				ViewID:    state.ViewID,
+				Timestamp: reqMsg.Timestamp,
+				ClientID:  reqMsg.ClientID,
+				Result:    result,
+			})
+		}
+		return replyMsgs, state.MsgLogs.ReqMsgs, nil
 	}
 
 	return nil, nil, nil
 }
 
+// verifyRound drops the votes of other rounds, before their digest is checked against the requests of this one.
+func (state *State) verifyRound(voteMsg *VoteMsg) error {
+	if state.CurrentStage == Idle || voteMsg.SequenceID != state.SequenceID {
+		return fmt.Errorf("vote of %s for sequence %d is not of the round %d", voteMsg.NodeID, voteMsg.SequenceID, state.SequenceID)
+	}
+	return nil
+}
+
+// verifyMsg rejects messages of another view, replays of old sequences and votes for other requests,
+// so faulty replicas can not make honest ones commit different blocks.
 func (state *State) verifyMsg(viewID int64, sequenceID int64, digestGot string) bool {
+	// Wrong view. That is, wrong configurations of peers to start the consensus.
+	if state.ViewID != viewID {
+		return false
+	}
+
+	// Check if the Primary sent fault sequence number. => Faulty primary.
+	// TODO: adopt upper/lower bound check.
+	if state.LastSequenceID != -1 {
+		if state.LastSequenceID >= sequenceID {
+			return false
+		}
+	}
+
+	digest, err := digest(state.MsgLogs.ReqMsgs)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	// Check digest.
+	if digestGot != digest {
+		return false
+	}
+
 	return true
-	//// Wrong view. That is, wrong configurations of peers to start the consensus.
-	//if state.ViewID != viewID {
-	//	return false
-	//}
-	//
-	//// Check if the Primary sent fault sequence number. => Faulty primary.
-	//// TODO: adopt upper/lower bound check.
-	//if state.LastSequenceID != -1 {
-	//	if state.LastSequenceID >= sequenceID {
-	//		return false
-	//	}
-	//}
-	//
-	//digest, err := digest(state.MsgLogs.ReqMsg)
-	//if err != nil {
-	//	fmt.Println(err)
-	//	return false
-	//}
-	//
-	//// Check digest.
-	//if digestGot != digest {
-	//	return false
-	//}
-	//
-	//return true
 }
 
 func (state *State) prepared() bool {
-	if state.MsgLogs.ReqMsg == nil {
+	if len(state.MsgLogs.ReqMsgs) == 0 {
 		return false
 	}
 
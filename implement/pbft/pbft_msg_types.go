@@ -1,10 +1,21 @@
 package pbft
 
+import (
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/account"
+)
+
 type RequestMsg struct {
-	Timestamp  int64  `json:"timestamp"`
-	ClientID   string `json:"clientID"`
-	Operation  string `json:"Operation"`
-	SequenceID int64  `json:"sequenceID"`
+	Timestamp   int64                `json:"timestamp"`
+	ClientID    string               `json:"clientID"`
+	Operation   string               `json:"Operation"`
+	Transaction *account.Transaction `json:"transaction,omitempty"`
+	SequenceID  int64                `json:"sequenceID"`
+}
+
+// Validate rejects transactions whose signature does not hold.
+func (msg *RequestMsg) Validate() error {
+	return msg.Transaction.Validate()
 }
 
 type ReplyMsg struct {
@@ -16,10 +27,10 @@ type ReplyMsg struct {
 }
 
 type PrePrepareMsg struct {
-	ViewID     int64       `json:"viewID"`
-	SequenceID int64       `json:"sequenceID"`
-	Digest     string      `json:"digest"`
-	RequestMsg *RequestMsg `json:"requestMsg"`
+	ViewID      int64         `json:"viewID"`
+	SequenceID  int64         `json:"sequenceID"`
+	Digest      string        `json:"digest"`
+	RequestMsgs []*RequestMsg `json:"requestMsgs"`
 }
 
 type VoteMsg struct {
@@ -42,3 +53,18 @@ const (
 	PrepareMsg MsgType = iota
 	CommitMsg
 )
+
+// CheckpointMsg announces the digest of the blocks a replica committed up to Height.
+type CheckpointMsg struct {
+	Height int64  `json:"height"`
+	Digest string `json:"digest"`
+	NodeID string `json:"nodeID"`
+}
+
+// Validate rejects heights which are not checkpoint heights.
+func (msg *CheckpointMsg) Validate() error {
+	if msg.Height <= 0 || msg.Height%CheckpointPeriod != 0 {
+		return errors.New("not a checkpoint height")
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package pbft
+
+import (
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/parse"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain runs in a temporary directory, nodes write their logs to the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "pbft")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newCluster adds n replicas to a memory network and tells them the total.
+func newCluster(t *testing.T, n int) (*server.Memory, []*Node) {
+	memory := server.NewMemory(Factory{Name: "pbft"})
+	replicas := make([]*Node, 0, n)
+	for i := 1; i <= n; i++ {
+		operator, err := memory.Add(parse.ID2name(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		replicas = append(replicas, operator.(*Node))
+	}
+	memory.Broadcast("center", "setF", SetFMsg{Total: n})
+	t.Cleanup(func() {
+		for _, id := range memory.IDs() {
+			memory.Remove(id)
+		}
+	})
+	return memory, replicas
+}
+
+func request(t *testing.T, memory *server.Memory, to string) int64 {
+	resp, err := memory.Send("center", to, "client", ClientMsg{Operation: "Test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("client request to %s: %s", to, resp.Status)
+	}
+	var msg ClientMsg
+	if err = json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg.Delay
+}
+
+func TestMemory(t *testing.T) {
+	memory, replicas := newCluster(t, 4)
+	commit(t, memory, replicas, 3)
+}
+
+// TestMemoryBinary runs the replicas on the binary codec, all messages of pbft are registered with it.
+func TestMemoryBinary(t *testing.T) {
+	memory, replicas := newCluster(t, 4)
+	memory.UseCodec(codec.Binary)
+	codec.ResetStats()
+	commit(t, memory, replicas, 3)
+	for operation, wires := range codec.Stats() {
+		if _, ok := wires[codec.JSON.Name()]; ok {
+			t.Fatalf("%s is sent as json", operation)
+		}
+	}
+}
+
+// commit sends requests to the replicas in turn, and checks all of them commit the same chain.
+func commit(t *testing.T, memory *server.Memory, replicas []*Node, requests int) {
+	for i := 0; i < requests; i++ {
+		if delay := request(t, memory, replicas[i%len(replicas)].ID); delay <= 0 {
+			t.Fatalf("request %d took %d µs", i, delay)
+		}
+	}
+
+	// A request returns once f+1 replicas replied, the others may still be committing.
+	deadline := time.Now().Add(5 * time.Second)
+	hashes := make([][]string, len(replicas))
+	for i, replica := range replicas {
+		for len(hashes[i]) < requests && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			hashes[i] = chain(replica)
+		}
+		if len(hashes[i]) != requests {
+			t.Fatalf("%s committed %d blocks after %d requests", replica.ID, len(hashes[i]), requests)
+		}
+	}
+	for i := range replicas[1:] {
+		for height, hash := range hashes[i+1] {
+			if hash != hashes[0][height] {
+				t.Fatalf("%s committed %s at height %d, the primary %s", replicas[i+1].ID, hash, height+1, hashes[0][height])
+			}
+		}
+	}
+}
+
+// chain is the hashes of the blocks replica committed, read by its resolver.
+func chain(replica *Node) []string {
+	done := make(chan []string)
+	replica.deliver(func() {
+		hashes := make([]string, 0)
+		for height := int64(1); height <= replica.Ledger.Height(); height++ {
+			b, _ := replica.Ledger.Get(height)
+			hashes = append(hashes, b.Hash)
+		}
+		done <- hashes
+	})
+	return <-done
+}
+
+// TestCheckpointBecomesStable commits a checkpoint period of blocks, every replica then holds the same stable checkpoint.
+func TestCheckpointBecomesStable(t *testing.T) {
+	memory, replicas := newCluster(t, 4)
+	// Requests arriving together share a block, so send until the primary reaches the checkpoint height.
+	for len(chain(replicas[0])) < CheckpointPeriod {
+		request(t, memory, replicas[0].ID)
+	}
+	stable := func(replica *Node) *CheckpointMsg {
+		done := make(chan *CheckpointMsg)
+		replica.deliver(func() {
+			done <- replica.Stable
+		})
+		return <-done
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var digest string
+	for _, replica := range replicas {
+		checkpoint := stable(replica)
+		for ; checkpoint == nil && time.Now().Before(deadline); checkpoint = stable(replica) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if checkpoint == nil || checkpoint.Height != CheckpointPeriod {
+			t.Fatalf("%s holds stable checkpoint %v", replica.ID, checkpoint)
+		}
+		if digest == "" {
+			digest = checkpoint.Digest
+		} else if checkpoint.Digest != digest {
+			t.Fatalf("%s agrees on %s, %s on %s", replica.ID, checkpoint.Digest, replicas[0].ID, digest)
+		}
+	}
+}
+
+// TestMakeByzantineWhileSending turns a replica faulty while it sends, run it with -race.
+func TestMakeByzantineWhileSending(t *testing.T) {
+	memory, replicas := newCluster(t, 4)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			replicas[1].Send(replicas[1].ID, replicas[2].ID, "reply", &ReplyMsg{ClientID: replicas[2].ID})
+		}
+	}()
+	for _, strategy := range []string{"silent", "wrong-digest", "equivocate"} {
+		resp, err := memory.Send("center", replicas[1].ID, "byzantine", ByzantineMsg{Strategy: strategy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("byzantine answered %d", resp.StatusCode)
+		}
+	}
+	<-done
+}
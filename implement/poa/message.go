@@ -0,0 +1,99 @@
+package poa
+
+import (
+	"fmt"
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/codec"
+	"github.com/glimmerzcy/bccp/basic/forkchoice"
+)
+
+type GenesisMsg struct {
+	// unix milliseconds of the genesis block
+	Timestamp int64    `json:"timestamp"`
+	Signers   []string `json:"signers"`
+	// balances of the account state
+	Alloc map[string]uint64 `json:"alloc"`
+	// name of the fork-choice rule, the heaviest chain by difficulty when empty
+	ForkChoice string `json:"forkChoice,omitempty"`
+	// signer to the hex public key its blocks are sealed with, see the key operation
+	Keys map[string]string `json:"keys"`
+}
+
+// Validate rejects fork-choice rules without a name known to forkchoice.RuleByName.
+func (msg *GenesisMsg) Validate() error {
+	if msg.ForkChoice != "" && forkchoice.RuleByName(msg.ForkChoice) == nil {
+		return fmt.Errorf("unknown fork-choice rule %s", msg.ForkChoice)
+	}
+	for _, signer := range msg.Signers {
+		if _, err := publicKey(msg.Keys[signer]); err != nil {
+			return fmt.Errorf("signer %s: %w", signer, err)
+		}
+	}
+	return nil
+}
+
+// ProposeMsg asks a signer to vote for adding (Authorize) or removing Address in the blocks it seals.
+type ProposeMsg struct {
+	Address   string `json:"address"`
+	Authorize bool   `json:"authorize"`
+	Discard   bool   `json:"discard"`
+	// hex public key of Address, needed to authorize it
+	Key string `json:"key,omitempty"`
+}
+
+// Validate rejects authorizing a candidate without a well-formed key.
+func (msg *ProposeMsg) Validate() error {
+	if msg.Authorize && !msg.Discard {
+		if _, err := publicKey(msg.Key); err != nil {
+			return fmt.Errorf("candidate %s: %w", msg.Address, err)
+		}
+	}
+	return nil
+}
+
+// KeyMsg answers the key operation with the public key the node seals with.
+type KeyMsg struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+type RequestMsg struct {
+	Timestamp   int64                `json:"timestamp"`
+	ClientID    string               `json:"clientID"`
+	Operation   string               `json:"operation"`
+	Transaction *account.Transaction `json:"transaction,omitempty"`
+}
+
+// Validate rejects transactions whose signature does not hold.
+func (msg *RequestMsg) Validate() error {
+	return msg.Transaction.Validate()
+}
+
+// Extra is put in the block header, the signer is the block proposer.
+type Extra struct {
+	Difficulty int    `json:"difficulty"`
+	Candidate  string `json:"candidate"`
+	Authorize  bool   `json:"authorize"`
+	// hex public key of an authorized candidate
+	CandidateKey string `json:"candidateKey,omitempty"`
+}
+
+type ClientMsg struct {
+	Operation   string
+	Transaction *account.Transaction
+	Delay       int64
+}
+
+const (
+	DiffNoTurn = 1 // out-of-turn signer
+	DiffInTurn = 2 // in-turn signer, preferred over an out-of-turn block at the same height
+)
+
+// Type IDs of the poa messages on the wire.
+func init() {
+	codec.Register(0x0301, 2, GenesisMsg{})
+	codec.Register(0x0302, 2, ProposeMsg{})
+	codec.Register(0x0303, 1, RequestMsg{})
+	codec.Register(0x0304, 1, ClientMsg{})
+	codec.Register(0x0305, 1, KeyMsg{})
+}
@@ -0,0 +1,495 @@
+package poa
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"github.com/glimmerzcy/bccp/basic/forkchoice"
+	"github.com/glimmerzcy/bccp/basic/gossip"
+	"github.com/glimmerzcy/bccp/basic/ledger"
+	"github.com/glimmerzcy/bccp/basic/mempool"
+	"github.com/glimmerzcy/bccp/basic/node"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"github.com/glimmerzcy/bccp/basic/votingbased"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Node struct {
+	votingbased.Node
+
+	Genesis  *GenesisMsg
+	Snapshot *Snapshot
+	Ledger   ledger.Store
+	// competing chains above the ledger, the one chosen by the rule of the genesis is followed
+	Tree    *forkchoice.Tree
+	Mempool *mempool.Pool
+	State   *account.State
+	// candidate to the proposal to authorize or remove it, the votes this signer puts in its blocks
+	Proposals map[string]*ProposeMsg
+	// seals the blocks of this signer
+	Key *account.Key
+
+	pending map[string]chan int64
+	// extra delay of an out-of-turn signer at the current height
+	wiggle time.Duration
+	mutex  sync.Mutex
+}
+
+const (
+	Period          = time.Millisecond * 500
+	WiggleTime      = time.Millisecond * 200
+	EpochLength     = 30000
+	TickDuration    = time.Millisecond * 10
+	ClientTimeout   = time.Second * 30
+	MaxBlockRequest = 500
+	MaxBlockBytes   = 1 << 20
+	// blocks deeper than PruneDepth below the head are final
+	PruneDepth = 64
+)
+
+func NewNode(id string, sender server.Sender) (*Node, error) {
+	key, err := account.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{
+		Node:      votingbased.Node{Node: *node.NewNode(id, sender)},
+		Mempool:   mempool.New(mempool.DefaultConfig),
+		State:     account.NewState(nil),
+		Proposals: make(map[string]*ProposeMsg),
+		Key:       key,
+		pending:   make(map[string]chan int64),
+	}
+
+	register(node)
+
+	return node, nil
+}
+
+func register(replica *Node) {
+	node.Register(&replica.Node.Node, "genesis", replica.handleGenesis)
+	node.Register(&replica.Node.Node, "propose", replica.handlePropose)
+	node.Register(&replica.Node.Node, "req", replica.handleRequest)
+	replica.Operations[mempool.GossipOperation] = replica.Mempool.HandleTx(replica.Logger, mempool.Derive(toTx))
+	node.Register(&replica.Node.Node, "block", replica.handleBlock)
+	node.RegisterReply(&replica.Node.Node, "client", replica.handleClient)
+	replica.Operations["metrics"] = replica.handleMetrics
+	replica.Operations["key"] = replica.handleKey
+	replica.Control("genesis", "propose")
+}
+
+// Start the sealing timer once the node is registered.
+func (node *Node) Start() {
+	node.Every(TickDuration, node.alarmToSealer)
+}
+
+// Stop ends the sealing timer, then closes the ledger.
+func (node *Node) Stop() {
+	node.Node.Stop()
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.Ledger != nil {
+		node.Ledger.Close()
+	}
+}
+
+// Factory makes poa nodes, it is registered as "poa".
+type Factory struct {
+	Name string
+	// broadcast by gossip instead of to every peer directly, when set
+	Gossip *gossip.Config
+}
+
+func init() {
+	server.RegisterFactory("poa", Factory{Name: "poa"})
+}
+
+func (factory Factory) NewOperator(id string, sender server.Sender) (server.Operator, error) {
+	node, err := NewNode(id, sender)
+	if err != nil {
+		return nil, err
+	}
+	if factory.Gossip != nil {
+		node.UseGossip(*factory.Gossip)
+	}
+	return node, nil
+}
+
+func (node *Node) head() *block.Block {
+	return node.Ledger.Head()
+}
+
+func (node *Node) alarmToSealer() {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.Snapshot != nil && node.readyToSeal() {
+		sealed, err := node.seal()
+		if err == nil {
+			err = node.apply(sealed)
+		}
+		if err != nil {
+			node.Println(err)
+		} else {
+			go node.Broadcast(node.ID, "block", sealed)
+		}
+	}
+}
+
+// readyToSeal tells if the node may seal the next block now:
+// the in-turn signer seals one Period after the parent, others wait an extra random wiggle.
+func (node *Node) readyToSeal() bool {
+	height := node.head().Height + 1
+	if !node.Snapshot.IsSigner(node.ID) || node.Snapshot.RecentlySigned(height, node.ID) {
+		return false
+	}
+	delay := Period
+	if !node.Snapshot.InTurn(height, node.ID) {
+		delay += node.wiggle
+	}
+	return !time.Now().Before(time.UnixMilli(node.head().Timestamp).Add(delay))
+}
+
+func (node *Node) resetWiggle() {
+	node.wiggle = time.Duration(rand.Int63n(int64(len(node.Snapshot.Signers)/2+1))*int64(WiggleTime)) + WiggleTime
+}
+
+func (node *Node) seal() (*block.Block, error) {
+	height := node.head().Height + 1
+	txs := mempool.Payloads(node.Mempool.Batch(MaxBlockRequest, MaxBlockBytes))
+
+	extra := &Extra{Difficulty: DiffNoTurn}
+	if node.Snapshot.InTurn(height, node.ID) {
+		extra.Difficulty = DiffInTurn
+	}
+	// Put one of the still meaningful proposals into the block.
+	candidates := make([]string, 0, len(node.Proposals))
+	for candidate := range node.Proposals {
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	for _, candidate := range candidates {
+		if proposal := node.Proposals[candidate]; node.Snapshot.ValidVote(candidate, proposal.Authorize) {
+			extra.Candidate, extra.Authorize = candidate, proposal.Authorize
+			if proposal.Authorize {
+				extra.CandidateKey = proposal.Key
+			}
+			break
+		}
+	}
+
+	sealed, err := block.NewBlock(node.head(), time.Now().UnixMilli(), node.ID, txs, extra)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Certificate = &block.Certificate{
+		Sequence:   sealed.Height,
+		Digest:     sealed.Hash,
+		Signers:    []string{node.ID},
+		Signatures: []string{hex.EncodeToString(ed25519.Sign(node.Key.PrivateKey, []byte(sealed.Hash)))},
+	}
+	return sealed, nil
+}
+
+func (node *Node) verify(parent *block.Block, snapshot *Snapshot, sealed *block.Block, extra *Extra) error {
+	if err := sealed.Verify(parent); err != nil {
+		return err
+	}
+	if sealed.Timestamp < parent.Timestamp+Period.Milliseconds() {
+		return errors.New("block is sealed too early")
+	}
+	if !snapshot.IsSigner(sealed.Proposer) {
+		return errors.New("block is sealed by an unauthorized signer")
+	}
+	if err := snapshot.VerifySeal(sealed); err != nil {
+		return err
+	}
+	if snapshot.RecentlySigned(sealed.Height, sealed.Proposer) {
+		return errors.New("signer has sealed a recent block")
+	}
+	difficulty := DiffNoTurn
+	if snapshot.InTurn(sealed.Height, sealed.Proposer) {
+		difficulty = DiffInTurn
+	}
+	if extra.Difficulty != difficulty {
+		return errors.New("block difficulty does not match the turn")
+	}
+	return nil
+}
+
+// apply adds sealed to the block tree and follows the chain chosen by the fork-choice rule,
+// by difficulty a block sealed in turn replaces its out-of-turn siblings.
+func (node *Node) apply(sealed *block.Block) error {
+	if err := node.Tree.Add(sealed); err != nil {
+		return err
+	}
+	return node.follow()
+}
+
+// follow moves the ledger to the head of the tree, the blocks failing verification are removed from the tree.
+func (node *Node) follow() error {
+	var rejected error
+	for node.Tree.Head().Hash != node.head().Hash {
+		reorg, err := node.Tree.Path(node.head().Hash, node.Tree.Head().Hash)
+		if err != nil {
+			return err
+		}
+		bad, err := node.switchTo(reorg)
+		if err == nil {
+			node.Tree.Metrics.Record(reorg)
+			if reorg.Depth > 0 {
+				node.Printf("Reorganized %d blocks at height %d to %s", reorg.Depth, reorg.Ancestor.Height, reorg.NewHead.Hash)
+			}
+			node.Tree.Prune(node.head().Height - PruneDepth)
+			return rejected
+		}
+		rejected = err
+		node.Println("block", bad.Hash, "is rejected:", err)
+		if err = node.Tree.Remove(bad.Hash); err != nil {
+			return err
+		}
+	}
+	return rejected
+}
+
+// switchTo rolls the ledger back to the common ancestor and applies the new chain,
+// returning the first block that can not be applied.
+func (node *Node) switchTo(reorg *forkchoice.Reorg) (*block.Block, error) {
+	if reorg.Depth > 0 {
+		if err := node.Ledger.Truncate(reorg.Ancestor.Height); err != nil {
+			return reorg.NewHead, err
+		}
+		node.rebuild()
+		// Return the requests of the dropped blocks to the mempool.
+		txs := make([]*mempool.Tx, 0)
+		for _, dropped := range reorg.Dropped {
+			for _, raw := range dropped.Transactions {
+				var req RequestMsg
+				if err := json.Unmarshal(raw, &req); err != nil {
+					continue
+				}
+				if tx, err := toTx(&req); err == nil {
+					txs = append(txs, tx)
+				}
+			}
+		}
+		node.Mempool.Restore(txs...)
+	}
+
+	for _, sealed := range reorg.Added {
+		var extra Extra
+		if err := sealed.DecodeExtra(&extra); err != nil {
+			return sealed, err
+		}
+		if err := node.verify(node.head(), node.Snapshot, sealed, &extra); err != nil {
+			return sealed, err
+		}
+		if err := node.Ledger.Append(sealed); err != nil {
+			return sealed, err
+		}
+		node.Snapshot.Apply(sealed, &extra)
+		node.execute(sealed)
+		node.resetWiggle()
+
+		hashes := make([]string, 0)
+		for _, tx := range sealed.Transactions {
+			key, _ := digest(tx)
+			hashes = append(hashes, key)
+			if ch, ok := node.pending[key]; ok {
+				ch <- time.Now().UnixMicro()
+				delete(node.pending, key)
+			}
+		}
+		node.Mempool.Remove(hashes...)
+
+		node.Printf("Block applied: height %d, signer %s, difficulty %d, %d transactions",
+			sealed.Height, sealed.Proposer, extra.Difficulty, len(sealed.Transactions))
+	}
+	return nil, nil
+}
+
+func difficultyOf(sealed *block.Block) int {
+	var extra Extra
+	if err := sealed.DecodeExtra(&extra); err != nil {
+		return 0
+	}
+	return extra.Difficulty
+}
+
+// rebuild replays the chain from genesis to recover the snapshot and the account state of the head.
+func (node *Node) rebuild() {
+	node.Snapshot = NewSnapshot(node.Genesis.Signers, node.Genesis.Keys)
+	node.State = account.NewState(node.Genesis.Alloc)
+	err := node.Ledger.Range(1, node.Ledger.Height(), func(sealed *block.Block) bool {
+		var extra Extra
+		_ = sealed.DecodeExtra(&extra)
+		node.Snapshot.Apply(sealed, &extra)
+		node.execute(sealed)
+		return true
+	})
+	if err != nil {
+		node.Println(err)
+	}
+}
+
+// toTx wraps a request for the mempool, a transaction is ordered by the account nonce and prioritized by fee.
+func toTx(msg *RequestMsg) (*mempool.Tx, error) {
+	if tx := msg.Transaction; tx != nil {
+		return tx.ToMempool(msg)
+	}
+	return mempool.NewTx(msg.ClientID, msg.Timestamp, 0, msg)
+}
+
+// execute applies the transactions carried by the requests of b, the invalid ones are skipped.
+func (node *Node) execute(b *block.Block) {
+	for _, raw := range b.Transactions {
+		var msg RequestMsg
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.Transaction == nil {
+			continue
+		}
+		if err := node.State.Apply(msg.Transaction, b.Proposer); err != nil {
+			node.Println("transaction", msg.Transaction.Hash(), "is rejected:", err)
+		}
+	}
+}
+
+func (node *Node) StartRequest(operation string, transaction *account.Transaction) (int64, error) {
+	msg := &RequestMsg{
+		ClientID:    node.ID,
+		Operation:   operation,
+		Transaction: transaction,
+		Timestamp:   time.Now().UnixNano(),
+	}
+	tx, err := toTx(msg)
+	if err != nil {
+		return -1, err
+	}
+	key := tx.Hash
+	start := time.Now().UnixMicro()
+	done := make(chan int64, 1)
+
+	node.mutex.Lock()
+	node.pending[key] = done
+	node.mutex.Unlock()
+
+	if errs := node.Mempool.Submit(node, node.ID, tx); len(errs) != 0 {
+		node.mutex.Lock()
+		delete(node.pending, key)
+		node.mutex.Unlock()
+		return -1, errs[0]
+	}
+
+	select {
+	case end := <-done:
+		node.Printf("Request Finished! Delay: %d", end-start)
+		return end - start, nil
+	case <-time.After(ClientTimeout):
+		node.mutex.Lock()
+		delete(node.pending, key)
+		node.mutex.Unlock()
+		return -1, errors.New("request is not included before timeout")
+	}
+}
+
+func (node *Node) handleGenesis(_ string, msg *GenesisMsg) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	genesis, err := block.Genesis(msg.Timestamp, msg)
+	if err != nil {
+		return err
+	}
+	// Keep the current ledger if the one of the new genesis can not be opened.
+	store, err := ledger.ForNode(node.ID, genesis)
+	if err != nil {
+		return err
+	}
+	if node.Ledger != nil {
+		node.Ledger.Close()
+	}
+	node.Ledger = store
+	node.Genesis = msg
+	// Recover the signers from a resumed ledger.
+	node.rebuild()
+	var rule forkchoice.Rule = forkchoice.HeaviestWork{}
+	if msg.ForkChoice != "" {
+		rule = forkchoice.RuleByName(msg.ForkChoice)
+	}
+	node.Tree = forkchoice.NewTree(node.head(), rule)
+	node.Tree.Work = func(sealed *block.Block) uint64 {
+		return uint64(difficultyOf(sealed))
+	}
+	node.resetWiggle()
+	node.Println("genesis signers:", node.Snapshot.Signers)
+	return nil
+}
+
+func (node *Node) handlePropose(_ string, msg *ProposeMsg) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if msg.Discard {
+		delete(node.Proposals, msg.Address)
+		return nil
+	}
+	node.Proposals[msg.Address] = msg
+	return nil
+}
+
+// handleKey answers the public key this node seals with, for the genesis to list it.
+func (node *Node) handleKey(writer http.ResponseWriter, _ *http.Request) {
+	jsonMessage, _ := json.Marshal(KeyMsg{ID: node.ID, Key: hex.EncodeToString(node.Key.PublicKey)})
+	writer.Write(jsonMessage)
+}
+
+func (node *Node) handleRequest(_ string, msg *RequestMsg) error {
+	tx, err := toTx(msg)
+	if err != nil {
+		return err
+	}
+	for _, err := range node.Mempool.Submit(node, node.ID, tx) {
+		node.Println(err)
+	}
+	return nil
+}
+
+func (node *Node) handleBlock(_ string, msg *block.Block) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.Snapshot == nil {
+		return errors.New("block received before genesis")
+	}
+	return node.apply(msg)
+}
+
+func (node *Node) handleClient(_ string, msg *ClientMsg) (*ClientMsg, error) {
+	delay, err := node.StartRequest("Test", msg.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	msg.Delay = delay
+	return msg, nil
+}
+
+func (node *Node) handleMetrics(writer http.ResponseWriter, _ *http.Request) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.Tree == nil {
+		node.Println("metrics requested before genesis")
+		return
+	}
+	jsonMessage, _ := json.Marshal(node.Tree.Metrics)
+	writer.Write(jsonMessage)
+}
+
+func digest(object interface{}) (string, error) {
+	msg, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	return node.Hash(msg), nil
+}
@@ -0,0 +1,91 @@
+package poa
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/glimmerzcy/bccp/basic/account"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"github.com/glimmerzcy/bccp/basic/server"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain runs in a temporary directory, nodes write their logs to the working directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "poa")
+	if err != nil {
+		panic(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestSealIsVerified(t *testing.T) {
+	// The nodes are not started, so they only apply the blocks sent to them.
+	memory := server.NewMemory(nil)
+	nodes := make(map[string]*Node)
+	keys := make(map[string]string)
+	for _, id := range []string{"node-1", "node-2"} {
+		node, err := NewNode(id, memory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		memory.OperatorTable.Put(id, node)
+		nodes[id], keys[id] = node, hex.EncodeToString(node.Key.PublicKey)
+		defer node.Stop()
+	}
+	genesis := GenesisMsg{Timestamp: time.Now().Add(-time.Second).UnixMilli(), Signers: []string{"node-1", "node-2"}, Keys: keys}
+	for id := range nodes {
+		resp, err := memory.Send("center", id, "genesis", genesis)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("genesis answered %d", resp.StatusCode)
+		}
+	}
+
+	// node-2 is in turn at height 1.
+	parent := nodes["node-1"].head()
+	sealed, err := block.NewBlock(parent, parent.Timestamp+Period.Milliseconds(), "node-2", make([]json.RawMessage, 0), &Extra{Difficulty: DiffInTurn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal := func(key ed25519.PrivateKey) *block.Block {
+		signed := *sealed
+		signed.Certificate = &block.Certificate{
+			Digest:     sealed.Hash,
+			Signers:    []string{"node-2"},
+			Signatures: []string{hex.EncodeToString(ed25519.Sign(key, []byte(sealed.Hash)))},
+		}
+		return &signed
+	}
+	forger, err := account.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name   string
+		sealed *block.Block
+		code   int
+		height int64
+	}{
+		{"forged", seal(forger.PrivateKey), http.StatusUnprocessableEntity, 0},
+		{"sealed by the signer", seal(nodes["node-2"].Key.PrivateKey), http.StatusOK, 1},
+	} {
+		resp, err := memory.Send("node-2", "node-1", "block", c.sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != c.code || nodes["node-1"].head().Height != c.height {
+			t.Fatalf("%s block answered %d at height %d", c.name, resp.StatusCode, nodes["node-1"].head().Height)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package poa
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"github.com/glimmerzcy/bccp/basic/block"
+	"github.com/glimmerzcy/bccp/basic/votingbased"
+	"sort"
+)
+
+// Snapshot is the authorization state after some block.
+type Snapshot struct {
+	Signers []string
+	// "+id" or "-id" to the signers voting for it
+	Votes map[string]*votingbased.Tally
+	// signer to the height of the last block it sealed
+	Recents map[string]int64
+	// signer to the hex public key its blocks are sealed with
+	Keys map[string]string
+}
+
+func NewSnapshot(signers []string, keys map[string]string) *Snapshot {
+	snapshot := &Snapshot{
+		Signers: make([]string, len(signers)),
+		Votes:   make(map[string]*votingbased.Tally),
+		Recents: make(map[string]int64),
+		Keys:    make(map[string]string, len(signers)),
+	}
+	copy(snapshot.Signers, signers)
+	sort.Strings(snapshot.Signers)
+	for _, signer := range signers {
+		snapshot.Keys[signer] = keys[signer]
+	}
+	return snapshot
+}
+
+func publicKey(hexKey string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("public key is malformed")
+	}
+	return key, nil
+}
+
+// VerifySeal checks the signature of sealed is made with the key of its signer.
+func (snapshot *Snapshot) VerifySeal(sealed *block.Block) error {
+	key, err := publicKey(snapshot.Keys[sealed.Proposer])
+	if err != nil {
+		return err
+	}
+	if sealed.Certificate == nil || len(sealed.Certificate.Signatures) != 1 {
+		return errors.New("block is not sealed")
+	}
+	signature, err := hex.DecodeString(sealed.Certificate.Signatures[0])
+	if err != nil || !ed25519.Verify(key, []byte(sealed.Hash), signature) {
+		return errors.New("block seal is not made by its signer")
+	}
+	return nil
+}
+
+func (snapshot *Snapshot) IsSigner(id string) bool {
+	i := sort.SearchStrings(snapshot.Signers, id)
+	return i < len(snapshot.Signers) && snapshot.Signers[i] == id
+}
+
+func (snapshot *Snapshot) InTurn(height int64, signer string) bool {
+	if len(snapshot.Signers) == 0 {
+		return false
+	}
+	return snapshot.Signers[height%int64(len(snapshot.Signers))] == signer
+}
+
+// RecentlySigned tells if signer sealed one of the last len(Signers)/2 blocks before height,
+// in which case it must leave the block to others.
+func (snapshot *Snapshot) RecentlySigned(height int64, signer string) bool {
+	last, ok := snapshot.Recents[signer]
+	if !ok {
+		return false
+	}
+	limit := int64(len(snapshot.Signers)/2 + 1)
+	return height-last < limit
+}
+
+func (snapshot *Snapshot) ValidVote(candidate string, authorize bool) bool {
+	return candidate != "" && snapshot.IsSigner(candidate) != authorize
+}
+
+func voteKey(candidate string, authorize bool) string {
+	if authorize {
+		return "+" + candidate
+	}
+	return "-" + candidate
+}
+
+// Apply accounts the block sealed by a signer, including the vote it carries.
+func (snapshot *Snapshot) Apply(sealed *block.Block, extra *Extra) {
+	if sealed.Height%EpochLength == 0 {
+		snapshot.Votes = make(map[string]*votingbased.Tally)
+	}
+	snapshot.Recents[sealed.Proposer] = sealed.Height
+
+	if !snapshot.ValidVote(extra.Candidate, extra.Authorize) {
+		return
+	}
+	if _, err := publicKey(extra.CandidateKey); extra.Authorize && err != nil {
+		return
+	}
+	key := voteKey(extra.Candidate, extra.Authorize)
+	// A signer only votes one way for a candidate.
+	if opposite, ok := snapshot.Votes[voteKey(extra.Candidate, !extra.Authorize)]; ok {
+		opposite.Revoke(sealed.Proposer)
+	}
+	tally, ok := snapshot.Votes[key]
+	if !ok {
+		tally = votingbased.NewTally()
+		snapshot.Votes[key] = tally
+	}
+	tally.Cast(votingbased.Ballot{Voter: sealed.Proposer, Candidates: []string{key}})
+
+	if tally.Count(votingbased.Equal)[key] <= int64(len(snapshot.Signers)/2) {
+		return
+	}
+	delete(snapshot.Votes, voteKey(extra.Candidate, true))
+	delete(snapshot.Votes, voteKey(extra.Candidate, false))
+	if extra.Authorize {
+		snapshot.Signers = append(snapshot.Signers, extra.Candidate)
+		sort.Strings(snapshot.Signers)
+		snapshot.Keys[extra.Candidate] = extra.CandidateKey
+		return
+	}
+	signers := make([]string, 0, len(snapshot.Signers))
+	for _, signer := range snapshot.Signers {
+		if signer != extra.Candidate {
+			signers = append(signers, signer)
+		}
+	}
+	snapshot.Signers = signers
+	delete(snapshot.Recents, extra.Candidate)
+	delete(snapshot.Keys, extra.Candidate)
+	for _, votes := range snapshot.Votes {
+		votes.Revoke(extra.Candidate)
+	}
+}